@@ -0,0 +1,81 @@
+package ado
+
+import (
+	"context"
+	"io/fs"
+)
+
+// BaselineState tells how a diagnostic compares with the validation of the same pipeline on the target branch
+type BaselineState string
+
+const (
+	// BaselineNew is set for diagnostics introduced by the changes
+	BaselineNew BaselineState = "new"
+	// BaselineExisting is set for diagnostics also found on the target branch. They do not fail validation.
+	BaselineExisting BaselineState = "existing"
+	// BaselineFixed is set for diagnostics found on the target branch but no longer with the changes
+	BaselineFixed BaselineState = "fixed"
+)
+
+// WithBaseline also validates the affected pipelines on the target branch, which is the pull request's target branch
+// in PR mode and the compared branch otherwise, and fails validation only on diagnostics the changes introduce
+func WithBaseline() ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		c.baseline = true
+		return nil
+	}
+}
+
+// validatePipelinesOnBranch validates the pipelines with the files of the given branch, without any changes
+func (c ValidationClient) validatePipelinesOnBranch(ctx context.Context, pipes []Pipeline, branch string, sources fs.FS) *Report {
+	return c.validatePipelines(ctx, pipes, func(ctx context.Context, pipeline Pipeline) (ValidationResult, error) {
+		return c.validatePipelineOnBranch(ctx, pipeline, branch, sources)
+	})
+}
+
+// ApplyBaseline classifies the diagnostics of every pipeline as new or existing by comparing them with the diagnostics
+// of the same pipeline in the baseline report, records the baseline diagnostics that are gone as fixed and updates the
+// pipeline statuses accordingly. Diagnostics are compared by file, rule and message, as changes can move them to other
+// lines. Errors that are not diagnostics, such as failed calls, are always kept.
+func (r *Report) ApplyBaseline(baseline *Report) {
+	baselines := make(map[string]PipelineReport, len(baseline.Results))
+	for _, result := range baseline.Results {
+		baselines[result.key()] = result
+	}
+
+	for i := range r.Results {
+		result := &r.Results[i]
+
+		// Diagnostics are counted, so a finding that is repeated more often than on the baseline is new
+		remaining := make(map[string]int)
+		baselineResult := baselines[result.key()]
+		for _, d := range baselineResult.Diagnostics {
+			remaining[d.baselineKey()]++
+		}
+
+		for j := range result.Diagnostics {
+			d := &result.Diagnostics[j]
+			d.Baseline = BaselineNew
+			if remaining[d.baselineKey()] > 0 {
+				remaining[d.baselineKey()]--
+				d.Baseline = BaselineExisting
+			}
+		}
+
+		result.Fixed = nil
+		for _, d := range baselineResult.Diagnostics {
+			if remaining[d.baselineKey()] > 0 {
+				remaining[d.baselineKey()]--
+				d.Baseline = BaselineFixed
+				result.Fixed = append(result.Fixed, d)
+			}
+		}
+
+		result.updateStatus()
+	}
+}
+
+// baselineKey identifies a diagnostic independently of its location within the file
+func (d Diagnostic) baselineKey() string {
+	return normalizePipelinePath(d.File) + "\x00" + d.Rule + "\x00" + d.Message
+}
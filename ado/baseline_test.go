@@ -0,0 +1,132 @@
+package ado
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyBaseline(t *testing.T) {
+	diagnostic := func(line int, message string) Diagnostic {
+		return Diagnostic{File: "/ci.yml", Line: line, Message: message, Severity: SeverityError, Rule: RulePreviewUnexpectedValue}
+	}
+
+	tests := []struct {
+		name string
+		// result is the pipeline validated with the changes, baseline the same pipeline on the target branch, if any
+		result     PipelineReport
+		baseline   *PipelineReport
+		wantStates []BaselineState
+		wantFixed  []string
+		wantStatus ValidationStatus
+	}{
+		{
+			name:       "new diagnostic",
+			result:     PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			baseline:   &PipelineReport{PipelineId: 1, Path: "/ci.yml"},
+			wantStates: []BaselineState{BaselineNew},
+			wantStatus: StatusFailed,
+		},
+		{
+			name:       "existing diagnostic on another line",
+			result:     PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{diagnostic(5, "unexpected value 'x'")}},
+			baseline:   &PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			wantStates: []BaselineState{BaselineExisting},
+			wantStatus: StatusPassed,
+		},
+		{
+			name:       "fixed diagnostic",
+			result:     PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{diagnostic(5, "unexpected value 'y'")}},
+			baseline:   &PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			wantStates: []BaselineState{BaselineNew},
+			wantFixed:  []string{"unexpected value 'x'"},
+			wantStatus: StatusFailed,
+		},
+		{
+			name: "duplicate message found more often than on the baseline",
+			result: PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{
+				diagnostic(3, "unexpected value 'x'"),
+				diagnostic(7, "unexpected value 'x'"),
+			}},
+			baseline:   &PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			wantStates: []BaselineState{BaselineExisting, BaselineNew},
+			wantStatus: StatusFailed,
+		},
+		{
+			name:   "duplicate message found less often than on the baseline",
+			result: PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			baseline: &PipelineReport{PipelineId: 1, Path: "/ci.yml", Diagnostics: []Diagnostic{
+				diagnostic(3, "unexpected value 'x'"),
+				diagnostic(7, "unexpected value 'x'"),
+			}},
+			wantStates: []BaselineState{BaselineExisting},
+			wantFixed:  []string{"unexpected value 'x'"},
+			wantStatus: StatusPassed,
+		},
+		{
+			name:       "pipeline missing from the baseline report",
+			result:     PipelineReport{PipelineId: 2, Path: "/new.yml", Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			wantStates: []BaselineState{BaselineNew},
+			wantStatus: StatusFailed,
+		},
+		{
+			name: "other parameter combination",
+			result: PipelineReport{PipelineId: 1, Path: "/ci.yml", Parameters: map[string]string{"debug": "true"},
+				Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			baseline: &PipelineReport{PipelineId: 1, Path: "/ci.yml", Parameters: map[string]string{"debug": "false"},
+				Diagnostics: []Diagnostic{diagnostic(3, "unexpected value 'x'")}},
+			wantStates: []BaselineState{BaselineNew},
+			wantStatus: StatusFailed,
+		},
+		{
+			name:       "errors that are not diagnostics are kept",
+			result:     PipelineReport{PipelineId: 1, Path: "/ci.yml", Error: "failed to call Azure DevOps"},
+			baseline:   &PipelineReport{PipelineId: 1, Path: "/ci.yml", Error: "failed to call Azure DevOps"},
+			wantStatus: StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := &Report{Results: []PipelineReport{tt.result}}
+			baseline := &Report{}
+			if tt.baseline != nil {
+				baseline.Results = append(baseline.Results, *tt.baseline)
+			}
+
+			report.ApplyBaseline(baseline)
+			got := report.Results[0]
+
+			var states []BaselineState
+			for _, d := range got.Diagnostics {
+				states = append(states, d.Baseline)
+			}
+			if !reflect.DeepEqual(states, tt.wantStates) {
+				t.Errorf("got states %v, want %v", states, tt.wantStates)
+			}
+
+			var fixed []string
+			for _, d := range got.Fixed {
+				if d.Baseline != BaselineFixed {
+					t.Errorf("fixed diagnostic %v has state %q, want %q", d, d.Baseline, BaselineFixed)
+				}
+				fixed = append(fixed, d.Message)
+			}
+			if !reflect.DeepEqual(fixed, tt.wantFixed) {
+				t.Errorf("got fixed %v, want %v", fixed, tt.wantFixed)
+			}
+
+			if got.Status != tt.wantStatus {
+				t.Errorf("got status %s, want %s", got.Status, tt.wantStatus)
+			}
+
+			// The baseline report itself is left as it is
+			for _, result := range baseline.Results {
+				for _, d := range result.Diagnostics {
+					if d.Baseline != "" {
+						t.Errorf("baseline diagnostic %v has state %q, want it unchanged", d, d.Baseline)
+					}
+				}
+			}
+		})
+	}
+}
@@ -2,42 +2,63 @@ package ado
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/git"
-	"github.com/microsoft/azure-devops-go-api/azuredevops/pipelines"
-	"io"
-	"log"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
-func (c ValidationClient) getPullRequestChangedYamlFiles(pullRequestId int) ([]string, error) {
+// getPullRequestChangedYamlFiles returns the YAML files the pull request adds or edits, including the new paths of
+// renamed files, and separately the YAML files it deletes or renames. The changes of the latest iteration are compared
+// with the common commit of the source and target branches, so they cover the whole pull request.
+func (c ValidationClient) getPullRequestChangedYamlFiles(pullRequestId int) ([]string, []removedFile, error) {
 	ctx := context.Background()
 
-	gitClient, err := git.NewClient(ctx, c.environment.connection)
+	iterationId, err := c.getLatestIterationId(ctx, pullRequestId)
 	if err != nil {
-		return nil, fmt.Errorf("getPullRequestChangedYamlFiles: failed to create git client: %w", err)
+		return nil, nil, fmt.Errorf("getPullRequestChangedYamlFiles: %w", err)
 	}
 
 	var changedYamlFiles []string
-	params := git.GetPullRequestIterationChangesArgs{
-		Project:       Pointer(c.environment.project),
-		RepositoryId:  Pointer(c.environment.repositoryId),
-		PullRequestId: Pointer(pullRequestId),
-		Top:           Pointer(1000),
-	}
+	var removedYamlFiles []removedFile
+	top, skip := 1000, 0
 
 	for {
-		changes, err := gitClient.GetPullRequestIterationChanges(ctx, params)
-		if err != nil {
-			return nil, err
+		query := url.Values{}
+		query.Set("$top", strconv.Itoa(top))
+		query.Set("$skip", strconv.Itoa(skip))
+		query.Set("$compareTo", "0")
+		changesUrl := c.environment.projectApiUrl(fmt.Sprintf("git/repositories/%s/pullRequests/%d/iterations/%d/changes", url.PathEscape(c.environment.repositoryId), pullRequestId, iterationId), query)
+
+		var changes git.GitPullRequestIterationChanges
+		if err := c.environment.sendRequest(ctx, c.environment.sdkClient(), http.MethodGet, changesUrl, nil, &changes); err != nil {
+			return nil, nil, fmt.Errorf("getPullRequestChangedYamlFiles: failed to get changes: %w", err)
+		}
+		if changes.ChangeEntries == nil {
+			break
 		}
 
-		changeEntries := changes.ChangeEntries
-		for _, change := range *changeEntries {
-			if change.SourceServerItem != nil && (filepath.Ext(*change.SourceServerItem) == ".yaml" || filepath.Ext(*change.SourceServerItem) == ".yml") && *change.ChangeType != "Delete" {
-				changedYamlFiles = append(changedYamlFiles, *change.SourceServerItem)
+		for _, change := range *changes.ChangeEntries {
+			file := changePath(change)
+			switch {
+			case hasChangeType(change, git.VersionControlChangeTypeValues.Delete):
+				if isYamlFile(file) {
+					removedYamlFiles = append(removedYamlFiles, removedFile{path: file})
+				}
+			case hasChangeType(change, git.VersionControlChangeTypeValues.Rename) && change.OriginalPath != nil:
+				if isYamlFile(*change.OriginalPath) {
+					removedYamlFiles = append(removedYamlFiles, removedFile{path: *change.OriginalPath, renamedTo: file})
+				}
+				if isYamlFile(file) {
+					changedYamlFiles = append(changedYamlFiles, file)
+				}
+			case isYamlFile(file):
+				changedYamlFiles = append(changedYamlFiles, file)
 			}
 		}
 
@@ -45,121 +66,246 @@ func (c ValidationClient) getPullRequestChangedYamlFiles(pullRequestId int) ([]s
 			break
 		}
 
-		params.Skip = changes.NextSkip
-		params.Top = changes.NextTop
+		skip = *changes.NextSkip
+		if changes.NextTop != nil && *changes.NextTop > 0 {
+			top = *changes.NextTop
+		}
+	}
+
+	return changedYamlFiles, removedYamlFiles, nil
+}
+
+// getLatestIterationId returns the ID of the latest iteration of the pull request, which is created by its latest push
+func (c ValidationClient) getLatestIterationId(ctx context.Context, pullRequestId int) (int, error) {
+	iterationsUrl := c.environment.projectApiUrl(fmt.Sprintf("git/repositories/%s/pullRequests/%d/iterations", url.PathEscape(c.environment.repositoryId), pullRequestId), nil)
+
+	var iterations struct {
+		Value []git.GitPullRequestIteration `json:"value"`
+	}
+	if err := c.environment.sendRequest(ctx, c.environment.sdkClient(), http.MethodGet, iterationsUrl, nil, &iterations); err != nil {
+		return 0, fmt.Errorf("getLatestIterationId: failed to get iterations: %w", err)
+	}
+
+	latest := 0
+	for _, iteration := range iterations.Value {
+		if iteration.Id != nil && *iteration.Id > latest {
+			latest = *iteration.Id
+		}
+	}
+	if latest == 0 {
+		return 0, fmt.Errorf("getLatestIterationId: pull request %d has no iterations", pullRequestId)
+	}
+
+	return latest, nil
+}
+
+// changePath returns the path of the changed item. Deleted items only carry their path in the item itself.
+func changePath(change git.GitPullRequestChange) string {
+	if change.SourceServerItem != nil {
+		return *change.SourceServerItem
+	}
+	if item, ok := change.Item.(map[string]any); ok {
+		if path, ok := item["path"].(string); ok {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// hasChangeType checks whether the change is of the given type. Change types are flags and come as a comma separated
+// list, for example "edit, rename".
+func hasChangeType(change git.GitPullRequestChange, changeType git.VersionControlChangeType) bool {
+	if change.ChangeType == nil {
+		return false
+	}
+	for _, t := range strings.Split(string(*change.ChangeType), ",") {
+		if strings.EqualFold(strings.TrimSpace(t), string(changeType)) {
+			return true
+		}
 	}
 
-	return changedYamlFiles, nil
+	return false
+}
+
+// isYamlFile checks whether the path has a YAML file extension
+func isYamlFile(file string) bool {
+	return filepath.Ext(file) == ".yaml" || filepath.Ext(file) == ".yml"
+}
+
+// repositoryPipelines are the pipelines of the validated repository and the templates they use. They are gathered
+// once per run and shared by its checks, as listing the pipelines takes a request per page and building the template
+// graph reads every template.
+type repositoryPipelines struct {
+	pipes []Pipeline
+	// graph holds the templates each pipeline transitively references, nil if there are no sources to read them from
+	graph *templateGraph
 }
 
-// getChangedPipelines returns a list of pipelines that have changed in the given pull request
-func (c ValidationClient) getChangedPipelines(ctx context.Context, changedYamlFiles []string) ([]Pipeline, error) {
-	pipes, err := c.getAllProjectPipelines(ctx)
+// getRepositoryPipelineGraph lists the pipelines of the validated repository and, if sources is given, builds the
+// graph of the templates they reference
+func (c ValidationClient) getRepositoryPipelineGraph(ctx context.Context, sources fs.FS) (*repositoryPipelines, error) {
+	pipes, err := c.getRepositoryPipelines(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("getChangedPipelines: failed to get all pipelines: %w", err)
+		return nil, fmt.Errorf("getRepositoryPipelineGraph: failed to get repository pipelines: %w", err)
 	}
+
+	repo := &repositoryPipelines{pipes: pipes}
+	if sources != nil {
+		graph := buildTemplateGraph(sources, c.environment.project, c.environment.repositoryName, pipes)
+		repo.graph = &graph
+	}
+
+	return repo, nil
+}
+
+// getChangedPipelines returns a list of pipelines affected by the given changed files. A pipeline is affected when its
+// root file changed or, if the template graph is known, any template it transitively references changed. Changed files
+// no pipeline uses are validated through their template host, if one is configured.
+func (c ValidationClient) getChangedPipelines(repo *repositoryPipelines, changedYamlFiles []string) []Pipeline {
 	result := make([]Pipeline, 0)
 
+	changedYamlFiles = c.fileFilter.filter(changedYamlFiles)
 	fileMap := make(map[string]bool)
 
 	for _, yamlFile := range changedYamlFiles {
-		fileMap[yamlFile] = true
+		fileMap[pipelinePathKey(yamlFile)] = true
 	}
 
-	for _, pipeline := range pipes {
-		if _, ok := fileMap[pipeline.FilePath]; ok {
+	for _, pipeline := range repo.pipes {
+		if _, ok := fileMap[pipelinePathKey(pipeline.FilePath)]; ok {
+			result = append(result, pipeline)
+		} else if repo.graph != nil && repo.graph.dependsOnAny(pipeline.Id, fileMap) {
 			result = append(result, pipeline)
 		}
 	}
 
-	return result, nil
-}
+	// Changed templates that none of the pipelines use can still be validated through a template host
+	covered := make(map[string]bool)
+	for _, pipeline := range result {
+		covered[pipelinePathKey(pipeline.FilePath)] = true
+		if repo.graph != nil {
+			for file := range repo.graph.dependencies[pipeline.Id] {
+				covered[pipelinePathKey(file)] = true
+			}
+		}
+	}
+	result = append(result, c.getTemplateHostPipelines(changedYamlFiles, covered)...)
 
-type RestListPipelinesResponse struct {
-	Id            *int                             `json:"id"`
-	Configuration *pipelines.PipelineConfiguration `json:"configuration"`
+	return result
 }
 
-type RestGetPipelineResponse struct {
+// RestBuildDefinitionResponse is a build definition as returned by the Build Definitions list API with all properties
+// included: https://learn.microsoft.com/en-us/rest/api/azure/devops/build/definitions/list?view=azure-devops-rest-7.0
+type RestBuildDefinitionResponse struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
 	Process struct {
+		Type         int    `json:"type"`
 		YamlFilename string `json:"yamlFilename"`
 	} `json:"process"`
+	Repository struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"repository"`
 }
 
-func (c ValidationClient) getAllProjectPipelines(ctx context.Context) ([]Pipeline, error) {
-	url := fmt.Sprintf("%s/%s/_apis/pipelines?api-version=7.0", c.environment.organizationUrl, c.environment.project)
-
-	httpClient := http.DefaultClient
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("getAllProjectPipelines: failed to create request: %w", err)
-	}
-	req.Header.Add("Authorization", c.environment.connection.AuthorizationString)
+// yamlProcessType is the process type of build definitions backed by a YAML file
+const yamlProcessType = 2
 
-	// TODO: Pagination handling
-	response, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("getAllProjectPipelines: failed to get response: %w", err)
-	}
+// definitionFilter holds the server side filters for listing build definitions. Empty fields are not filtered on.
+type definitionFilter struct {
+	// folder is the definition folder, for example \team\ci
+	folder         string
+	repositoryId   string
+	repositoryType string
+}
 
-	body, err := io.ReadAll(response.Body)
-	_ = response.Body.Close()
-	if err != nil {
-		return nil, fmt.Errorf("getAllProjectPipelines: failed to read response body: %w", err)
-	}
+// azureReposType is the repository type of build definitions backed by an Azure Repos Git repository
+const azureReposType = "TfsGit"
 
-	var listResult []RestListPipelinesResponse
-	err = json.Unmarshal(body, &listResult)
-	if err != nil {
-		return nil, fmt.Errorf("getAllProjectPipelines: failed to unmarshal response body: %w", err)
+// getRepositoryPipelines lists the YAML pipelines in the project whose YAML lives in the validated repository, with
+// their YAML file paths. Pipelines of other repositories can have root files with the same paths, which are different
+// files. The Build Definitions API returns the process and repository details in bulk, so this only needs one request
+// per page.
+func (c ValidationClient) getRepositoryPipelines(ctx context.Context) ([]Pipeline, error) {
+	filter := definitionFilter{
+		folder:         c.pipelineFolder,
+		repositoryId:   c.environment.repositoryId,
+		repositoryType: azureReposType,
 	}
 
 	result := make([]Pipeline, 0)
-	resultChan := make(chan Pipeline)
-	for _, pipeline := range listResult {
-		if pipeline.Configuration != nil && *pipeline.Configuration.Type == pipelines.ConfigurationTypeValues.Yaml {
-			go c.getSinglePipeline(ctx, *pipeline.Id, resultChan)
+	continuationToken := ""
+	for {
+		page, nextToken, err := c.listDefinitionsPage(ctx, filter, continuationToken)
+		if err != nil {
+			return nil, fmt.Errorf("getRepositoryPipelines: %w", err)
 		}
-	}
-	for p := range resultChan {
-		result = append(result, p)
+
+		for _, definition := range page {
+			if definition.Process.Type != yamlProcessType || definition.Process.YamlFilename == "" {
+				continue
+			}
+			pipeline := Pipeline{
+				Id:             definition.Id,
+				FilePath:       definition.Process.YamlFilename,
+				RepositoryId:   definition.Repository.Id,
+				RepositoryType: definition.Repository.Type,
+			}
+			// The repository is checked again in case the server did not apply the filter
+			if !c.inRepository(pipeline) {
+				continue
+			}
+			result = append(result, pipeline)
+		}
+
+		if nextToken == "" {
+			break
+		}
+		continuationToken = nextToken
 	}
 
 	return result, nil
 }
 
-func (c ValidationClient) getSinglePipeline(ctx context.Context, pipelineId int, results chan<- Pipeline) {
-	url := fmt.Sprintf("%s/%s/_apis/pipelines/%d?api-version=7.0", c.environment.organizationUrl, c.environment.project, pipelineId)
-
-	httpClient := http.DefaultClient
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		log.Printf("getAllProjectPipelines: failed to create request: %v", err)
-		return
+// listDefinitionsPage gets a single page of YAML build definitions in the project, starting at the given continuation
+// token. The returned token is empty when there are no more pages.
+func (c ValidationClient) listDefinitionsPage(ctx context.Context, filter definitionFilter, continuationToken string) ([]RestBuildDefinitionResponse, string, error) {
+	query := url.Values{}
+	query.Set("includeAllProperties", "true")
+	query.Set("processType", strconv.Itoa(yamlProcessType))
+	query.Set("$top", strconv.Itoa(c.pageSize))
+	if filter.folder != "" {
+		query.Set("path", filter.folder)
 	}
-	req.Header.Add("Authorization", c.environment.connection.AuthorizationString)
-	response, err := httpClient.Do(req)
-	if err != nil {
-		log.Printf("getAllProjectPipelines: failed to get response: %v", err)
-		return
+	if filter.repositoryId != "" {
+		query.Set("repositoryId", filter.repositoryId)
+		query.Set("repositoryType", filter.repositoryType)
+	}
+	if continuationToken != "" {
+		query.Set("continuationToken", continuationToken)
 	}
+	listUrl := c.environment.projectApiUrl("build/definitions", query)
 
-	body, err := io.ReadAll(response.Body)
-	_ = response.Body.Close()
-	var pipelineResult RestGetPipelineResponse
-	err = json.Unmarshal(body, &pipelineResult)
+	var listResult struct {
+		Value []RestBuildDefinitionResponse `json:"value"`
+	}
+	header, err := c.environment.sendRequestWithHeader(ctx, c.environment.sdkClient(), http.MethodGet, listUrl, nil, &listResult)
 	if err != nil {
-		log.Printf("getAllProjectPipelines: failed to unmarshal response body: %v", err)
-		return
+		return nil, "", fmt.Errorf("listDefinitionsPage: failed to list build definitions: %w", err)
 	}
 
-	select {
-	case results <- Pipeline{
-		Id:       pipelineId,
-		FilePath: pipelineResult.Process.YamlFilename,
-	}:
-	case <-ctx.Done():
-		return
+	return listResult.Value, header.Get(azuredevops.HeaderKeyContinuationToken), nil
+}
+
+// inRepository checks whether the YAML of the pipeline lives in the validated repository
+func (c ValidationClient) inRepository(pipeline Pipeline) bool {
+	if pipeline.RepositoryType != "" && !strings.EqualFold(pipeline.RepositoryType, azureReposType) {
+		return false
 	}
 
-}
\ No newline at end of file
+	return strings.EqualFold(pipeline.RepositoryId, c.environment.repositoryId)
+}
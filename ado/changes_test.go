@@ -0,0 +1,284 @@
+package ado
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/git"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newDefinitionsServer serves the given build definitions two per page and records the query of every request
+func newDefinitionsServer(t *testing.T, definitions []RestBuildDefinitionResponse, queries *[]map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/project/_apis/build/definitions" {
+			t.Errorf("got request for %s, want the build definitions", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		query := make(map[string]string)
+		for key := range r.URL.Query() {
+			query[key] = r.URL.Query().Get(key)
+		}
+		*queries = append(*queries, query)
+
+		page := definitions
+		if query["continuationToken"] == "" && len(definitions) > 2 {
+			page = definitions[:2]
+			w.Header().Set("X-MS-ContinuationToken", "next")
+		} else if query["continuationToken"] == "next" {
+			page = definitions[2:]
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"count": len(page), "value": page})
+	}))
+}
+
+// testDefinition returns a build definition with the given YAML file in the given repository
+func testDefinition(id int, yamlFilename string, repositoryId string, repositoryType string) RestBuildDefinitionResponse {
+	definition := RestBuildDefinitionResponse{Id: id}
+	definition.Process.Type = yamlProcessType
+	definition.Process.YamlFilename = yamlFilename
+	definition.Repository.Id = repositoryId
+	definition.Repository.Type = repositoryType
+	return definition
+}
+
+func TestGetRepositoryPipelines(t *testing.T) {
+	designer := testDefinition(4, "", "repo-id", azureReposType)
+	designer.Process.Type = 1
+	definitions := []RestBuildDefinitionResponse{
+		testDefinition(1, "/pipelines/ci.yml", "repo-id", azureReposType),
+		testDefinition(2, "/pipelines/ci.yml", "other-id", azureReposType),
+		testDefinition(3, "/Pipelines/Release.yml", "REPO-ID", azureReposType),
+		designer,
+		testDefinition(5, "/pipelines/ci.yml", "repo-id", "GitHub"),
+	}
+
+	var queries []map[string]string
+	server := newDefinitionsServer(t, definitions, &queries)
+	defer server.Close()
+
+	c := ValidationClient{
+		environment: &AzureDevOpsEnvironment{
+			connection:      NewOauthConnection(server.URL, "token"),
+			organizationUrl: server.URL,
+			project:         "project",
+			repositoryId:    "repo-id",
+			httpClient:      http.DefaultClient,
+		},
+		pageSize:       2,
+		pipelineFolder: `\team`,
+	}
+
+	pipes, err := c.getRepositoryPipelines(context.Background())
+	if err != nil {
+		t.Fatalf("getRepositoryPipelines failed: %v", err)
+	}
+
+	// The server may not apply the filter, so pipelines of other repositories are left out by the client as well
+	var ids []int
+	for _, pipeline := range pipes {
+		ids = append(ids, pipeline.Id)
+	}
+	if !reflect.DeepEqual(ids, []int{1, 3}) {
+		t.Errorf("got pipelines %v, want [1 3]", ids)
+	}
+
+	if len(queries) != 2 {
+		t.Fatalf("got %d requests, want 2 pages", len(queries))
+	}
+	for i, query := range queries {
+		want := map[string]string{
+			"repositoryId":   "repo-id",
+			"repositoryType": azureReposType,
+			"path":           `\team`,
+			"processType":    "2",
+			"$top":           "2",
+		}
+		for key, value := range want {
+			if query[key] != value {
+				t.Errorf("request %d: got %s=%q, want %q", i+1, key, query[key], value)
+			}
+		}
+	}
+	if queries[1]["continuationToken"] != "next" {
+		t.Errorf("got continuation token %q on the second page, want next", queries[1]["continuationToken"])
+	}
+}
+
+func TestGetChangedPipelinesIgnoresCase(t *testing.T) {
+	definitions := []RestBuildDefinitionResponse{
+		testDefinition(1, "/Pipelines/CI.yml", "repo-id", azureReposType),
+		testDefinition(2, "pipelines/release.yml", "repo-id", azureReposType),
+		testDefinition(3, "/pipelines/other.yml", "repo-id", azureReposType),
+	}
+
+	var queries []map[string]string
+	server := newDefinitionsServer(t, definitions, &queries)
+	defer server.Close()
+
+	c := ValidationClient{
+		environment: &AzureDevOpsEnvironment{
+			connection:      NewOauthConnection(server.URL, "token"),
+			organizationUrl: server.URL,
+			project:         "project",
+			repositoryId:    "repo-id",
+			httpClient:      http.DefaultClient,
+		},
+		pageSize: 10,
+	}
+
+	repo, err := c.getRepositoryPipelineGraph(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("getRepositoryPipelineGraph failed: %v", err)
+	}
+	pipes := c.getChangedPipelines(repo, []string{"/pipelines/ci.yml", "/PIPELINES/Release.YML"})
+
+	var ids []int
+	for _, pipeline := range pipes {
+		ids = append(ids, pipeline.Id)
+	}
+	sort.Ints(ids)
+	if !reflect.DeepEqual(ids, []int{1, 2}) {
+		t.Errorf("got pipelines %v, want [1 2]", ids)
+	}
+}
+
+// testChange returns a pull request change of the given type. Deleted items only carry their path in the item itself,
+// so the path is set there when sourceServerItem is empty.
+func testChange(changeType string, path string, sourceServerItem string, originalPath string) git.GitPullRequestChange {
+	change := git.GitPullRequestChange{Item: map[string]any{"path": path}}
+	if changeType != "" {
+		t := git.VersionControlChangeType(changeType)
+		change.ChangeType = &t
+	}
+	if sourceServerItem != "" {
+		change.SourceServerItem = &sourceServerItem
+	}
+	if originalPath != "" {
+		change.OriginalPath = &originalPath
+	}
+	return change
+}
+
+func TestHasChangeType(t *testing.T) {
+	tests := []struct {
+		name       string
+		change     git.GitPullRequestChange
+		changeType git.VersionControlChangeType
+		want       bool
+	}{
+		{"single type", testChange("delete", "/a.yml", "", ""), git.VersionControlChangeTypeValues.Delete, true},
+		{"different type", testChange("edit", "/a.yml", "", ""), git.VersionControlChangeTypeValues.Delete, false},
+		{"combined types", testChange("edit, rename", "/a.yml", "", ""), git.VersionControlChangeTypeValues.Rename, true},
+		{"combined types without spaces", testChange("rename,edit", "/a.yml", "", ""), git.VersionControlChangeTypeValues.Edit, true},
+		{"case", testChange("Delete", "/a.yml", "", ""), git.VersionControlChangeTypeValues.Delete, true},
+		{"prefix of another type", testChange("sourceRename", "/a.yml", "", ""), git.VersionControlChangeTypeValues.Rename, false},
+		{"no type", testChange("", "/a.yml", "", ""), git.VersionControlChangeTypeValues.Delete, false},
+	}
+
+	for _, tt := range tests {
+		if got := hasChangeType(tt.change, tt.changeType); got != tt.want {
+			t.Errorf("%s: hasChangeType(%s) = %v, want %v", tt.name, tt.changeType, got, tt.want)
+		}
+	}
+}
+
+func TestChangePath(t *testing.T) {
+	tests := []struct {
+		name   string
+		change git.GitPullRequestChange
+		want   string
+	}{
+		{"source server item", testChange("edit", "/item.yml", "/source.yml", ""), "/source.yml"},
+		{"item path", testChange("delete", "/item.yml", "", ""), "/item.yml"},
+		{"item without path", git.GitPullRequestChange{Item: map[string]any{"objectId": "abc"}}, ""},
+		{"no item", git.GitPullRequestChange{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := changePath(tt.change); got != tt.want {
+			t.Errorf("%s: changePath() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetPullRequestChangedYamlFiles(t *testing.T) {
+	// The second page is only sent when the first one names where it starts
+	pages := map[string]map[string]any{
+		"0": {
+			"changeEntries": []git.GitPullRequestChange{
+				testChange("edit", "/pipelines/ci.yml", "/pipelines/ci.yml", ""),
+				testChange("add", "/templates/new.yaml", "/templates/new.yaml", ""),
+				testChange("edit", "/README.md", "/README.md", ""),
+				testChange("delete", "/templates/deleted.yml", "", ""),
+				testChange("delete", "/docs/deleted.md", "", ""),
+			},
+			"nextSkip": 5,
+			"nextTop":  5,
+		},
+		"5": {
+			"changeEntries": []git.GitPullRequestChange{
+				testChange("rename", "/pipelines/moved.yml", "/pipelines/moved.yml", "/pipelines/renamed.yml"),
+				testChange("edit, rename", "/templates/steps.yml", "/templates/steps.yml", "/templates/old-steps.yml"),
+				testChange("rename", "/pipelines/ci.txt", "/pipelines/ci.txt", "/pipelines/ci-old.yml"),
+				testChange("rename", "/docs/new.yml", "/docs/new.yml", "/docs/old.md"),
+			},
+		},
+	}
+
+	var skips []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/project/_apis/git/repositories/repo-id/pullRequests/42/iterations":
+			_ = json.NewEncoder(w).Encode(map[string]any{"value": []map[string]any{{"id": 1}, {"id": 3}, {"id": 2}}})
+		case "/project/_apis/git/repositories/repo-id/pullRequests/42/iterations/3/changes":
+			if got := r.URL.Query().Get("$compareTo"); got != "0" {
+				t.Errorf("got $compareTo %q, want the changes of the whole pull request", got)
+			}
+			skip := r.URL.Query().Get("$skip")
+			skips = append(skips, skip)
+			_ = json.NewEncoder(w).Encode(pages[skip])
+		default:
+			t.Errorf("got request for %s, want the iterations or the changes of the latest one", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := ValidationClient{
+		environment: &AzureDevOpsEnvironment{
+			connection:      NewOauthConnection(server.URL, "token"),
+			organizationUrl: server.URL,
+			project:         "project",
+			repositoryId:    "repo-id",
+			httpClient:      http.DefaultClient,
+		},
+	}
+
+	changed, removed, err := c.getPullRequestChangedYamlFiles(42)
+	if err != nil {
+		t.Fatalf("getPullRequestChangedYamlFiles failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(skips, []string{"0", "5"}) {
+		t.Errorf("got pages %v, want [0 5]", skips)
+	}
+	wantChanged := []string{"/pipelines/ci.yml", "/templates/new.yaml", "/pipelines/moved.yml", "/templates/steps.yml", "/docs/new.yml"}
+	if !reflect.DeepEqual(changed, wantChanged) {
+		t.Errorf("got changed files %v, want %v", changed, wantChanged)
+	}
+	wantRemoved := []removedFile{
+		{path: "/templates/deleted.yml"},
+		{path: "/pipelines/renamed.yml", renamedTo: "/pipelines/moved.yml"},
+		{path: "/templates/old-steps.yml", renamedTo: "/templates/steps.yml"},
+		{path: "/pipelines/ci-old.yml", renamedTo: "/pipelines/ci.txt"},
+	}
+	if !reflect.DeepEqual(removed, wantRemoved) {
+		t.Errorf("got removed files %+v, want %+v", removed, wantRemoved)
+	}
+}
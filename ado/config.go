@@ -0,0 +1,243 @@
+package ado
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultConfigFileName is the name of the configuration file, looked up in the working directory and its parents
+const DefaultConfigFileName = ".ado-yaml-validator.yaml"
+
+// Config is the repository level configuration of the validator, read from a YAML file. Command line flags take
+// precedence over it.
+type Config struct {
+	// Organization is the Azure DevOps organization name, or the full URL of organizations outside dev.azure.com
+	Organization string `yaml:"organization"`
+	Project      string `yaml:"project"`
+	Repository   string `yaml:"repository"`
+	// FileFilter limits the changed files that are validated
+	FileFilter `yaml:",inline"`
+	// Concurrency is the maximum number of pipelines validated at the same time
+	Concurrency int `yaml:"concurrency"`
+	// Outputs are the reports written after validation. Defaults to a text report on standard output.
+	Outputs []OutputConfig `yaml:"outputs"`
+	// Rules change the severity of diagnostics by rule. Diagnostics of rules set to off are not reported.
+	Rules map[string]Severity `yaml:"rules"`
+	// TemplateHosts configure the pipelines used to validate templates that no pipeline in the repository uses
+	TemplateHosts []TemplateHost `yaml:"templateHosts"`
+	// Pipelines configure runtime parameter and variable values by pipeline
+	Pipelines []PipelineConfig `yaml:"pipelines"`
+	// BaselineFile is the file listing known diagnostics, relative to the configuration file. Defaults to
+	// .ado-yaml-validator-baseline.yaml next to the configuration file, if it exists.
+	BaselineFile string `yaml:"baselineFile"`
+}
+
+// FileFilter selects changed files by glob patterns. A file is selected if it matches any include pattern, or there
+// are none, and no exclude pattern.
+type FileFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// OutputConfig is a report written after validation
+type OutputConfig struct {
+	Format OutputFormat `yaml:"format"`
+	// File is the file the report is written to, relative to the configuration file. Defaults to standard output.
+	File string `yaml:"file"`
+}
+
+// TemplateHost configures a host pipeline for template files. Templates are previewed inside a wrapper pipeline that
+// inserts the template, sent as a YAML override of the host pipeline.
+type TemplateHost struct {
+	// Templates are glob patterns of template paths in the repository, for example /templates/**/*.yml. ** matches any
+	// number of directories.
+	Templates []string `yaml:"templates"`
+	// PipelineId is the ID of the pipeline the Preview call is made for. Any pipeline in the project the template
+	// repository can be checked out from will do.
+	PipelineId int `yaml:"pipelineId"`
+	// Parameters are passed to the template by the generated wrapper pipeline
+	Parameters map[string]any `yaml:"parameters"`
+	// Wrapper is a pipeline used instead of the generated one. {{template}} in it is replaced with the path of the
+	// template, for example /templates/build.yml, and {{ref}} with the validated branch. The host pipeline's own
+	// repository is not switched to the validated branch, so the wrapper references the template through a repository
+	// resource of the validated repository on {{ref}}.
+	Wrapper string `yaml:"wrapper"`
+}
+
+// FindConfig looks for the configuration file in the given directory and its parents. An empty path is returned if
+// there is none.
+func FindConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("FindConfig: %w", err)
+	}
+
+	for {
+		candidate := filepath.Join(dir, DefaultConfigFileName)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("FindConfig: %w", err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadConfig reads a configuration file
+func LoadConfig(file string) (*Config, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfig: failed to read %s: %w", file, err)
+	}
+
+	// Unknown keys are rejected, so misspelled settings fail instead of being ignored
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("LoadConfig: failed to parse %s: %w", file, err)
+	}
+
+	for i, output := range config.Outputs {
+		format, err := ParseOutputFormat(string(output.Format))
+		if err != nil {
+			return nil, fmt.Errorf("LoadConfig: outputs[%d]: %w", i, err)
+		}
+		config.Outputs[i].Format = format
+		if output.File != "" && !filepath.IsAbs(output.File) {
+			config.Outputs[i].File = filepath.Join(filepath.Dir(file), output.File)
+		}
+	}
+	if config.BaselineFile != "" && !filepath.IsAbs(config.BaselineFile) {
+		config.BaselineFile = filepath.Join(filepath.Dir(file), config.BaselineFile)
+	}
+	// Malformed patterns would never match, which silently disables the setting they are used in
+	if err := validatePathGlobs("include", config.Include); err != nil {
+		return nil, fmt.Errorf("LoadConfig: %w", err)
+	}
+	if err := validatePathGlobs("exclude", config.Exclude); err != nil {
+		return nil, fmt.Errorf("LoadConfig: %w", err)
+	}
+	for i, host := range config.TemplateHosts {
+		if err := validatePathGlobs(fmt.Sprintf("templateHosts[%d].templates", i), host.Templates); err != nil {
+			return nil, fmt.Errorf("LoadConfig: %w", err)
+		}
+	}
+	for i, pipeline := range config.Pipelines {
+		if err := validatePathGlobs(fmt.Sprintf("pipelines[%d].path", i), []string{pipeline.Path}); err != nil {
+			return nil, fmt.Errorf("LoadConfig: %w", err)
+		}
+	}
+	for rule, severity := range config.Rules {
+		if !isKnownRule(rule) {
+			return nil, fmt.Errorf("LoadConfig: unknown rule %s", rule)
+		}
+		if severity != SeverityError && severity != SeverityWarning && severity != SeverityOff {
+			return nil, fmt.Errorf("LoadConfig: invalid severity %s for rule %s, must be error, warning or off", severity, rule)
+		}
+	}
+
+	return &config, nil
+}
+
+// Matches checks whether the filter selects the given file
+func (f FileFilter) Matches(file string) bool {
+	included := len(f.Include) == 0
+	for _, pattern := range f.Include {
+		if matchPathGlob(pattern, file) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, pattern := range f.Exclude {
+		if matchPathGlob(pattern, file) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filter returns the files the filter selects
+func (f FileFilter) filter(files []string) []string {
+	result := make([]string, 0, len(files))
+	for _, file := range files {
+		if f.Matches(file) {
+			result = append(result, file)
+		}
+	}
+
+	return result
+}
+
+// matches checks whether the template host is configured for the given template file
+func (h TemplateHost) matches(file string) bool {
+	for _, pattern := range h.Templates {
+		if matchPathGlob(pattern, file) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validatePathGlobs checks that the glob patterns of a setting are well-formed
+func validatePathGlobs(setting string, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s: invalid pattern %q: %w", setting, pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// matchPathGlob matches a repository path against a glob pattern. Both are treated as relative to the repository root
+// whether they start with a slash or not. A ** segment matches any number of directories.
+func matchPathGlob(pattern string, file string) bool {
+	patternSegments := strings.Split(strings.TrimPrefix(normalizePipelinePath(pattern), "/"), "/")
+	fileSegments := strings.Split(strings.TrimPrefix(normalizePipelinePath(file), "/"), "/")
+
+	return matchSegments(patternSegments, fileSegments)
+}
+
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], segments[0]); err != nil || !ok {
+		return false
+	}
+
+	return matchSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,181 @@
+package ado
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindConfig(t *testing.T) {
+	repoRoot := t.TempDir()
+	nested := filepath.Join(repoRoot, "pipelines", "templates")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := FindConfig(nested)
+	if err != nil {
+		t.Fatalf("FindConfig() error = %v", err)
+	}
+	if got != "" {
+		t.Errorf("FindConfig() = %q without a configuration file, want none", got)
+	}
+
+	configFile := filepath.Join(repoRoot, DefaultConfigFileName)
+	if err := os.WriteFile(configFile, []byte("project: project\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{repoRoot, nested} {
+		got, err := FindConfig(dir)
+		if err != nil {
+			t.Fatalf("FindConfig(%q) error = %v", dir, err)
+		}
+		if got != configFile {
+			t.Errorf("FindConfig(%q) = %q, want %q", dir, got, configFile)
+		}
+	}
+
+	// The closest configuration file wins
+	nestedConfig := filepath.Join(nested, DefaultConfigFileName)
+	if err := os.WriteFile(nestedConfig, []byte("project: nested\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := FindConfig(nested); err != nil || got != nestedConfig {
+		t.Errorf("FindConfig(%q) = %q, %v, want %q", nested, got, err, nestedConfig)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, DefaultConfigFileName)
+	content := `organization: org
+project: project
+repository: repo
+include:
+- /pipelines/**
+exclude:
+- /pipelines/templates/*.yml
+concurrency: 4
+outputs:
+- format: JUnit
+  file: reports/junit.xml
+- format: sarif
+rules:
+  schema-unknown-key: warning
+templateHosts:
+- templates:
+  - /templates/**
+  pipelineId: 7
+baselineFile: baseline.yaml
+`
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	want := &Config{
+		Organization: "org",
+		Project:      "project",
+		Repository:   "repo",
+		FileFilter: FileFilter{
+			Include: []string{"/pipelines/**"},
+			Exclude: []string{"/pipelines/templates/*.yml"},
+		},
+		Concurrency: 4,
+		// Files are relative to the configuration file
+		Outputs: []OutputConfig{
+			{Format: OutputJUnit, File: filepath.Join(dir, "reports", "junit.xml")},
+			{Format: OutputSarif},
+		},
+		Rules:         map[string]Severity{RuleSchemaUnknownKey: SeverityWarning},
+		TemplateHosts: []TemplateHost{{Templates: []string{"/templates/**"}, PipelineId: 7}},
+		BaselineFile:  filepath.Join(dir, "baseline.yaml"),
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("LoadConfig() = %+v, want %+v", config, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown field", content: "projcet: project\n", wantErr: "field projcet not found"},
+		{name: "unknown nested field", content: "templateHosts:\n- template: /templates/*.yml\n", wantErr: "field template not found"},
+		{name: "unknown output format", content: "outputs:\n- format: html\n", wantErr: "outputs[0]"},
+		{name: "unknown rule", content: "rules:\n  no-such-rule: off\n", wantErr: "unknown rule no-such-rule"},
+		{name: "invalid severity", content: "rules:\n  yaml-syntax: fatal\n", wantErr: "invalid severity fatal"},
+		{name: "invalid include", content: "include:\n- /pipelines/[abc\n", wantErr: `include: invalid pattern "/pipelines/[abc"`},
+		{name: "invalid exclude", content: "exclude:\n- templates/[abc\n", wantErr: `exclude: invalid pattern "templates/[abc"`},
+		{name: "invalid template host", content: "templateHosts:\n- templates:\n  - '/templates/\\'\n  pipelineId: 1\n", wantErr: "templateHosts[0].templates: invalid pattern"},
+		{name: "invalid pipeline path", content: "pipelines:\n- path: /ci/[\n", wantErr: "pipelines[0].path: invalid pattern"},
+	}
+
+	for _, tt := range tests {
+		configFile := filepath.Join(t.TempDir(), DefaultConfigFileName)
+		if err := os.WriteFile(configFile, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := LoadConfig(configFile)
+		if err == nil || !strings.HasPrefix(err.Error(), "LoadConfig: ") || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadConfigEmpty(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), DefaultConfigFileName)
+	if err := os.WriteFile(configFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if !reflect.DeepEqual(config, &Config{}) {
+		t.Errorf("LoadConfig() = %+v, want an empty configuration", config)
+	}
+}
+
+func TestMatchPathGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		file    string
+		want    bool
+	}{
+		{pattern: "/pipelines/*.yml", file: "/pipelines/ci.yml", want: true},
+		{pattern: "pipelines/*.yml", file: "/pipelines/ci.yml", want: true},
+		{pattern: "/pipelines/*.yml", file: "pipelines/ci.yml", want: true},
+		{pattern: "/pipelines/*.yml", file: "/pipelines/templates/ci.yml", want: false},
+		{pattern: "/templates/**/*.yml", file: "/templates/build.yml", want: true},
+		{pattern: "/templates/**/*.yml", file: "/templates/build/steps.yml", want: true},
+		{pattern: "/templates/**/*.yml", file: "/templates/build/dotnet/steps.yml", want: true},
+		{pattern: "/templates/**/*.yml", file: "/other/build.yml", want: false},
+		{pattern: "/templates/**", file: "/templates", want: true},
+		{pattern: "/templates/**", file: "/templates/a/b/c.yml", want: true},
+		{pattern: "**/steps.yml", file: "/steps.yml", want: true},
+		{pattern: "**/steps.yml", file: "/templates/build/steps.yml", want: true},
+		{pattern: "**", file: "/azure-pipelines.yml", want: true},
+		{pattern: "/templates/**/build/**/*.yml", file: "/templates/build/steps.yml", want: true},
+		{pattern: "/templates/**/build/**/*.yml", file: "/templates/x/build/y/steps.yml", want: true},
+		{pattern: "/templates/**/build/**/*.yml", file: "/templates/x/steps.yml", want: false},
+		{pattern: "/templates/[bc]uild.yml", file: "/templates/build.yml", want: true},
+		{pattern: "/templates/[abc", file: "/templates/a", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPathGlob(tt.pattern, tt.file); got != tt.want {
+			t.Errorf("matchPathGlob(%q, %q) = %v, want %v", tt.pattern, tt.file, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package ado
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// AzureDevOpsResource is the Microsoft Entra ID application ID of Azure DevOps, which tokens are requested for
+const AzureDevOpsResource = "499b84ac-1321-427f-aa17-267ca6975798"
+
+// DefaultAuthorityHost is the Microsoft Entra ID endpoint federated tokens are exchanged at unless AZURE_AUTHORITY_HOST
+// is set
+const DefaultAuthorityHost = "https://login.microsoftonline.com/"
+
+// ErrNoCredentials is returned by token providers that are not configured, for example because their environment
+// variables are not set
+var ErrNoCredentials = errors.New("no credentials available")
+
+// Token is a credential for Azure DevOps
+type Token struct {
+	Value string
+	// Pat tells whether the token is sent with basic authentication, like personal access tokens, instead of as a
+	// bearer token
+	Pat bool
+	// ExpiresOn is the time the token expires, zero if unknown
+	ExpiresOn time.Time
+}
+
+// TokenProvider gets tokens for Azure DevOps
+type TokenProvider interface {
+	// Name describes the provider in error messages
+	Name() string
+	// Token returns a token, or an error wrapping ErrNoCredentials if the provider is not configured
+	Token(ctx context.Context) (Token, error)
+}
+
+// Token sources that can be selected instead of trying all of them in order
+const (
+	TokenSourceAuto      = "auto"
+	TokenSourceEnv       = "env"
+	TokenSourceFederated = "federated"
+	TokenSourceAzureCli  = "azure-cli"
+	TokenSourceGit       = "git"
+)
+
+// TokenSources are the valid token sources
+var TokenSources = []string{TokenSourceAuto, TokenSourceEnv, TokenSourceFederated, TokenSourceAzureCli, TokenSourceGit}
+
+// NewTokenProvider returns the token provider for the given source. The auto source tries the environment, a
+// federated token, the Azure CLI and the git credential helpers, in that order.
+func NewTokenProvider(source string, organizationUrl string) (TokenProvider, error) {
+	switch strings.ToLower(source) {
+	case TokenSourceAuto, "":
+		return TokenChain{
+			EnvTokenProvider{},
+			NewFederatedTokenProviderFromEnv(),
+			AzureCliTokenProvider{},
+			GitCredentialTokenProvider{OrganizationUrl: organizationUrl},
+		}, nil
+	case TokenSourceEnv:
+		return EnvTokenProvider{}, nil
+	case TokenSourceAzureCli:
+		return AzureCliTokenProvider{}, nil
+	case TokenSourceFederated:
+		return NewFederatedTokenProviderFromEnv(), nil
+	case TokenSourceGit:
+		return GitCredentialTokenProvider{OrganizationUrl: organizationUrl}, nil
+	default:
+		return nil, fmt.Errorf("NewTokenProvider: unknown token source %q, must be one of %s", source, strings.Join(TokenSources, ", "))
+	}
+}
+
+// TokenChain tries its providers in order and returns the first token one of them provides
+type TokenChain []TokenProvider
+
+func (c TokenChain) Name() string {
+	names := make([]string, 0, len(c))
+	for _, provider := range c {
+		names = append(names, provider.Name())
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func (c TokenChain) Token(ctx context.Context) (Token, error) {
+	errs := make([]error, 0, len(c))
+	for _, provider := range c {
+		token, err := provider.Token(ctx)
+		if err == nil {
+			return token, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+	}
+
+	return Token{}, fmt.Errorf("TokenChain: no provider returned a token: %w", errors.Join(errs...))
+}
+
+// StaticTokenProvider returns a token it was given, for example from a flag
+type StaticTokenProvider struct {
+	// Source describes where the token comes from, for example the flag it was given with
+	Source string
+	Value  Token
+}
+
+func (p StaticTokenProvider) Name() string {
+	return p.Source
+}
+
+func (p StaticTokenProvider) Token(ctx context.Context) (Token, error) {
+	if p.Value.Value == "" {
+		return Token{}, ErrNoCredentials
+	}
+
+	return p.Value, nil
+}
+
+// EnvTokenProvider reads a personal access token from AZURE_DEVOPS_EXT_PAT, the variable the Azure DevOps CLI uses, or
+// an access token from SYSTEM_ACCESSTOKEN, which pipelines can map the job access token to
+type EnvTokenProvider struct{}
+
+func (p EnvTokenProvider) Name() string {
+	return "environment"
+}
+
+func (p EnvTokenProvider) Token(ctx context.Context) (Token, error) {
+	if pat := os.Getenv("AZURE_DEVOPS_EXT_PAT"); pat != "" {
+		return Token{Value: pat, Pat: true}, nil
+	}
+	if token := os.Getenv("SYSTEM_ACCESSTOKEN"); token != "" {
+		return Token{Value: token}, nil
+	}
+
+	return Token{}, fmt.Errorf("neither AZURE_DEVOPS_EXT_PAT nor SYSTEM_ACCESSTOKEN is set: %w", ErrNoCredentials)
+}
+
+// JobTokenProvider reads the job access token of a pipeline run from SYSTEM_ACCESSTOKEN, which pipelines map
+// System.AccessToken to. Unlike EnvTokenProvider it ignores personal access tokens, so PR builds always act as the
+// build service.
+type JobTokenProvider struct{}
+
+func (p JobTokenProvider) Name() string {
+	return "System.AccessToken"
+}
+
+func (p JobTokenProvider) Token(ctx context.Context) (Token, error) {
+	if token := os.Getenv("SYSTEM_ACCESSTOKEN"); token != "" {
+		return Token{Value: token}, nil
+	}
+
+	return Token{}, fmt.Errorf("SYSTEM_ACCESSTOKEN is not set, map System.AccessToken to it in the pipeline: %w", ErrNoCredentials)
+}
+
+// AzureCliTokenProvider gets an access token for Azure DevOps from the account logged in to the Azure CLI
+type AzureCliTokenProvider struct{}
+
+func (p AzureCliTokenProvider) Name() string {
+	return "Azure CLI"
+}
+
+func (p AzureCliTokenProvider) Token(ctx context.Context) (Token, error) {
+	az, err := exec.LookPath("az")
+	if err != nil {
+		return Token{}, fmt.Errorf("az is not installed: %w", ErrNoCredentials)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, az, "account", "get-access-token", "--resource", AzureDevOpsResource, "--output", "json")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return Token{}, fmt.Errorf("az account get-access-token failed: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	var response struct {
+		AccessToken string `json:"accessToken"`
+		// ExpiresOnUnix is only returned by newer versions of the Azure CLI
+		ExpiresOnUnix int64 `json:"expires_on"`
+		// ExpiresOn is in local time
+		ExpiresOn string `json:"expiresOn"`
+	}
+	if err := json.Unmarshal(out, &response); err != nil {
+		return Token{}, fmt.Errorf("failed to parse the output of az account get-access-token: %w", err)
+	}
+	if response.AccessToken == "" {
+		return Token{}, fmt.Errorf("az account get-access-token returned no token")
+	}
+
+	token := Token{Value: response.AccessToken}
+	if response.ExpiresOnUnix != 0 {
+		token.ExpiresOn = time.Unix(response.ExpiresOnUnix, 0)
+	} else if expiresOn, err := time.ParseInLocation("2006-01-02 15:04:05.999999", response.ExpiresOn, time.Local); err == nil {
+		token.ExpiresOn = expiresOn
+	}
+
+	return token, nil
+}
+
+// FederatedTokenProvider exchanges a federated token from a file, such as the one of an Azure workload identity, for a
+// Microsoft Entra ID access token for Azure DevOps
+type FederatedTokenProvider struct {
+	// TokenFile is the file holding the federated token, which is read for every exchange as it can be rotated
+	TokenFile string
+	ClientId  string
+	TenantId  string
+	// AuthorityHost is the Microsoft Entra ID endpoint, for example https://login.microsoftonline.com/
+	AuthorityHost string
+}
+
+// NewFederatedTokenProviderFromEnv configures the provider from the environment variables Azure workload identity sets:
+// AZURE_FEDERATED_TOKEN_FILE, AZURE_CLIENT_ID, AZURE_TENANT_ID and optionally AZURE_AUTHORITY_HOST
+func NewFederatedTokenProviderFromEnv() FederatedTokenProvider {
+	authorityHost := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authorityHost == "" {
+		authorityHost = DefaultAuthorityHost
+	}
+
+	return FederatedTokenProvider{
+		TokenFile:     os.Getenv("AZURE_FEDERATED_TOKEN_FILE"),
+		ClientId:      os.Getenv("AZURE_CLIENT_ID"),
+		TenantId:      os.Getenv("AZURE_TENANT_ID"),
+		AuthorityHost: authorityHost,
+	}
+}
+
+func (p FederatedTokenProvider) Name() string {
+	return "federated token"
+}
+
+func (p FederatedTokenProvider) Token(ctx context.Context) (Token, error) {
+	if p.TokenFile == "" || p.ClientId == "" || p.TenantId == "" {
+		return Token{}, fmt.Errorf("AZURE_FEDERATED_TOKEN_FILE, AZURE_CLIENT_ID and AZURE_TENANT_ID must be set: %w", ErrNoCredentials)
+	}
+
+	assertion, err := os.ReadFile(p.TokenFile)
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to read the federated token: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", p.ClientId)
+	form.Set("scope", AzureDevOpsResource+"/.default")
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+
+	tokenUrl := strings.TrimRight(p.AuthorityHost, "/") + "/" + url.PathEscape(p.TenantId) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	requestTime := time.Now()
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Token{}, fmt.Errorf("token request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return Token{}, fmt.Errorf("failed to parse token response with status %d: %w", response.StatusCode, err)
+	}
+	if response.StatusCode != http.StatusOK || body.AccessToken == "" {
+		return Token{}, fmt.Errorf("token request failed with status %d: %s %s", response.StatusCode, body.Error, body.ErrorDescription)
+	}
+
+	token := Token{Value: body.AccessToken}
+	if body.ExpiresIn > 0 {
+		token.ExpiresOn = requestTime.Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
+
+// GitCredentialTokenProvider asks the git credential helpers for the organization's credentials, using the git
+// credential protocol. Git Credential Manager returns a token that Azure DevOps accepts as a password.
+type GitCredentialTokenProvider struct {
+	OrganizationUrl string
+}
+
+func (p GitCredentialTokenProvider) Name() string {
+	return "git credential helper"
+}
+
+func (p GitCredentialTokenProvider) Token(ctx context.Context) (Token, error) {
+	orgUrl, err := url.Parse(p.OrganizationUrl)
+	if err != nil || orgUrl.Host == "" {
+		return Token{}, fmt.Errorf("invalid organization URL %q: %w", p.OrganizationUrl, ErrNoCredentials)
+	}
+
+	request := fmt.Sprintf("protocol=%s\nhost=%s\npath=%s\n\n", orgUrl.Scheme, orgUrl.Host, strings.Trim(orgUrl.Path, "/"))
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", "credential", "fill")
+	cmd.Stdin = strings.NewReader(request)
+	cmd.Stderr = &stderr
+	// Helpers must not prompt, the tool may run without a terminal
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GCM_INTERACTIVE=never")
+	out, err := cmd.Output()
+	if err != nil {
+		return Token{}, fmt.Errorf("git credential fill failed: %s: %w", strings.TrimSpace(stderr.String()), ErrNoCredentials)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if password, ok := strings.CutPrefix(line, "password="); ok && password != "" {
+			return Token{Value: strings.TrimSpace(password), Pat: true}, nil
+		}
+	}
+
+	return Token{}, fmt.Errorf("git credential fill returned no password: %w", ErrNoCredentials)
+}
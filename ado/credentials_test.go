@@ -0,0 +1,182 @@
+package ado
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeTokenProvider returns a fixed token or error and counts how often it is asked
+type fakeTokenProvider struct {
+	name  string
+	token Token
+	err   error
+	calls *int
+}
+
+func (p fakeTokenProvider) Name() string {
+	return p.name
+}
+
+func (p fakeTokenProvider) Token(ctx context.Context) (Token, error) {
+	*p.calls++
+	return p.token, p.err
+}
+
+func TestFederatedTokenProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			t.Errorf("got %s %s, want POST /tenant/oauth2/v2.0/token", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("failed to parse form: %v", err)
+		}
+		want := map[string]string{
+			"grant_type":            "client_credentials",
+			"client_id":             "client",
+			"scope":                 AzureDevOpsResource + "/.default",
+			"client_assertion_type": "urn:ietf:params:oauth:client-assertion-type:jwt-bearer",
+			"client_assertion":      "federated-token",
+		}
+		for key, value := range want {
+			if got := r.PostForm.Get(key); got != value {
+				t.Errorf("form %s = %q, want %q", key, got, value)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"access-token","expires_in":3600}`))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("federated-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := FederatedTokenProvider{TokenFile: tokenFile, ClientId: "client", TenantId: "tenant", AuthorityHost: server.URL + "/"}
+	token, err := provider.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if token.Value != "access-token" || token.Pat {
+		t.Errorf("got token %+v, want bearer token access-token", token)
+	}
+	if until := time.Until(token.ExpiresOn); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s, want an hour", until)
+	}
+}
+
+func TestFederatedTokenProviderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"AADSTS700213: No matching federated identity record found"}`))
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("federated-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := FederatedTokenProvider{TokenFile: tokenFile, ClientId: "client", TenantId: "tenant", AuthorityHost: server.URL}
+	_, err := provider.Token(context.Background())
+	if err == nil {
+		t.Fatal("Token succeeded, want an error")
+	}
+	if errors.Is(err, ErrNoCredentials) {
+		t.Errorf("got %v, want a failed exchange instead of missing credentials", err)
+	}
+}
+
+func TestFederatedTokenProviderNotConfigured(t *testing.T) {
+	_, err := FederatedTokenProvider{AuthorityHost: DefaultAuthorityHost}.Token(context.Background())
+	if !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("got %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestTokenChain(t *testing.T) {
+	var missingCalls, firstCalls, secondCalls int
+	chain := TokenChain{
+		fakeTokenProvider{name: "missing", err: ErrNoCredentials, calls: &missingCalls},
+		fakeTokenProvider{name: "first", token: Token{Value: "first"}, calls: &firstCalls},
+		fakeTokenProvider{name: "second", token: Token{Value: "second"}, calls: &secondCalls},
+	}
+
+	token, err := chain.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token failed: %v", err)
+	}
+	if token.Value != "first" {
+		t.Errorf("got token %q, want the token of the first configured provider", token.Value)
+	}
+	if missingCalls != 1 || firstCalls != 1 || secondCalls != 0 {
+		t.Errorf("providers were asked %d, %d and %d times, want 1, 1 and 0", missingCalls, firstCalls, secondCalls)
+	}
+}
+
+func TestTokenChainNoToken(t *testing.T) {
+	var firstCalls, secondCalls int
+	failed := errors.New("az account get-access-token failed")
+	chain := TokenChain{
+		fakeTokenProvider{name: "first", err: ErrNoCredentials, calls: &firstCalls},
+		fakeTokenProvider{name: "second", err: failed, calls: &secondCalls},
+	}
+
+	_, err := chain.Token(context.Background())
+	if !errors.Is(err, ErrNoCredentials) || !errors.Is(err, failed) {
+		t.Errorf("got %v, want the errors of all providers", err)
+	}
+	if firstCalls != 1 || secondCalls != 1 {
+		t.Errorf("providers were asked %d and %d times, want each once", firstCalls, secondCalls)
+	}
+}
+
+func TestEnvTokenProvider(t *testing.T) {
+	tests := []struct {
+		name        string
+		pat         string
+		accessToken string
+		want        Token
+		wantErr     bool
+	}{
+		{name: "personal access token", pat: "pat", want: Token{Value: "pat", Pat: true}},
+		{name: "access token", accessToken: "access", want: Token{Value: "access"}},
+		{name: "personal access token takes precedence", pat: "pat", accessToken: "access", want: Token{Value: "pat", Pat: true}},
+		{name: "not set", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AZURE_DEVOPS_EXT_PAT", tt.pat)
+			t.Setenv("SYSTEM_ACCESSTOKEN", tt.accessToken)
+
+			token, err := EnvTokenProvider{}.Token(context.Background())
+			if tt.wantErr {
+				if !errors.Is(err, ErrNoCredentials) {
+					t.Errorf("got %v, want ErrNoCredentials", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Token failed: %v", err)
+			}
+			if token != tt.want {
+				t.Errorf("got %+v, want %+v", token, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTokenProviderUnknownSource(t *testing.T) {
+	if _, err := NewTokenProvider("keychain", "https://dev.azure.com/org"); err == nil {
+		t.Error("NewTokenProvider succeeded, want an error for an unknown source")
+	}
+}
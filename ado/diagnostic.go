@@ -0,0 +1,186 @@
+package ado
+
+import (
+	"errors"
+	"fmt"
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	// SeverityOff is only used to configure rules and turns a rule off
+	SeverityOff Severity = "off"
+)
+
+const (
+	// RuleYamlSyntax is reported for files that are not valid YAML
+	RuleYamlSyntax = "yaml-syntax"
+	// RuleSchemaUnknownKey is reported for keys or values the schema does not allow
+	RuleSchemaUnknownKey = "schema-unknown-key"
+	// RuleSchemaType is reported for values of the wrong type
+	RuleSchemaType = "schema-type"
+	// RuleSchemaRequired is reported for missing required properties
+	RuleSchemaRequired = "schema-required"
+	// RuleSchemaValue is reported for values that do not match an allowed value or pattern
+	RuleSchemaValue = "schema-value"
+
+	// RulePreviewUnexpectedValue is reported by the Preview API for unknown keys and values
+	RulePreviewUnexpectedValue = "preview-unexpected-value"
+	// RulePreviewMissingParameter is reported by the Preview API for required parameters without a value
+	RulePreviewMissingParameter = "preview-missing-parameter"
+	// RulePreviewTemplateNotFound is reported by the Preview API for template references that can't be resolved
+	RulePreviewTemplateNotFound = "preview-template-not-found"
+	// RulePreviewError is reported for all other Preview API validation errors
+	RulePreviewError = "preview-error"
+
+	// RuleRemovedFileInUse is reported for pipeline and template files a pull request deletes or renames while
+	// pipelines still use them
+	RuleRemovedFileInUse = "removed-file-in-use"
+)
+
+// isKnownRule checks whether diagnostics can be reported for the given rule
+func isKnownRule(rule string) bool {
+	switch rule {
+	case RuleYamlSyntax, RuleSchemaUnknownKey, RuleSchemaType, RuleSchemaRequired, RuleSchemaValue,
+		RulePreviewUnexpectedValue, RulePreviewMissingParameter, RulePreviewTemplateNotFound, RulePreviewError,
+		RuleRemovedFileInUse:
+		return true
+	default:
+		return false
+	}
+}
+
+// Diagnostic is a single finding in a pipeline or template file
+type Diagnostic struct {
+	File     string
+	Line     int
+	Column   int
+	Message  string
+	Severity Severity
+	Rule     string
+	// Fingerprint identifies the diagnostic independently of its line number, empty if not computed
+	Fingerprint string
+	// Baseline tells whether the finding is new or also found on the target branch, empty unless compared with a
+	// baseline
+	Baseline BaselineState
+}
+
+// String formats the diagnostic the same way Azure DevOps formats pipeline errors
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	}
+
+	return fmt.Sprintf("%s (Line: %d, Col: %d): %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// hasErrors checks whether any of the diagnostics is an error. Errors also found on the baseline are not counted.
+func hasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError && d.Baseline != BaselineExisting {
+			return true
+		}
+	}
+
+	return false
+}
+
+// previewErrorLocation matches the location prefix of a single Preview API error, for example
+// "/templates/build.yml (Line: 12, Col: 5): ". Errors are separated by commas, so a location only starts at the start
+// of the message or after a comma, which keeps paths with spaces in one piece.
+var previewErrorLocation = regexp.MustCompile(`(?:^|,|\n)\s*([^,\s][^,\n]*?) \(Line: (\d+), Col: (\d+)\): `)
+
+// previewErrorFile matches errors that name a file without a location, for example "/templates/build.yml: File not found"
+var previewErrorFile = regexp.MustCompile(`^(/\S+?): (.+)$`)
+
+// parsePreviewError splits a Preview API error into diagnostics. ok is false if the error is not a validation error of
+// the pipeline, for example a network or authorization failure, so it should be reported as is.
+func parsePreviewError(err error, pipelinePath string) (diagnostics []Diagnostic, ok bool) {
+	var wrapped azuredevops.WrappedError
+	var wrappedPtr *azuredevops.WrappedError
+	switch {
+	case errors.As(err, &wrappedPtr) && wrappedPtr != nil:
+		wrapped = *wrappedPtr
+	case errors.As(err, &wrapped):
+	default:
+		return nil, false
+	}
+
+	if wrapped.StatusCode == nil || *wrapped.StatusCode != http.StatusBadRequest || wrapped.Message == nil {
+		return nil, false
+	}
+
+	return parsePreviewErrorMessage(*wrapped.Message, pipelinePath), true
+}
+
+// parsePreviewErrorMessage splits a Preview API error message, which can contain several errors, into diagnostics.
+// Errors without a file are attributed to the pipeline's root file.
+func parsePreviewErrorMessage(message string, pipelinePath string) []Diagnostic {
+	diagnostics := make([]Diagnostic, 0)
+
+	matches := previewErrorLocation.FindAllStringSubmatchIndex(message, -1)
+	for i, match := range matches {
+		end := len(message)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+
+		line, _ := strconv.Atoi(message[match[4]:match[5]])
+		column, _ := strconv.Atoi(message[match[6]:match[7]])
+		text := strings.TrimRight(strings.TrimSpace(message[match[1]:end]), ",")
+		diagnostics = append(diagnostics, Diagnostic{
+			File:     message[match[2]:match[3]],
+			Line:     line,
+			Column:   column,
+			Message:  text,
+			Severity: SeverityError,
+			Rule:     classifyPreviewError(text),
+		})
+	}
+	if len(diagnostics) > 0 {
+		return diagnostics
+	}
+
+	for _, text := range strings.Split(message, "\n") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		d := Diagnostic{
+			File:     pipelinePath,
+			Message:  text,
+			Severity: SeverityError,
+		}
+		if match := previewErrorFile.FindStringSubmatch(text); match != nil {
+			d.File = match[1]
+			d.Message = match[2]
+		}
+		d.Rule = classifyPreviewError(d.Message)
+		diagnostics = append(diagnostics, d)
+	}
+
+	return diagnostics
+}
+
+// classifyPreviewError returns the rule for a Preview API error message
+func classifyPreviewError(message string) string {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.HasPrefix(lower, "unexpected value"), strings.HasPrefix(lower, "unexpected parameter"):
+		return RulePreviewUnexpectedValue
+	case strings.Contains(lower, "parameter") && strings.Contains(lower, "must be provided"):
+		return RulePreviewMissingParameter
+	case strings.Contains(lower, "not found"), strings.Contains(lower, "could not find"):
+		return RulePreviewTemplateNotFound
+	default:
+		return RulePreviewError
+	}
+}
@@ -0,0 +1,103 @@
+package ado
+
+import (
+	"errors"
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	"net/http"
+	"testing"
+)
+
+func TestParsePreviewErrorMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []Diagnostic
+	}{
+		{
+			name:    "single error",
+			message: "/azure-pipelines.yml (Line: 12, Col: 5): Unexpected value 'stepz'",
+			want: []Diagnostic{
+				{File: "/azure-pipelines.yml", Line: 12, Column: 5, Message: "Unexpected value 'stepz'", Rule: RulePreviewUnexpectedValue},
+			},
+		},
+		{
+			name:    "several errors in several files",
+			message: "/azure-pipelines.yml (Line: 3, Col: 1): Unexpected value 'trigger2',/templates/build.yml (Line: 7, Col: 9): Unexpected value 'inputz'",
+			want: []Diagnostic{
+				{File: "/azure-pipelines.yml", Line: 3, Column: 1, Message: "Unexpected value 'trigger2'", Rule: RulePreviewUnexpectedValue},
+				{File: "/templates/build.yml", Line: 7, Column: 9, Message: "Unexpected value 'inputz'", Rule: RulePreviewUnexpectedValue},
+			},
+		},
+		{
+			name:    "path with spaces",
+			message: "/azure-pipelines.yml (Line: 1, Col: 1): Unexpected value 'a',/templates/build steps.yml (Line: 3, Col: 1): Unexpected value 'b'",
+			want: []Diagnostic{
+				{File: "/azure-pipelines.yml", Line: 1, Column: 1, Message: "Unexpected value 'a'", Rule: RulePreviewUnexpectedValue},
+				{File: "/templates/build steps.yml", Line: 3, Column: 1, Message: "Unexpected value 'b'", Rule: RulePreviewUnexpectedValue},
+			},
+		},
+		{
+			name:    "separated by comma and space",
+			message: "/a.yml (Line: 1, Col: 2): A value for the 'env' parameter must be provided., /b.yml (Line: 4, Col: 6): Unexpected value 'x'",
+			want: []Diagnostic{
+				{File: "/a.yml", Line: 1, Column: 2, Message: "A value for the 'env' parameter must be provided.", Rule: RulePreviewMissingParameter},
+				{File: "/b.yml", Line: 4, Column: 6, Message: "Unexpected value 'x'", Rule: RulePreviewUnexpectedValue},
+			},
+		},
+		{
+			name:    "separated by newlines",
+			message: "/a.yml (Line: 1, Col: 2): Unexpected value 'x'\n/b.yml (Line: 3, Col: 4): Unexpected value 'y'",
+			want: []Diagnostic{
+				{File: "/a.yml", Line: 1, Column: 2, Message: "Unexpected value 'x'", Rule: RulePreviewUnexpectedValue},
+				{File: "/b.yml", Line: 3, Column: 4, Message: "Unexpected value 'y'", Rule: RulePreviewUnexpectedValue},
+			},
+		},
+		{
+			name:    "file without location",
+			message: "/templates/missing.yml: File /templates/missing.yml not found in repository",
+			want: []Diagnostic{
+				{File: "/templates/missing.yml", Message: "File /templates/missing.yml not found in repository", Rule: RulePreviewTemplateNotFound},
+			},
+		},
+		{
+			name:    "no file",
+			message: "Something went wrong",
+			want: []Diagnostic{
+				{File: "/azure-pipelines.yml", Message: "Something went wrong", Rule: RulePreviewError},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePreviewErrorMessage(tt.message, "/azure-pipelines.yml")
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d diagnostics, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				want.Severity = SeverityError
+				if got[i] != want {
+					t.Errorf("diagnostic %d:\ngot  %+v\nwant %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePreviewError(t *testing.T) {
+	message := "/azure-pipelines.yml (Line: 1, Col: 1): Unexpected value 'a'"
+
+	badRequest := &azuredevops.WrappedError{StatusCode: Pointer(http.StatusBadRequest), Message: Pointer(message)}
+	if diagnostics, ok := parsePreviewError(badRequest, "/azure-pipelines.yml"); !ok || len(diagnostics) != 1 {
+		t.Errorf("bad request: got %+v, %v, want one diagnostic", diagnostics, ok)
+	}
+
+	unauthorized := &azuredevops.WrappedError{StatusCode: Pointer(http.StatusUnauthorized), Message: Pointer(message)}
+	if _, ok := parsePreviewError(unauthorized, "/azure-pipelines.yml"); ok {
+		t.Errorf("unauthorized: got a validation error, want none")
+	}
+
+	if _, ok := parsePreviewError(errors.New("connection refused"), "/azure-pipelines.yml"); ok {
+		t.Errorf("network error: got a validation error, want none")
+	}
+}
@@ -0,0 +1,226 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+type DiffStatus string
+
+const (
+	// DiffChanged is reported for pipelines whose expanded YAML differs from the target branch
+	DiffChanged DiffStatus = "changed"
+	// DiffUnchanged is reported for pipelines whose expanded YAML is the same as on the target branch
+	DiffUnchanged DiffStatus = "unchanged"
+	// DiffNew is reported for pipelines that can't be expanded on the target branch, for example because their files
+	// are new. They are compared with an empty file.
+	DiffNew DiffStatus = "new"
+	// DiffFailed is reported for pipelines that can't be expanded with the changes
+	DiffFailed DiffStatus = "failed"
+)
+
+// PipelineDiff compares the expanded YAML of a pipeline with the changes to the one on the target branch
+type PipelineDiff struct {
+	// Source is the validation outcome of the pipeline with the changes
+	Source PipelineReport
+	// Target is the validation outcome of the pipeline on the target branch
+	Target PipelineReport
+	Status DiffStatus
+	// Diff is the unified diff of the expanded YAML, empty unless the status is changed or new
+	Diff string
+}
+
+// DiffReport is the structured result of comparing the expanded YAML of pipelines between two versions
+type DiffReport struct {
+	// TargetBranch is the branch the changes are compared with
+	TargetBranch string
+	// SourceName names the version with the changes in the diff headers, for example the pull request branch
+	SourceName string
+	Pipelines  []PipelineDiff
+	// checked are the results of pipelines that were not expanded but have diagnostics of other checks, such as
+	// pipelines using files the pull request removes. They are only part of the source report.
+	checked []PipelineReport
+}
+
+// newDiffReport pairs the validation results of the same pipelines with and without the changes. Only the source
+// results whose key is in previewed were expanded, the others are kept for the source report.
+func newDiffReport(source *Report, target *Report, previewed map[string]bool, targetBranch string, sourceName string) *DiffReport {
+	report := &DiffReport{
+		TargetBranch: targetBranch,
+		SourceName:   sourceName,
+		Pipelines:    make([]PipelineDiff, 0, len(source.Results)),
+	}
+
+	targets := make(map[string]PipelineReport, len(target.Results))
+	for _, result := range target.Results {
+		targets[result.key()] = result
+	}
+
+	for _, result := range source.Results {
+		if !previewed[result.key()] {
+			report.checked = append(report.checked, result)
+			continue
+		}
+
+		diff := PipelineDiff{
+			Source: result,
+			Target: targets[result.key()],
+		}
+
+		switch {
+		case result.FinalYaml == "":
+			diff.Status = DiffFailed
+		case diff.Target.FinalYaml == result.FinalYaml:
+			diff.Status = DiffUnchanged
+		default:
+			diff.Status = DiffChanged
+			if diff.Target.FinalYaml == "" {
+				diff.Status = DiffNew
+			}
+			diff.Diff = unifiedDiff(
+				fmt.Sprintf("%s:%s", toRefName(targetBranch), result.Path),
+				fmt.Sprintf("%s:%s", sourceName, result.Path),
+				diff.Target.FinalYaml,
+				result.FinalYaml,
+			)
+			if diff.Diff == "" {
+				// Only whitespace at the end of the YAML differs
+				diff.Status = DiffUnchanged
+			}
+		}
+		report.Pipelines = append(report.Pipelines, diff)
+	}
+
+	return report
+}
+
+// Count returns the number of pipelines with the given status
+func (r *DiffReport) Count(status DiffStatus) int {
+	count := 0
+	for _, pipeline := range r.Pipelines {
+		if pipeline.Status == status {
+			count++
+		}
+	}
+
+	return count
+}
+
+// SourceReport returns the validation report of the pipelines with the changes
+func (r *DiffReport) SourceReport() *Report {
+	report := &Report{
+		Results: make([]PipelineReport, 0, len(r.Pipelines)+len(r.checked)),
+	}
+	for _, pipeline := range r.Pipelines {
+		report.Results = append(report.Results, pipeline.Source)
+	}
+	report.Results = append(report.Results, r.checked...)
+	report.sort()
+
+	return report
+}
+
+// Render writes the diff of every pipeline whose expansion changed to w, followed by a summary
+func (r *DiffReport) Render(w io.Writer) error {
+	if len(r.Pipelines) == 0 {
+		_, err := fmt.Fprintln(w, "no changed pipelines found")
+		return err
+	}
+
+	for _, pipeline := range r.Pipelines {
+		var err error
+		switch pipeline.Status {
+		case DiffFailed:
+			_, err = fmt.Fprintf(w, "pipeline %s could not be expanded: %s\n", pipeline.Source.Name(), strings.Join(pipeline.Source.Messages(), "; "))
+		case DiffUnchanged:
+			_, err = fmt.Fprintf(w, "pipeline %s unchanged\n", pipeline.Source.Name())
+		case DiffNew:
+			_, err = fmt.Fprintf(w, "pipeline %s could not be expanded on %s: %s\n", pipeline.Source.Name(), r.TargetBranch, strings.Join(pipeline.Target.Messages(), "; "))
+		default:
+			_, err = fmt.Fprintf(w, "pipeline %s changed\n", pipeline.Source.Name())
+		}
+		if err != nil {
+			return err
+		}
+
+		if pipeline.Diff != "" {
+			if _, err := io.WriteString(w, pipeline.Diff); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d changed, %d unchanged, %d new and %d failed of %d pipeline(s) compared with %s\n",
+		r.Count(DiffChanged), r.Count(DiffUnchanged), r.Count(DiffNew), r.Count(DiffFailed), len(r.Pipelines), r.TargetBranch)
+	return err
+}
+
+// diffPipelines validates the pipelines with the changes using the given function and on the target branch, and
+// compares the expanded YAML of both. With a baseline comparison, the validation on the target branch is the baseline.
+// checked are results of checks that need no expansion, which are added to the source report.
+func (c ValidationClient) diffPipelines(ctx context.Context, pipes []Pipeline, validate func(context.Context, Pipeline) (ValidationResult, error), checked []ValidationResult, targetBranch string, sourceName string, sources fs.FS) *DiffReport {
+	source := c.validatePipelines(ctx, pipes, validate)
+	previewed := make(map[string]bool, len(source.Results))
+	for _, result := range source.Results {
+		previewed[result.key()] = true
+	}
+	source.addResults(checked)
+	source.annotateDiagnostics(sources)
+	target := c.validatePipelinesOnBranch(ctx, pipes, targetBranch, sources)
+	if c.baseline {
+		source.ApplyBaseline(target)
+	}
+
+	return newDiffReport(source, target, previewed, targetBranch, sourceName)
+}
+
+// DiffAllLocalChanges compares the expanded YAML of all pipelines affected by the local changes with the expanded
+// YAML on the run branch
+func (c ValidationClient) DiffAllLocalChanges(ctx context.Context) (*DiffReport, error) {
+	local, err := c.getLocalChangedPipelines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("DiffAllLocalChanges: %w", err)
+	}
+
+	return c.diffLocalPipelines(ctx, local), nil
+}
+
+// DiffLocalPipelines compares the expanded YAML of the pipelines whose root files are given as paths in the working
+// tree with the expanded YAML on the run branch
+func (c ValidationClient) DiffLocalPipelines(ctx context.Context, files []string) (*DiffReport, error) {
+	local, err := c.getLocalPipelines(ctx, files)
+	if err != nil {
+		return nil, fmt.Errorf("DiffLocalPipelines: %w", err)
+	}
+
+	return c.diffLocalPipelines(ctx, local), nil
+}
+
+// diffLocalPipelines compares the pipelines validated with the local working tree with the run branch
+func (c ValidationClient) diffLocalPipelines(ctx context.Context, local *localPipelines) *DiffReport {
+	validate := func(ctx context.Context, pipeline Pipeline) (ValidationResult, error) {
+		return c.validatePipelineLocally(ctx, local.repoRoot, local.changed, pipeline)
+	}
+
+	return c.diffPipelines(ctx, local.pipes, validate, nil, c.environment.runBranch, "working tree", os.DirFS(local.repoRoot))
+}
+
+// DiffAllPrChanges compares the expanded YAML of all pipelines affected by the pull request on the pull request branch
+// with the expanded YAML on its target branch
+func (c ValidationClient) DiffAllPrChanges(ctx context.Context) (*DiffReport, error) {
+	if c.environment.targetBranch == "" {
+		return nil, fmt.Errorf("DiffAllPrChanges: target branch of the pull request is not set")
+	}
+
+	// The source report is used as the validation report, so it needs the same checks as ValidateAllPrChanges
+	changedPipelines, removedFileResults, err := c.getPrChangedPipelines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("DiffAllPrChanges: %w", err)
+	}
+
+	return c.diffPipelines(ctx, changedPipelines, c.validatePipelineInPR, removedFileResults, c.environment.targetBranch, toRefName(c.environment.runBranch), c.sourcesFS()), nil
+}
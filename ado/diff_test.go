@@ -0,0 +1,71 @@
+package ado
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewDiffReport(t *testing.T) {
+	source := &Report{Results: []PipelineReport{
+		{PipelineId: 1, Path: "/changed.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo new\n"},
+		{PipelineId: 2, Path: "/unchanged.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo\n"},
+		{PipelineId: 3, Path: "/trailing.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo\n"},
+		{PipelineId: 4, Path: "/new.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo\n"},
+		{PipelineId: 5, Path: "/failed.yml", Status: StatusFailed, Error: "unexpected value"},
+		{PipelineId: 6, Path: "/matrix.yml", Parameters: map[string]string{"debug": "true"}, Status: StatusPassed, FinalYaml: "steps:\n- script: echo debug\n"},
+		{PipelineId: 6, Path: "/matrix.yml", Parameters: map[string]string{"debug": "false"}, Status: StatusPassed, FinalYaml: "steps:\n- script: echo\n"},
+		{PipelineId: 7, Path: "/removed.yml", Status: StatusFailed, Error: "template no longer exists"},
+	}}
+	target := &Report{Results: []PipelineReport{
+		{PipelineId: 1, Path: "/changed.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo old\n"},
+		{PipelineId: 2, Path: "/unchanged.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo\n"},
+		{PipelineId: 3, Path: "/trailing.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo"},
+		{PipelineId: 4, Path: "/new.yml", Status: StatusFailed, Error: "file not found"},
+		{PipelineId: 5, Path: "/failed.yml", Status: StatusPassed, FinalYaml: "steps: []\n"},
+		{PipelineId: 6, Path: "/matrix.yml", Parameters: map[string]string{"debug": "true"}, Status: StatusPassed, FinalYaml: "steps:\n- script: echo debug\n"},
+		{PipelineId: 6, Path: "/matrix.yml", Parameters: map[string]string{"debug": "false"}, Status: StatusPassed, FinalYaml: "steps: []\n"},
+	}}
+	previewed := make(map[string]bool)
+	for _, result := range source.Results[:7] {
+		previewed[result.key()] = true
+	}
+
+	report := newDiffReport(source, target, previewed, "main", "feature")
+
+	want := []struct {
+		name   string
+		status DiffStatus
+		diff   bool
+	}{
+		{name: "/changed.yml", status: DiffChanged, diff: true},
+		{name: "/unchanged.yml", status: DiffUnchanged},
+		{name: "/trailing.yml", status: DiffUnchanged},
+		{name: "/new.yml", status: DiffNew, diff: true},
+		{name: "/failed.yml", status: DiffFailed},
+		{name: "/matrix.yml (debug=true)", status: DiffUnchanged},
+		{name: "/matrix.yml (debug=false)", status: DiffChanged, diff: true},
+	}
+	if len(report.Pipelines) != len(want) {
+		t.Fatalf("got %d pipelines, want %d", len(report.Pipelines), len(want))
+	}
+	for i, w := range want {
+		got := report.Pipelines[i]
+		if got.Source.Name() != w.name || got.Status != w.status || (got.Diff != "") != w.diff {
+			t.Errorf("pipeline %d: got %s %s with diff %q, want %s %s", i, got.Source.Name(), got.Status, got.Diff, w.name, w.status)
+		}
+	}
+
+	if diff := report.Pipelines[0].Diff; !strings.HasPrefix(diff, "--- refs/heads/main:/changed.yml\n+++ feature:/changed.yml\n") {
+		t.Errorf("got diff\n%s\nwant headers naming the target branch and the source", diff)
+	}
+
+	// Pipelines that were not previewed are kept for the source report only
+	sourceReport := report.SourceReport()
+	paths := make(map[string]bool)
+	for _, result := range sourceReport.Results {
+		paths[result.Path] = true
+	}
+	if len(sourceReport.Results) != len(source.Results) || !paths["/removed.yml"] {
+		t.Errorf("got %d source results with paths %v, want all %d", len(sourceReport.Results), paths, len(source.Results))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/microsoft/azure-devops-go-api/azuredevops"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/git"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -30,8 +31,18 @@ type AzureDevOpsEnvironment struct {
 	project         string
 	connection      *azuredevops.Connection
 	runBranch       string
-	repositoryId    string
-	pullRequestId   int
+	// targetBranch is the branch a pull request merges into, empty outside of PR builds
+	targetBranch   string
+	repositoryId   string
+	repositoryName string
+	pullRequestId  int
+	// buildId is the ID of the build running the validation, used to link back to it. Zero outside of builds.
+	buildId int
+	// sourcesDirectory is the checkout of the repository in a PR build, used to resolve template references. Empty
+	// if the build has no checkout.
+	sourcesDirectory string
+	// httpClient sends the REST calls that are not made through the SDK, such as previews
+	httpClient *http.Client
 }
 
 func NewAzureDevOpsEnvironment(conn *azuredevops.Connection, project string, runBranch string, repositoryName string, opts ...EnvOption) (*AzureDevOpsEnvironment, error) {
@@ -40,15 +51,11 @@ func NewAzureDevOpsEnvironment(conn *azuredevops.Connection, project string, run
 		organizationUrl: conn.BaseUrl,
 		project:         project,
 		runBranch:       runBranch,
+		repositoryName:  repositoryName,
+		httpClient:      http.DefaultClient,
 	}
 
-	repoId, err := env.getRepoId(repositoryName)
-	if err != nil {
-		return nil, fmt.Errorf("NewAzureDevOpsEnvironment: failed to retrieve repository ID: %w", err)
-	}
-
-	env.repositoryId = repoId
-
+	// Options are applied first, so the repository lookup is already sent with the configured HTTP client
 	for _, opt := range opts {
 		err := opt(env)
 		if err != nil {
@@ -56,43 +63,54 @@ func NewAzureDevOpsEnvironment(conn *azuredevops.Connection, project string, run
 		}
 	}
 
+	repoId, err := env.getRepoId(repositoryName)
+	if err != nil {
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironment: failed to retrieve repository ID: %w", err)
+	}
+
+	env.repositoryId = repoId
+
 	return env, nil
 }
 
-func NewAzureDevOpsEnvironmentFromPR() (*AzureDevOpsEnvironment, error) {
+// NewAzureDevOpsEnvironmentFromPR creates the environment of a PR build from the predefined pipeline variables. REST
+// calls are sent through the given transport, authenticated with the job access token, see JobTokenProvider.
+func NewAzureDevOpsEnvironmentFromPR(ctx context.Context, base http.RoundTripper, opts ...EnvOption) (*AzureDevOpsEnvironment, error) {
 	env := &AzureDevOpsEnvironment{}
 
-	orgUri := os.Getenv("SYSTEM_TEAMFOUNDATIONCOLLECTIONURI")
-	if orgUri == "" {
-		return nil, fmt.Errorf("failed to retrieve organization URL from environment variables")
+	orgUrl := os.Getenv("SYSTEM_TEAMFOUNDATIONCOLLECTIONURI")
+	if orgUrl == "" {
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironmentFromPR: failed to retrieve organization URL from environment variables")
 	}
-	token := os.Getenv("SYSTEM_ACCESSTOKEN")
-	if token == "" {
-		return nil, fmt.Errorf("WithPrEnv: failed to retrieve access token from environment variables")
+	auth, err := NewAuthTransport(ctx, base, JobTokenProvider{})
+	if err != nil {
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironmentFromPR: failed to retrieve access token from environment variables: %w", err)
 	}
 
-	conn := NewOauthConnection(orgUri, token)
+	conn := auth.Connection(orgUrl)
+	env.httpClient = &http.Client{Transport: auth}
 
-	orgUrl := os.Getenv("SYSTEM_TEAMFOUNDATIONCOLLECTIONURI")
-	if orgUrl == "" {
-		return nil, fmt.Errorf("WithPrEnv: failed to retrieve organization URL from environment variables")
-	}
 	project := os.Getenv("SYSTEM_TEAMPROJECT")
 	if project == "" {
-		return nil, fmt.Errorf("WithPrEnv: failed to retrieve project name from environment variables")
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironmentFromPR: failed to retrieve project name from environment variables")
 	}
 	runBranch := os.Getenv("BUILD_SOURCEBRANCH")
 	if runBranch == "" {
-		return nil, fmt.Errorf("WithPrEnv: failed to retrieve run branch from environment variables")
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironmentFromPR: failed to retrieve run branch from environment variables")
 	}
 	repositoryId := os.Getenv("BUILD_REPOSITORY_ID")
 	if repositoryId == "" {
-		return nil, fmt.Errorf("WithPrEnv: failed to retrieve repository ID from environment variables")
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironmentFromPR: failed to retrieve repository ID from environment variables")
+	}
+
+	repositoryName := os.Getenv("BUILD_REPOSITORY_NAME")
+	if repositoryName == "" {
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironmentFromPR: failed to retrieve repository name from environment variables")
 	}
 
 	pullRequestId, err := strconv.Atoi(os.Getenv("SYSTEM_PULLREQUEST_PULLREQUESTID"))
 	if err != nil {
-		return nil, fmt.Errorf("WithPrEnv: failed to retrieve pull request ID from environment variables. %w", err)
+		return nil, fmt.Errorf("NewAzureDevOpsEnvironmentFromPR: failed to retrieve pull request ID from environment variables. %w", err)
 	}
 
 	env.connection = conn
@@ -100,32 +118,53 @@ func NewAzureDevOpsEnvironmentFromPR() (*AzureDevOpsEnvironment, error) {
 	env.project = project
 	env.runBranch = runBranch
 	env.repositoryId = repositoryId
+	env.repositoryName = repositoryName
 	env.pullRequestId = pullRequestId
+	env.targetBranch = os.Getenv("SYSTEM_PULLREQUEST_TARGETBRANCH")
+	env.sourcesDirectory = os.Getenv("BUILD_SOURCESDIRECTORY")
+	// The build ID is only used for linking, so a missing value is not an error
+	env.buildId, _ = strconv.Atoi(os.Getenv("BUILD_BUILDID"))
+
+	for _, opt := range opts {
+		err := opt(env)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return env, nil
 }
 
 type EnvOption func(*AzureDevOpsEnvironment) error
 
-func (e AzureDevOpsEnvironment) getRepoId(repoName string) (string, error) {
-	client, err := git.NewClient(context.Background(), e.connection)
-	if err != nil {
-		return "", fmt.Errorf("getRepoId: failed to create git client. %w", err)
+// WithHTTPClient sends the REST calls that are not made through the SDK with the given client, for example one with a
+// retry transport. Defaults to http.DefaultClient.
+func WithHTTPClient(client *http.Client) EnvOption {
+	return func(e *AzureDevOpsEnvironment) error {
+		if client == nil {
+			return fmt.Errorf("WithHTTPClient: client must not be nil")
+		}
+		e.httpClient = client
+		return nil
 	}
+}
 
-	allRepos, err := client.GetRepositories(context.Background(), git.GetRepositoriesArgs{
-		Project: Pointer(e.project),
-	})
-
+func (e AzureDevOpsEnvironment) getRepoId(repoName string) (string, error) {
+	var allRepos struct {
+		Value []git.GitRepository `json:"value"`
+	}
+	err := e.sendRequest(context.Background(), e.sdkClient(), http.MethodGet, e.projectApiUrl("git/repositories", nil), nil, &allRepos)
 	if err != nil {
 		return "", fmt.Errorf("getRepoId: failed to retrieve repositories. %w", err)
 	}
 
-	for _, repo := range *allRepos {
-		if *repo.Name == repoName {
+	// Repository names are case-insensitive in Azure Repos
+	for _, repo := range allRepos.Value {
+		if repo.Name != nil && repo.Id != nil && strings.EqualFold(*repo.Name, repoName) {
 			return (*repo.Id).String(), nil
 		}
 	}
 
-	return "", nil
-}
\ No newline at end of file
+	// Pipelines are matched by repository, so nothing could be validated without it
+	return "", fmt.Errorf("getRepoId: repository %s not found in project %s", repoName, e.project)
+}
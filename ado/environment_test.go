@@ -0,0 +1,96 @@
+package ado
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setPrEnv sets the predefined variables of a PR build
+func setPrEnv(t *testing.T) {
+	t.Setenv("SYSTEM_TEAMFOUNDATIONCOLLECTIONURI", "https://dev.azure.com/org/")
+	t.Setenv("SYSTEM_TEAMPROJECT", "project")
+	t.Setenv("BUILD_SOURCEBRANCH", "refs/pull/7/merge")
+	t.Setenv("BUILD_REPOSITORY_ID", "repo-id")
+	t.Setenv("BUILD_REPOSITORY_NAME", "repo")
+	t.Setenv("SYSTEM_PULLREQUEST_PULLREQUESTID", "7")
+	t.Setenv("SYSTEM_PULLREQUEST_TARGETBRANCH", "refs/heads/main")
+	t.Setenv("SYSTEM_ACCESSTOKEN", "job-token")
+	t.Setenv("AZURE_DEVOPS_EXT_PAT", "")
+}
+
+func TestNewAzureDevOpsEnvironmentFromPR(t *testing.T) {
+	setPrEnv(t)
+	// A personal access token in the environment does not replace the job access token
+	t.Setenv("AZURE_DEVOPS_EXT_PAT", "pat")
+
+	env, err := NewAzureDevOpsEnvironmentFromPR(context.Background(), http.DefaultTransport)
+	if err != nil {
+		t.Fatalf("NewAzureDevOpsEnvironmentFromPR failed: %v", err)
+	}
+
+	if env.connection.AuthorizationString != "Bearer job-token" {
+		t.Errorf("got authorization %q, want the job access token", env.connection.AuthorizationString)
+	}
+	if env.organizationUrl != "https://dev.azure.com/org/" || env.project != "project" || env.repositoryId != "repo-id" || env.pullRequestId != 7 {
+		t.Errorf("got environment %+v, want the values of the pipeline variables", env)
+	}
+}
+
+func TestNewAzureDevOpsEnvironmentFromPRMissingVariables(t *testing.T) {
+	tests := []struct {
+		variable string
+		wantErr  string
+	}{
+		{variable: "SYSTEM_TEAMFOUNDATIONCOLLECTIONURI", wantErr: "organization URL"},
+		{variable: "SYSTEM_ACCESSTOKEN", wantErr: "access token"},
+		{variable: "SYSTEM_TEAMPROJECT", wantErr: "project name"},
+		{variable: "SYSTEM_PULLREQUEST_PULLREQUESTID", wantErr: "pull request ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.variable, func(t *testing.T) {
+			setPrEnv(t)
+			t.Setenv(tt.variable, "")
+
+			_, err := NewAzureDevOpsEnvironmentFromPR(context.Background(), http.DefaultTransport)
+			if err == nil || !strings.HasPrefix(err.Error(), "NewAzureDevOpsEnvironmentFromPR: ") || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error about the %s", err, tt.wantErr)
+			}
+			if tt.variable == "SYSTEM_ACCESSTOKEN" && !errors.Is(err, ErrNoCredentials) {
+				t.Errorf("got %v, want ErrNoCredentials", err)
+			}
+		})
+	}
+}
+
+func TestNewAzureDevOpsEnvironmentUsesHTTPClient(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte(`{"value":[{"id":"5f0e0b1e-3c4a-4d58-9a1e-2b6f7c8d9e0f","name":"Repo"}]}`))
+	}))
+	defer server.Close()
+
+	// The repository lookup is the first request, so it must already be retried
+	client := &http.Client{Transport: NewRetryTransport(http.DefaultTransport, testRetryOptions)}
+	env, err := NewAzureDevOpsEnvironment(NewOauthConnection(server.URL, "token"), "project", "main", "repo", WithHTTPClient(client))
+	if err != nil {
+		t.Fatalf("NewAzureDevOpsEnvironment failed: %v", err)
+	}
+
+	if env.repositoryId != "5f0e0b1e-3c4a-4d58-9a1e-2b6f7c8d9e0f" {
+		t.Errorf("got repository ID %q, want the ID of Repo", env.repositoryId)
+	}
+	if requests != 2 {
+		t.Errorf("got %d requests, want the throttled request to be retried once", requests)
+	}
+}
@@ -0,0 +1,75 @@
+package ado
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// unsafeFileNameChars matches characters replaced when deriving file names from pipeline paths and parameters
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._=-]+`)
+
+// expandedFileName returns the file name the expanded YAML of a pipeline is saved as. The pipeline ID and parameter
+// values are part of the name, as several pipelines can share a root file.
+func (p PipelineReport) expandedFileName() string {
+	name := fmt.Sprintf("%d-%s", p.PipelineId, strings.ReplaceAll(strings.TrimPrefix(p.Path, "/"), "/", "_"))
+	if len(p.Parameters) > 0 {
+		ext := filepath.Ext(name)
+		label := unsafeFileNameChars.ReplaceAllString(p.parameterLabel(), "_")
+		name = strings.TrimSuffix(name, ext) + "-" + label + ext
+	}
+
+	return unsafeFileNameChars.ReplaceAllString(name, "_")
+}
+
+// SaveExpanded writes the expanded YAML of every pipeline that passed validation to a file in dir, which is created if
+// needed
+func (r *Report) SaveExpanded(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("SaveExpanded: failed to create %s: %w", dir, err)
+	}
+
+	for _, result := range r.Results {
+		if result.FinalYaml == "" {
+			continue
+		}
+		file := filepath.Join(dir, result.expandedFileName())
+		if err := os.WriteFile(file, []byte(result.FinalYaml), 0o644); err != nil {
+			return fmt.Errorf("SaveExpanded: failed to write %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// RenderExpanded writes the expanded YAML of every pipeline that passed validation to w as a stream of YAML documents,
+// each preceded by a comment naming the pipeline
+func (r *Report) RenderExpanded(w io.Writer) error {
+	first := true
+	for _, result := range r.Results {
+		if result.FinalYaml == "" {
+			continue
+		}
+
+		if !first {
+			if _, err := fmt.Fprintln(w, "---"); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		if _, err := fmt.Fprintf(w, "# pipeline %d: %s\n%s", result.PipelineId, result.Name(), result.FinalYaml); err != nil {
+			return err
+		}
+		if !strings.HasSuffix(result.FinalYaml, "\n") {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
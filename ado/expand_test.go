@@ -0,0 +1,111 @@
+package ado
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandedFileName(t *testing.T) {
+	tests := []struct {
+		name   string
+		result PipelineReport
+		want   string
+	}{
+		{
+			name:   "root file",
+			result: PipelineReport{PipelineId: 1, Path: "/azure-pipelines.yml"},
+			want:   "1-azure-pipelines.yml",
+		},
+		{
+			name:   "nested path",
+			result: PipelineReport{PipelineId: 12, Path: "/pipelines/ci/build.yml"},
+			want:   "12-pipelines_ci_build.yml",
+		},
+		{
+			name:   "unsafe characters",
+			result: PipelineReport{PipelineId: 3, Path: "/My Pipelines/build (ci).yml"},
+			want:   "3-My_Pipelines_build_ci_.yml",
+		},
+		{
+			name:   "parameter matrix",
+			result: PipelineReport{PipelineId: 4, Path: "/pipelines/deploy.yml", Parameters: map[string]string{"region": "west europe", "environment": "dev"}},
+			want:   "4-pipelines_deploy-environment=dev_region=west_europe.yml",
+		},
+		{
+			name:   "parameter values with slashes",
+			result: PipelineReport{PipelineId: 5, Path: "/deploy.yml", Parameters: map[string]string{"path": "../../etc"}},
+			want:   "5-deploy-path=.._.._etc.yml",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.result.expandedFileName(); got != tt.want {
+			t.Errorf("%s: expandedFileName() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSaveExpanded(t *testing.T) {
+	report := &Report{Results: []PipelineReport{
+		{PipelineId: 1, Path: "/azure-pipelines.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo root\n"},
+		{PipelineId: 2, Path: "/pipelines/deploy.yml", Parameters: map[string]string{"environment": "dev"}, Status: StatusPassed, FinalYaml: "steps:\n- script: echo dev\n"},
+		{PipelineId: 2, Path: "/pipelines/deploy.yml", Parameters: map[string]string{"environment": "prod"}, Status: StatusPassed, FinalYaml: "steps:\n- script: echo prod\n"},
+		{PipelineId: 3, Path: "/pipelines/broken.yml", Status: StatusFailed, Error: "invalid"},
+	}}
+	dir := filepath.Join(t.TempDir(), "expanded", "nested")
+
+	if err := report.SaveExpanded(dir); err != nil {
+		t.Fatalf("SaveExpanded() error = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+	got := make(map[string]string)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[entry.Name()] = string(content)
+	}
+
+	// Failed pipelines have no expanded YAML, so there is no file for them
+	want := map[string]string{
+		"1-azure-pipelines.yml":                   "steps:\n- script: echo root\n",
+		"2-pipelines_deploy-environment=dev.yml":  "steps:\n- script: echo dev\n",
+		"2-pipelines_deploy-environment=prod.yml": "steps:\n- script: echo prod\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+}
+
+func TestRenderExpanded(t *testing.T) {
+	report := &Report{Results: []PipelineReport{
+		{PipelineId: 1, Path: "/azure-pipelines.yml", Status: StatusPassed, FinalYaml: "steps:\n- script: echo root"},
+		{PipelineId: 3, Path: "/pipelines/broken.yml", Status: StatusFailed, Error: "invalid"},
+		{PipelineId: 2, Path: "/pipelines/deploy.yml", Parameters: map[string]string{"environment": "dev"}, Status: StatusPassed, FinalYaml: "steps:\n- script: echo dev\n"},
+	}}
+
+	var out bytes.Buffer
+	if err := report.RenderExpanded(&out); err != nil {
+		t.Fatalf("RenderExpanded() error = %v", err)
+	}
+
+	want := `# pipeline 1: /azure-pipelines.yml
+steps:
+- script: echo root
+---
+# pipeline 2: /pipelines/deploy.yml (environment=dev)
+steps:
+- script: echo dev
+`
+	if got := out.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
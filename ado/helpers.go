@@ -1,5 +1,16 @@
 package ado
 
+import "strings"
+
 func Pointer[T any](val T) *T {
 	return &val
-}
\ No newline at end of file
+}
+
+// toRefName returns the full git ref name for a branch, leaving names that are already full refs untouched
+func toRefName(branch string) string {
+	if strings.HasPrefix(branch, "refs/") {
+		return branch
+	}
+
+	return "refs/heads/" + branch
+}
@@ -0,0 +1,146 @@
+package ado
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"strings"
+)
+
+// templateHostAlias is the repository alias generated wrapper pipelines use for the repository being validated
+const templateHostAlias = "validated"
+
+// WithTemplateHosts configures host pipelines for changed templates that no pipeline uses
+func WithTemplateHosts(hosts []TemplateHost) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		for i, host := range hosts {
+			if len(host.Templates) == 0 {
+				return fmt.Errorf("WithTemplateHosts: template host %d has no templates", i)
+			}
+			if host.PipelineId <= 0 {
+				return fmt.Errorf("WithTemplateHosts: template host %d has no pipeline ID", i)
+			}
+		}
+		c.templateHosts = hosts
+		return nil
+	}
+}
+
+// getTemplateHostPipelines returns a host pipeline for each changed file that is not covered by any pipeline and
+// matches a configured template host. The first matching host is used. covered is keyed by pipelinePathKey.
+func (c ValidationClient) getTemplateHostPipelines(changedYamlFiles []string, covered map[string]bool) []Pipeline {
+	result := make([]Pipeline, 0)
+	seen := make(map[string]bool)
+
+	for _, file := range changedYamlFiles {
+		file = normalizePipelinePath(file)
+		if covered[pipelinePathKey(file)] || seen[pipelinePathKey(file)] {
+			continue
+		}
+		seen[pipelinePathKey(file)] = true
+
+		for i := range c.templateHosts {
+			if c.templateHosts[i].matches(file) {
+				result = append(result, Pipeline{
+					FilePath: file,
+					Id:       c.templateHosts[i].PipelineId,
+					host:     &c.templateHosts[i],
+				})
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// templateWrapper returns the pipeline that previews a template inside its host pipeline. Unless the host configures
+// its own wrapper, the template is inserted into a generated pipeline with the host's parameters. The generated
+// pipeline references the template through a repository resource on the given branch, so the host pipeline can live
+// in any repository of the project.
+func (c ValidationClient) templateWrapper(pipeline Pipeline, sources fs.FS, branch string) (string, error) {
+	templatePath := normalizePipelinePath(pipeline.FilePath)
+	if pipeline.host.Wrapper != "" {
+		replacer := strings.NewReplacer("{{template}}", templatePath, "{{ref}}", toRefName(branch))
+		return replacer.Replace(pipeline.host.Wrapper), nil
+	}
+
+	if sources == nil {
+		return "", fmt.Errorf("templateWrapper: %s needs a wrapper in the configuration, as its type can't be determined without the sources", templatePath)
+	}
+	content, err := fs.ReadFile(sources, strings.TrimPrefix(templatePath, "/"))
+	if err != nil {
+		return "", fmt.Errorf("templateWrapper: failed to read %s: %w", templatePath, err)
+	}
+	kind, err := templateKind(content)
+	if err != nil {
+		return "", fmt.Errorf("templateWrapper: %s: %w", templatePath, err)
+	}
+
+	item := map[string]any{
+		"template": templatePath + "@" + templateHostAlias,
+	}
+	if len(pipeline.host.Parameters) > 0 {
+		item["parameters"] = pipeline.host.Parameters
+	}
+	repository := map[string]string{
+		"repository": templateHostAlias,
+		"type":       "git",
+		"name":       c.environment.project + "/" + c.environment.repositoryName,
+		"ref":        toRefName(branch),
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	add := func(key string, value any) error {
+		var node yaml.Node
+		if err := node.Encode(value); err != nil {
+			return fmt.Errorf("templateWrapper: failed to encode %s: %w", key, err)
+		}
+		root.Content = append(root.Content, scalarNode(key), &node)
+		return nil
+	}
+
+	if err := add("trigger", "none"); err != nil {
+		return "", err
+	}
+	if err := add("pr", "none"); err != nil {
+		return "", err
+	}
+	if err := add("resources", map[string]any{"repositories": []any{repository}}); err != nil {
+		return "", err
+	}
+	if err := add(kind, []any{item}); err != nil {
+		return "", err
+	}
+	if kind == "variables" {
+		// A pipeline needs at least one step to be valid
+		if err := add("steps", []any{map[string]string{"checkout": "none"}}); err != nil {
+			return "", err
+		}
+	}
+
+	out, err := yaml.Marshal(root)
+	if err != nil {
+		return "", fmt.Errorf("templateWrapper: failed to serialize wrapper: %w", err)
+	}
+
+	return string(out), nil
+}
+
+// templateKind returns the list a template inserts into: stages, jobs, steps or variables
+func templateKind(content []byte) (string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	if len(doc.Content) > 0 {
+		for _, key := range templateListKeys {
+			if mappingValue(doc.Content[0], key) != nil {
+				return key, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("template has none of %s", strings.Join(templateListKeys, ", "))
+}
@@ -0,0 +1,197 @@
+package ado
+
+import (
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestGetTemplateHostPipelines(t *testing.T) {
+	c := ValidationClient{
+		templateHosts: []TemplateHost{
+			{Templates: []string{"/templates/deploy/**"}, PipelineId: 2},
+			{Templates: []string{"/templates/**/*.yml"}, PipelineId: 1},
+		},
+	}
+	changed := []string{
+		"/templates/steps.yml",
+		"templates/deploy/stages.yml",
+		"/templates/steps.yml",
+		"/Templates/Covered.yml",
+		"/pipelines/ci.yml",
+	}
+	covered := map[string]bool{pipelinePathKey("/templates/covered.yml"): true}
+
+	type hostedPipeline struct {
+		FilePath string
+		Id       int
+	}
+	var got []hostedPipeline
+	for _, pipeline := range c.getTemplateHostPipelines(changed, covered) {
+		if pipeline.host == nil || pipeline.host.PipelineId != pipeline.Id {
+			t.Errorf("%s: got host %+v, want the host of pipeline %d", pipeline.FilePath, pipeline.host, pipeline.Id)
+		}
+		got = append(got, hostedPipeline{FilePath: pipeline.FilePath, Id: pipeline.Id})
+	}
+
+	// Files covered by a pipeline or without a matching host are left out, and the first matching host wins
+	want := []hostedPipeline{
+		{FilePath: "/templates/steps.yml", Id: 1},
+		{FilePath: "/templates/deploy/stages.yml", Id: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got host pipelines %+v, want %+v", got, want)
+	}
+}
+
+func TestTemplateKind(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{name: "stages", content: "parameters:\n- name: env\nstages:\n- stage: build\n", want: "stages"},
+		{name: "jobs", content: "jobs:\n- job: build\n", want: "jobs"},
+		{name: "steps", content: "steps:\n- script: echo\n", want: "steps"},
+		{name: "variables", content: "variables:\n- name: verbose\n  value: true\n", want: "variables"},
+		{name: "stages before steps", content: "steps: []\nstages: []\n", want: "stages"},
+		{name: "parameters only", content: "parameters:\n- name: env\n  type: string\n", wantErr: true},
+		{name: "empty", content: "", wantErr: true},
+		{name: "invalid YAML", content: "steps: [\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := templateKind([]byte(tt.content))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: templateKind() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: templateKind() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// wrapperPipeline is the part of a generated wrapper pipeline the tests check
+type wrapperPipeline struct {
+	Trigger   string `yaml:"trigger"`
+	Pr        string `yaml:"pr"`
+	Resources struct {
+		Repositories []map[string]string `yaml:"repositories"`
+	} `yaml:"resources"`
+	Stages    []map[string]any `yaml:"stages"`
+	Jobs      []map[string]any `yaml:"jobs"`
+	Steps     []map[string]any `yaml:"steps"`
+	Variables []map[string]any `yaml:"variables"`
+}
+
+func TestTemplateWrapper(t *testing.T) {
+	sources := fstest.MapFS{
+		"templates/stages.yml":    {Data: []byte("parameters:\n- name: env\nstages:\n- stage: deploy\n")},
+		"templates/jobs.yml":      {Data: []byte("jobs:\n- job: build\n")},
+		"templates/steps.yml":     {Data: []byte("steps:\n- script: echo\n")},
+		"templates/variables.yml": {Data: []byte("variables:\n- name: verbose\n  value: true\n")},
+	}
+	c := ValidationClient{
+		environment: &AzureDevOpsEnvironment{
+			project:        "project",
+			repositoryName: "repo",
+		},
+	}
+	wantRepository := map[string]string{
+		"repository": templateHostAlias,
+		"type":       "git",
+		"name":       "project/repo",
+		"ref":        "refs/heads/feature/templates",
+	}
+
+	tests := []struct {
+		file      string
+		kind      string
+		wantSteps []map[string]any
+	}{
+		{file: "/templates/stages.yml", kind: "stages"},
+		{file: "/templates/jobs.yml", kind: "jobs"},
+		{file: "/templates/steps.yml", kind: "steps"},
+		// Variables templates get a step, as a pipeline needs at least one
+		{file: "/templates/variables.yml", kind: "variables", wantSteps: []map[string]any{{"checkout": "none"}}},
+	}
+	for _, tt := range tests {
+		host := &TemplateHost{Templates: []string{"/templates/**"}, PipelineId: 1, Parameters: map[string]any{"env": "dev"}}
+		wrapper, err := c.templateWrapper(Pipeline{FilePath: tt.file, Id: 1, host: host}, sources, "feature/templates")
+		if err != nil {
+			t.Fatalf("%s: templateWrapper failed: %v", tt.file, err)
+		}
+
+		var got wrapperPipeline
+		if err := yaml.Unmarshal([]byte(wrapper), &got); err != nil {
+			t.Fatalf("%s: failed to parse wrapper %s: %v", tt.file, wrapper, err)
+		}
+		if got.Trigger != "none" || got.Pr != "none" {
+			t.Errorf("%s: got trigger %q and pr %q, want none", tt.file, got.Trigger, got.Pr)
+		}
+		if len(got.Resources.Repositories) != 1 || !reflect.DeepEqual(got.Resources.Repositories[0], wantRepository) {
+			t.Errorf("%s: got repositories %v, want %v", tt.file, got.Resources.Repositories, wantRepository)
+		}
+
+		lists := map[string][]map[string]any{"stages": got.Stages, "jobs": got.Jobs, "steps": got.Steps, "variables": got.Variables}
+		wantItem := []map[string]any{{"template": tt.file + "@" + templateHostAlias, "parameters": map[string]any{"env": "dev"}}}
+		for kind, items := range lists {
+			var want []map[string]any
+			switch {
+			case kind == tt.kind:
+				want = wantItem
+			case kind == "steps":
+				want = tt.wantSteps
+			}
+			if !reflect.DeepEqual(items, want) {
+				t.Errorf("%s: got %s %v, want %v", tt.file, kind, items, want)
+			}
+		}
+	}
+}
+
+func TestTemplateWrapperConfigured(t *testing.T) {
+	host := &TemplateHost{
+		Templates:  []string{"/templates/**"},
+		PipelineId: 1,
+		Wrapper:    "resources:\n  repositories:\n  - repository: templates\n    ref: {{ref}}\nextends:\n  template: {{template}}@templates\n",
+	}
+
+	// A configured wrapper does not need the sources
+	wrapper, err := ValidationClient{}.templateWrapper(Pipeline{FilePath: "templates/steps.yml", Id: 1, host: host}, nil, "main")
+	if err != nil {
+		t.Fatalf("templateWrapper failed: %v", err)
+	}
+	if want := "resources:\n  repositories:\n  - repository: templates\n    ref: refs/heads/main\nextends:\n  template: /templates/steps.yml@templates\n"; wrapper != want {
+		t.Errorf("got wrapper %q, want %q", wrapper, want)
+	}
+}
+
+func TestTemplateWrapperErrors(t *testing.T) {
+	sources := fstest.MapFS{
+		"templates/parameters.yml": {Data: []byte("parameters:\n- name: env\n")},
+	}
+	c := ValidationClient{environment: &AzureDevOpsEnvironment{project: "project", repositoryName: "repo"}}
+	host := &TemplateHost{Templates: []string{"/templates/**"}, PipelineId: 1}
+
+	tests := []struct {
+		file    string
+		sources fs.FS
+		wantErr string
+	}{
+		{file: "/templates/parameters.yml", sources: sources, wantErr: "template has none of"},
+		{file: "/templates/missing.yml", sources: sources, wantErr: "failed to read"},
+		{file: "/templates/steps.yml", wantErr: "needs a wrapper"},
+	}
+	for _, tt := range tests {
+		_, err := c.templateWrapper(Pipeline{FilePath: tt.file, Id: 1, host: host}, tt.sources, "main")
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.file, err, tt.wantErr)
+		}
+	}
+}
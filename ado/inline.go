@@ -0,0 +1,649 @@
+package ado
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxTemplateDepth is the maximum template nesting depth, the same limit Azure Pipelines enforces
+const maxTemplateDepth = 100
+
+// templateListKeys are the keys whose values are lists that templates can insert items into
+var templateListKeys = []string{"stages", "jobs", "steps", "variables"}
+
+var (
+	// templateExpressionBlock matches a single ${{ }} template expression
+	templateExpressionBlock = regexp.MustCompile(`\$\{\{(.*?)\}\}`)
+	// parameterReference matches a parameter reference inside a template expression
+	parameterReference = regexp.MustCompile(`\bparameters(?:\.([A-Za-z_][A-Za-z0-9_]*)|\[\s*'([^']+)'\s*\])`)
+	// wholeParameterReference matches a scalar that consists of a single parameter reference
+	wholeParameterReference = regexp.MustCompile(`^\s*\$\{\{\s*parameters(?:\.([A-Za-z_][A-Za-z0-9_]*)|\[\s*'([^']+)'\s*\])\s*\}\}\s*$`)
+)
+
+// errCannotInline is returned when a template can't be inlined without evaluating expressions, in which case the
+// template reference is left for Azure DevOps to resolve
+var errCannotInline = errors.New("template can't be inlined")
+
+// templateInliner produces a single pipeline document from a root pipeline and the local versions of its templates,
+// so template changes that have not been pushed yet can be sent to the Preview API as a YAML override.
+//
+// Template parameters are substituted into the inlined content. Scalar values are inserted as literals, and object
+// values used inside expressions, for example in ${{ each }}, become object parameters of the root pipeline with the
+// passed value as their default.
+type templateInliner struct {
+	sources        fs.FS
+	project        string
+	repositoryName string
+	// changed are the files that differ from the remote version. Only templates that are changed or lead to a changed
+	// template are inlined, so errors in other templates keep their original file and line in Preview results.
+	changed map[string]bool
+
+	repositories    map[string]string
+	rootParameters  *yaml.Node
+	parameterCount  int
+	needsInlineMemo map[string]bool
+}
+
+// templateScope maps the parameter names of a template to the values passed to it
+type templateScope map[string]*yaml.Node
+
+// inlineLocalTemplates returns the given content of the root pipeline file with its changed local templates inlined.
+// Errors from inlined templates are reported by the Preview API against the root file, with lines of the generated
+// document that match neither the root file nor the template, see dropLocations. The content is returned unchanged
+// when no referenced template changed.
+func inlineLocalTemplates(sources fs.FS, project string, repositoryName string, rootFile string, content []byte, changed map[string]bool) (string, error) {
+	rootFile = normalizePipelinePath(rootFile)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return "", fmt.Errorf("inlineLocalTemplates: failed to parse %s: %w", rootFile, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return string(content), nil
+	}
+	root := doc.Content[0]
+
+	parsed, err := parsePipelineFile(content)
+	if err != nil {
+		return "", fmt.Errorf("inlineLocalTemplates: %w", err)
+	}
+
+	in := templateInliner{
+		sources:         sources,
+		project:         project,
+		repositoryName:  repositoryName,
+		changed:         changed,
+		repositories:    parsed.repositories,
+		needsInlineMemo: make(map[string]bool),
+	}
+
+	if !in.referencesChange(rootFile, parsed, 0) {
+		return string(content), nil
+	}
+
+	if err := in.expand(root, rootFile, "", 0); err != nil {
+		return "", fmt.Errorf("inlineLocalTemplates: %w", err)
+	}
+	if err := in.inlineExtends(root, rootFile); err != nil {
+		return "", fmt.Errorf("inlineLocalTemplates: %w", err)
+	}
+	in.declareRootParameters(root)
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return "", fmt.Errorf("inlineLocalTemplates: failed to serialize pipeline: %w", err)
+	}
+
+	return string(out), nil
+}
+
+// dropLocations clears the line and column of the diagnostics in the given file. It is used when the file was sent as a
+// generated document, so the reported lines do not point into the file on disk and would misplace annotations, ignore
+// comments and fingerprints.
+func (r *ValidationResult) dropLocations(file string) {
+	file = normalizePipelinePath(file)
+	for i := range r.diagnostics {
+		if normalizePipelinePath(r.diagnostics[i].File) == file {
+			r.diagnostics[i].Line = 0
+			r.diagnostics[i].Column = 0
+		}
+	}
+}
+
+// localTemplatePath returns the repository path of a template reference if it points to the local repository
+func (in *templateInliner) localTemplatePath(includingFile string, ref templateReference) (string, bool) {
+	if ref.RepositoryAlias != "" && ref.RepositoryAlias != selfRepositoryAlias &&
+		!isRepositoryName(in.repositories[ref.RepositoryAlias], in.project, in.repositoryName) {
+		return "", false
+	}
+
+	return resolveTemplatePath(includingFile, ref.Path), true
+}
+
+// needsInlining checks whether a template changed or references, directly or transitively, a local template that
+// changed
+func (in *templateInliner) needsInlining(file string, depth int) bool {
+	if result, ok := in.needsInlineMemo[file]; ok {
+		return result
+	}
+	if in.changed[file] {
+		in.needsInlineMemo[file] = true
+		return true
+	}
+	if depth > maxTemplateDepth {
+		return false
+	}
+	// Guards against reference cycles while the result is being computed
+	in.needsInlineMemo[file] = false
+
+	content, err := fs.ReadFile(in.sources, strings.TrimPrefix(file, "/"))
+	if err != nil {
+		return false
+	}
+	parsed, err := parsePipelineFile(content)
+	if err != nil {
+		return false
+	}
+
+	result := in.referencesChange(file, parsed, depth)
+	in.needsInlineMemo[file] = result
+	return result
+}
+
+// referencesChange checks whether any local template referenced by a file needs inlining
+func (in *templateInliner) referencesChange(file string, parsed pipelineFile, depth int) bool {
+	for _, ref := range parsed.references {
+		if templateFile, ok := in.localTemplatePath(file, ref); ok && in.needsInlining(templateFile, depth+1) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expand replaces template references in lists under the given node with the content of the referenced local
+// templates. kind is the key of the list the node is in, like steps or jobs.
+func (in *templateInliner) expand(node *yaml.Node, file string, kind string, depth int) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childKind := kind
+			for _, key := range templateListKeys {
+				if node.Content[i].Value == key {
+					childKind = key
+				}
+			}
+			if err := in.expand(node.Content[i+1], file, childKind, depth); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for _, item := range node.Content {
+			inserted, err := in.expandItem(item, file, kind, depth)
+			if err != nil {
+				return err
+			}
+			content = append(content, inserted...)
+		}
+		node.Content = content
+	}
+
+	return nil
+}
+
+// expandItem returns the items a list item expands to: the content of the referenced template for template references
+// that need inlining, otherwise the item itself with its own content expanded
+func (in *templateInliner) expandItem(item *yaml.Node, file string, kind string, depth int) ([]*yaml.Node, error) {
+	templateValue := mappingValue(item, "template")
+	if templateValue == nil || templateValue.Kind != yaml.ScalarNode {
+		return []*yaml.Node{item}, in.expand(item, file, kind, depth)
+	}
+	// Template paths built from expressions can't be resolved without evaluating the pipeline
+	if strings.Contains(templateValue.Value, "$") {
+		return []*yaml.Node{item}, nil
+	}
+
+	ref := parseTemplateReference(templateValue.Value)
+	templateFile, ok := in.localTemplatePath(file, ref)
+	if !ok {
+		return []*yaml.Node{item}, nil
+	}
+	// Azure DevOps resolves relative paths from the root file once a kept reference is inlined into it
+	anchoredValue := templateFile
+	if ref.RepositoryAlias != "" {
+		anchoredValue += "@" + ref.RepositoryAlias
+	}
+	if !in.needsInlining(templateFile, depth) {
+		templateValue.Value = anchoredValue
+		return []*yaml.Node{item}, nil
+	}
+
+	declared := in.rootParameterCount()
+	templateRoot, err := in.loadTemplate(templateFile, mappingValue(item, "parameters"), depth)
+	var list *yaml.Node
+	if err == nil {
+		list, err = templateList(templateRoot, templateFile, kind)
+	}
+	if errors.Is(err, errCannotInline) {
+		in.dropRootParameters(declared)
+		templateValue.Value = anchoredValue
+		return []*yaml.Node{item}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return list.Content, nil
+}
+
+// templateList returns the list of a template that is inserted in place of its reference. It returns errCannotInline
+// if the list is not a sequence, for example variables in mapping form or a list built by an expression.
+func templateList(templateRoot *yaml.Node, templateFile string, kind string) (*yaml.Node, error) {
+	list := mappingValue(templateRoot, kind)
+	if list == nil {
+		for _, key := range templateListKeys {
+			if list = mappingValue(templateRoot, key); list != nil {
+				break
+			}
+		}
+	}
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%w: template %s has no %s list to insert", errCannotInline, templateFile, kind)
+	}
+
+	return list, nil
+}
+
+// rootParameterCount returns the number of nodes in the root pipeline's parameters, to drop the ones added for a
+// template that is not inlined after all
+func (in *templateInliner) rootParameterCount() int {
+	if in.rootParameters == nil {
+		return 0
+	}
+
+	return len(in.rootParameters.Content)
+}
+
+// dropRootParameters removes the root parameters added since rootParameterCount returned the given count
+func (in *templateInliner) dropRootParameters(count int) {
+	if count == 0 {
+		in.rootParameters = nil
+		return
+	}
+	in.rootParameters.Content = in.rootParameters.Content[:count]
+}
+
+// loadTemplate reads a template, substitutes the passed parameters and expands its own template references
+func (in *templateInliner) loadTemplate(templateFile string, passed *yaml.Node, depth int) (*yaml.Node, error) {
+	if depth >= maxTemplateDepth {
+		return nil, fmt.Errorf("template %s exceeds the maximum nesting depth of %d", templateFile, maxTemplateDepth)
+	}
+
+	content, err := fs.ReadFile(in.sources, strings.TrimPrefix(templateFile, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template %s: %w", templateFile, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", templateFile, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("template %s is not a mapping", templateFile)
+	}
+	templateRoot := doc.Content[0]
+
+	scope := newTemplateScope(mappingValue(templateRoot, "parameters"), passed)
+	removeMappingKey(templateRoot, "parameters")
+
+	// Substituting into a copy keeps the original intact if the template turns out not to be inlinable
+	substituted := cloneNode(templateRoot)
+	declared := in.rootParameterCount()
+	if err := in.substitute(substituted, scope); err != nil {
+		in.dropRootParameters(declared)
+		return nil, err
+	}
+	if err := in.expand(substituted, templateFile, "", depth+1); err != nil {
+		return nil, err
+	}
+
+	return substituted, nil
+}
+
+// inlineExtends replaces a local extends template with its content, merged into the root pipeline
+func (in *templateInliner) inlineExtends(root *yaml.Node, rootFile string) error {
+	extends := mappingValue(root, "extends")
+	templateValue := mappingValue(extends, "template")
+	if templateValue == nil || templateValue.Kind != yaml.ScalarNode || strings.Contains(templateValue.Value, "$") {
+		return nil
+	}
+
+	templateFile, ok := in.localTemplatePath(rootFile, parseTemplateReference(templateValue.Value))
+	if !ok || !in.needsInlining(templateFile, 0) {
+		return nil
+	}
+
+	templateRoot, err := in.loadTemplate(templateFile, mappingValue(extends, "parameters"), 0)
+	if errors.Is(err, errCannotInline) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	removeMappingKey(root, "extends")
+	for i := 0; i+1 < len(templateRoot.Content); i += 2 {
+		key, value := templateRoot.Content[i], templateRoot.Content[i+1]
+		existing := mappingValue(root, key.Value)
+		switch {
+		case existing == nil:
+			root.Content = append(root.Content, key, value)
+		case existing.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode:
+			existing.Content = append(existing.Content, value.Content...)
+		default:
+			*existing = *value
+		}
+	}
+
+	return nil
+}
+
+// newTemplateScope builds the parameter values of a template from its declarations and the passed parameters
+func newTemplateScope(declarations *yaml.Node, passed *yaml.Node) templateScope {
+	scope := make(templateScope)
+
+	if declarations != nil {
+		switch declarations.Kind {
+		case yaml.SequenceNode:
+			for _, declaration := range declarations.Content {
+				name := mappingValue(declaration, "name")
+				if name == nil {
+					continue
+				}
+				if value := mappingValue(declaration, "default"); value != nil {
+					scope[name.Value] = typedParameterValue(value, mappingValue(declaration, "type"))
+				}
+			}
+		case yaml.MappingNode:
+			// Old syntax, where parameters map names to default values
+			for i := 0; i+1 < len(declarations.Content); i += 2 {
+				scope[declarations.Content[i].Value] = declarations.Content[i+1]
+			}
+		}
+	}
+
+	if passed != nil && passed.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(passed.Content); i += 2 {
+			scope[passed.Content[i].Value] = passed.Content[i+1]
+		}
+	}
+
+	return scope
+}
+
+// typedParameterValue tags scalar defaults of boolean and number parameters, so they are inserted as literals of the
+// right type
+func typedParameterValue(value *yaml.Node, declaredType *yaml.Node) *yaml.Node {
+	if value.Kind != yaml.ScalarNode || declaredType == nil {
+		return value
+	}
+
+	typed := cloneNode(value)
+	switch strings.ToLower(declaredType.Value) {
+	case "boolean":
+		if _, err := strconv.ParseBool(value.Value); err == nil {
+			typed.Tag = "!!bool"
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(value.Value, 64); err == nil {
+			typed.Tag = "!!float"
+		}
+	}
+
+	return typed
+}
+
+// substitute replaces parameter references in the node tree with the values in scope
+func (in *templateInliner) substitute(node *yaml.Node, scope templateScope) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if value, ok := scope.wholeReference(node); ok {
+			*node = *cloneNode(value)
+			return nil
+		}
+		rewritten, err := in.rewriteExpressions(node.Value, scope)
+		if err != nil {
+			return err
+		}
+		node.Value = rewritten
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			rewritten, err := in.rewriteExpressions(node.Content[i].Value, scope)
+			if err != nil {
+				return err
+			}
+			node.Content[i].Value = rewritten
+			if err := in.substitute(node.Content[i+1], scope); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for _, item := range node.Content {
+			// Lists passed as parameters are spliced into the list they are inserted in
+			if value, ok := scope.wholeReference(item); ok && value.Kind == yaml.SequenceNode {
+				for _, child := range value.Content {
+					content = append(content, cloneNode(child))
+				}
+				continue
+			}
+			if err := in.substitute(item, scope); err != nil {
+				return err
+			}
+			content = append(content, item)
+		}
+		node.Content = content
+	}
+
+	return nil
+}
+
+// wholeReference returns the value of a parameter if the node is a scalar consisting of only a reference to it
+func (s templateScope) wholeReference(node *yaml.Node) (*yaml.Node, bool) {
+	if node.Kind != yaml.ScalarNode {
+		return nil, false
+	}
+
+	match := wholeParameterReference.FindStringSubmatch(node.Value)
+	if match == nil {
+		return nil, false
+	}
+	name := match[1]
+	if name == "" {
+		name = match[2]
+	}
+
+	value, ok := s[name]
+	return value, ok
+}
+
+// rewriteExpressions replaces parameter references inside the template expressions of a string with expressions for
+// their values. It returns errCannotInline if a referenced parameter has no value in the template's scope.
+func (in *templateInliner) rewriteExpressions(text string, scope templateScope) (string, error) {
+	if !strings.Contains(text, "${{") {
+		return text, nil
+	}
+
+	var rewriteErr error
+	result := templateExpressionBlock.ReplaceAllStringFunc(text, func(block string) string {
+		return parameterReference.ReplaceAllStringFunc(block, func(reference string) string {
+			match := parameterReference.FindStringSubmatch(reference)
+			name := match[1]
+			if name == "" {
+				name = match[2]
+			}
+
+			// A reference that is neither passed nor defaulted would bind to a parameter of the root pipeline once
+			// inlined, so the template is left for Azure DevOps, which reports the missing value
+			value, ok := scope[name]
+			if !ok {
+				rewriteErr = fmt.Errorf("%w: parameter %s has no value", errCannotInline, name)
+				return reference
+			}
+			expression, err := in.valueExpression(value)
+			if err != nil {
+				rewriteErr = err
+				return reference
+			}
+			return expression
+		})
+	})
+
+	return result, rewriteErr
+}
+
+// valueExpression returns an expression evaluating to the given parameter value
+func (in *templateInliner) valueExpression(value *yaml.Node) (string, error) {
+	if value.Kind == yaml.AliasNode {
+		value = value.Alias
+	}
+
+	if value.Kind == yaml.ScalarNode {
+		if match := templateExpressionBlock.FindStringSubmatch(value.Value); match != nil && strings.TrimSpace(value.Value) == match[0] {
+			return strings.TrimSpace(match[1]), nil
+		}
+		if strings.Contains(value.Value, "${{") {
+			return formatExpression(value.Value), nil
+		}
+
+		switch value.ShortTag() {
+		case "!!bool", "!!int", "!!float":
+			return value.Value, nil
+		case "!!null":
+			return "null", nil
+		default:
+			return "'" + strings.ReplaceAll(value.Value, "'", "''") + "'", nil
+		}
+	}
+
+	// Defaults of root parameters can't contain expressions
+	if containsTemplateExpression(value) {
+		return "", errCannotInline
+	}
+
+	name := fmt.Sprintf("inlined_%d", in.parameterCount)
+	in.parameterCount++
+	in.addRootParameter(name, value)
+
+	return "parameters." + name, nil
+}
+
+// formatExpression turns a string with embedded template expressions into a format expression evaluating to the same
+// string, for example "v${{ parameters.major }}" into format('v{0}', parameters.major)
+func formatExpression(text string) string {
+	escape := strings.NewReplacer("'", "''", "{", "{{", "}", "}}")
+
+	var format strings.Builder
+	var args []string
+	last := 0
+	for _, match := range templateExpressionBlock.FindAllStringSubmatchIndex(text, -1) {
+		format.WriteString(escape.Replace(text[last:match[0]]))
+		format.WriteString(fmt.Sprintf("{%d}", len(args)))
+		args = append(args, strings.TrimSpace(text[match[2]:match[3]]))
+		last = match[1]
+	}
+	format.WriteString(escape.Replace(text[last:]))
+
+	return fmt.Sprintf("format('%s', %s)", format.String(), strings.Join(args, ", "))
+}
+
+// addRootParameter declares an object parameter on the root pipeline with the given default value
+func (in *templateInliner) addRootParameter(name string, value *yaml.Node) {
+	if in.rootParameters == nil {
+		in.rootParameters = &yaml.Node{Kind: yaml.SequenceNode}
+	}
+
+	in.rootParameters.Content = append(in.rootParameters.Content, &yaml.Node{
+		Kind: yaml.MappingNode,
+		Content: []*yaml.Node{
+			scalarNode("name"), scalarNode(name),
+			scalarNode("type"), scalarNode("object"),
+			scalarNode("default"), cloneNode(value),
+		},
+	})
+}
+
+// declareRootParameters adds the parameters created for object values to the root pipeline's parameters
+func (in *templateInliner) declareRootParameters(root *yaml.Node) {
+	if in.rootParameters == nil {
+		return
+	}
+
+	existing := mappingValue(root, "parameters")
+	switch {
+	case existing == nil:
+		root.Content = append([]*yaml.Node{scalarNode("parameters"), in.rootParameters}, root.Content...)
+	case existing.Kind == yaml.SequenceNode:
+		existing.Content = append(existing.Content, in.rootParameters.Content...)
+	case existing.Kind == yaml.MappingNode:
+		// Old syntax, where parameters map names to default values
+		for _, declaration := range in.rootParameters.Content {
+			existing.Content = append(existing.Content, scalarNode(mappingValue(declaration, "name").Value), mappingValue(declaration, "default"))
+		}
+	}
+}
+
+// containsTemplateExpression checks whether any scalar in the node tree contains a template expression
+func containsTemplateExpression(node *yaml.Node) bool {
+	if isTemplateExpression(node) {
+		return true
+	}
+	for _, child := range node.Content {
+		if containsTemplateExpression(child) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cloneNode deep copies a YAML node tree
+func cloneNode(node *yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+
+	clone := *node
+	if node.Content != nil {
+		clone.Content = make([]*yaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			clone.Content[i] = cloneNode(child)
+		}
+	}
+
+	return &clone
+}
+
+// removeMappingKey removes a key and its value from a mapping node
+func removeMappingKey(node *yaml.Node, key string) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
@@ -0,0 +1,320 @@
+package ado
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestInlineLocalTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		root    string
+		sources fstest.MapFS
+		changed []string
+		want    string
+	}{
+		{
+			name: "passed and default parameters",
+			root: `steps:
+- template: ../templates/steps.yml
+  parameters:
+    message: hello
+`,
+			sources: fstest.MapFS{
+				"templates/steps.yml": {Data: []byte(`parameters:
+- name: message
+  type: string
+- name: directory
+  type: string
+  default: src
+steps:
+- script: echo ${{ parameters.message }}
+  workingDirectory: ${{ parameters.directory }}
+`)},
+			},
+			changed: []string{"/templates/steps.yml"},
+			want: `steps:
+- script: echo ${{ 'hello' }}
+  workingDirectory: src
+`,
+		},
+		{
+			name: "parameter without value keeps the reference",
+			root: `steps:
+- template: ../templates/steps.yml
+`,
+			sources: fstest.MapFS{
+				"templates/steps.yml": {Data: []byte(`parameters:
+- name: message
+  type: string
+steps:
+- script: echo ${{ parameters.message }}
+`)},
+			},
+			changed: []string{"/templates/steps.yml"},
+			want: `steps:
+- template: /templates/steps.yml
+`,
+		},
+		{
+			name: "root parameter referenced by a template keeps the reference",
+			root: `parameters:
+- name: message
+  type: string
+  default: root
+steps:
+- template: ../templates/steps.yml
+`,
+			sources: fstest.MapFS{
+				"templates/steps.yml": {Data: []byte(`steps:
+- script: echo ${{ parameters.message }}
+`)},
+			},
+			changed: []string{"/templates/steps.yml"},
+			want: `parameters:
+- name: message
+  type: string
+  default: root
+steps:
+- template: /templates/steps.yml
+`,
+		},
+		{
+			name: "conditional insertion",
+			root: `steps:
+- template: ../templates/steps.yml
+  parameters:
+    debug: true
+`,
+			sources: fstest.MapFS{
+				"templates/steps.yml": {Data: []byte(`parameters:
+- name: debug
+  type: boolean
+  default: false
+steps:
+- ${{ if eq(parameters.debug, true) }}:
+  - script: echo debug
+- script: echo build
+`)},
+			},
+			changed: []string{"/templates/steps.yml"},
+			want: `steps:
+- ${{ if eq(true, true) }}:
+  - script: echo debug
+- script: echo build
+`,
+		},
+		{
+			name: "each over an object parameter",
+			root: `steps:
+- template: ../templates/steps.yml
+  parameters:
+    projects: [api, web]
+`,
+			sources: fstest.MapFS{
+				"templates/steps.yml": {Data: []byte(`parameters:
+- name: projects
+  type: object
+steps:
+- ${{ each project in parameters.projects }}:
+  - script: dotnet build ${{ project }}
+`)},
+			},
+			changed: []string{"/templates/steps.yml"},
+			want: `parameters:
+- name: inlined_0
+  type: object
+  default: [api, web]
+steps:
+- ${{ each project in parameters.inlined_0 }}:
+  - script: dotnet build ${{ project }}
+`,
+		},
+		{
+			name: "step list parameter",
+			root: `steps:
+- template: ../templates/steps.yml
+  parameters:
+    preSteps:
+    - script: echo pre
+`,
+			sources: fstest.MapFS{
+				"templates/steps.yml": {Data: []byte(`parameters:
+- name: preSteps
+  type: stepList
+  default: []
+steps:
+- ${{ parameters.preSteps }}
+- script: echo build
+`)},
+			},
+			changed: []string{"/templates/steps.yml"},
+			want: `steps:
+- script: echo pre
+- script: echo build
+`,
+		},
+		{
+			name: "extends",
+			root: `trigger: none
+extends:
+  template: ../templates/base.yml
+  parameters:
+    vmImage: ubuntu-latest
+`,
+			sources: fstest.MapFS{
+				"templates/base.yml": {Data: []byte(`parameters:
+- name: vmImage
+  type: string
+stages:
+- stage: build
+  jobs:
+  - job: build
+    pool:
+      vmImage: ${{ parameters.vmImage }}
+    steps:
+    - script: echo build
+`)},
+			},
+			changed: []string{"/templates/base.yml"},
+			want: `trigger: none
+stages:
+- stage: build
+  jobs:
+  - job: build
+    pool:
+      vmImage: ubuntu-latest
+    steps:
+    - script: echo build
+`,
+		},
+		{
+			name: "nested templates",
+			root: `jobs:
+- template: ../templates/jobs.yml
+  parameters:
+    name: api
+`,
+			sources: fstest.MapFS{
+				"templates/jobs.yml": {Data: []byte(`parameters:
+- name: name
+  type: string
+jobs:
+- job: ${{ parameters.name }}
+  steps:
+  - template: steps.yml
+    parameters:
+      project: ${{ parameters.name }}
+`)},
+				"templates/steps.yml": {Data: []byte(`parameters:
+- name: project
+  type: string
+steps:
+- script: dotnet build ${{ parameters.project }}
+`)},
+			},
+			changed: []string{"/templates/steps.yml"},
+			want: `jobs:
+- job: api
+  steps:
+  - script: dotnet build ${{ 'api' }}
+`,
+		},
+		{
+			name: "nested template without a passed parameter",
+			root: `jobs:
+- template: ../templates/jobs.yml
+`,
+			sources: fstest.MapFS{
+				"templates/jobs.yml": {Data: []byte(`jobs:
+- job: build
+  steps:
+  - template: steps.yml
+`)},
+				"templates/steps.yml": {Data: []byte(`parameters:
+- name: project
+  type: string
+steps:
+- script: dotnet build ${{ parameters.project }}
+`)},
+			},
+			changed: []string{"/templates/jobs.yml", "/templates/steps.yml"},
+			want: `jobs:
+- job: build
+  steps:
+  - template: /templates/steps.yml
+`,
+		},
+		{
+			name: "variables in mapping form keep the reference",
+			root: `variables:
+- template: ../templates/variables.yml
+  parameters:
+    settings:
+      verbose: true
+steps:
+- script: echo
+`,
+			sources: fstest.MapFS{
+				"templates/variables.yml": {Data: []byte(`parameters:
+- name: settings
+  type: object
+variables:
+  ${{ each setting in parameters.settings }}:
+    ${{ setting.key }}: ${{ setting.value }}
+`)},
+			},
+			changed: []string{"/templates/variables.yml"},
+			want: `variables:
+- template: /templates/variables.yml
+  parameters:
+    settings:
+      verbose: true
+steps:
+- script: echo
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed := make(map[string]bool)
+			for _, file := range tt.changed {
+				changed[file] = true
+			}
+
+			got, err := inlineLocalTemplates(tt.sources, "project", "repo", "/pipelines/ci.yml", []byte(tt.root), changed)
+			if err != nil {
+				t.Fatalf("inlineLocalTemplates failed: %v", err)
+			}
+
+			var gotValue, wantValue any
+			if err := yaml.Unmarshal([]byte(got), &gotValue); err != nil {
+				t.Fatalf("failed to parse result: %v\n%s", err, got)
+			}
+			if err := yaml.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+				t.Fatalf("failed to parse expected result: %v", err)
+			}
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInlineLocalTemplatesUnchanged(t *testing.T) {
+	root := "steps:\n- template: ../templates/steps.yml\n"
+	sources := fstest.MapFS{
+		"templates/steps.yml": {Data: []byte("steps:\n- script: echo\n")},
+	}
+
+	got, err := inlineLocalTemplates(sources, "project", "repo", "/pipelines/ci.yml", []byte(root), map[string]bool{"/other.yml": true})
+	if err != nil {
+		t.Fatalf("inlineLocalTemplates failed: %v", err)
+	}
+	if got != root {
+		t.Errorf("got\n%s\nwant the unchanged content", got)
+	}
+}
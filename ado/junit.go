@@ -0,0 +1,89 @@
+package ado
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// JUnit XML as understood by the Publish Test Results task: https://learn.microsoft.com/en-us/azure/devops/pipelines/tasks/reference/publish-test-results-v2#result-formats-mapping
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+const junitSuiteName = "ado-yaml-validator"
+
+func (r *Report) renderJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		Name:      junitSuiteName,
+		Tests:     len(r.Results),
+		Failures:  r.Failed(),
+		Time:      fmt.Sprintf("%.3f", r.Duration().Seconds()),
+		TestCases: make([]junitTestCase, 0, len(r.Results)),
+	}
+
+	for _, result := range r.Results {
+		testCase := junitTestCase{
+			Name:      result.Name(),
+			ClassName: fmt.Sprintf("pipeline.%d", result.PipelineId),
+			Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+		}
+		if result.Status == StatusFailed {
+			messages := result.Messages()
+			testCase.Failure = &junitFailure{
+				Content: strings.Join(messages, "\n"),
+			}
+			if len(messages) > 0 {
+				testCase.Failure.Message = messages[0]
+			}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	out := junitTestSuites{
+		Name:     junitSuiteName,
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(out); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
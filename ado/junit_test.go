@@ -0,0 +1,33 @@
+package ado
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenderJUnit(t *testing.T) {
+	var out bytes.Buffer
+	if err := testReport().Render(&out, OutputJUnit); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="ado-yaml-validator" tests="4" failures="3" time="1.750">
+  <testsuite name="ado-yaml-validator" tests="4" failures="3" time="1.750">
+    <testcase name="/azure-pipelines.yml" classname="pipeline.1" time="1.500"></testcase>
+    <testcase name="/pipelines/build.yml (environment=dev)" classname="pipeline.2" time="0.250">
+      <failure message="/templates/steps #1.yml (Line: 12, Col: 5): Unexpected value &#39;scripts&#39;">/templates/steps #1.yml (Line: 12, Col: 5): Unexpected value &#39;scripts&#39;&#xA;/pipelines/build.yml (Line: 3, Col: 1): A template expression is not allowed here (pre-existing)</failure>
+    </testcase>
+    <testcase name="/pipelines/deploy app.yml" classname="pipeline.3" time="0.000">
+      <failure message="TF401019: The Git repository does not exist">TF401019: The Git repository does not exist</failure>
+    </testcase>
+    <testcase name="/pipelines/release.yml" classname="pipeline.4" time="0.000">
+      <failure message="context canceled">context canceled</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if got := out.String(); got != want {
+		t.Errorf("got JUnit report\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,287 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// getLocalRepoRoot returns the root directory of the git repository containing the current working directory
+func getLocalRepoRoot() (string, error) {
+	out, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return "", fmt.Errorf("getLocalRepoRoot: failed to determine git repository root: %w", err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// getLocalChangedYamlFiles returns the YAML files in the working tree that differ from the given branch, including
+// untracked files. Paths are returned relative to the repository root with a leading slash, which is the format Azure
+// DevOps uses for pipeline file paths.
+func getLocalChangedYamlFiles(repoRoot string, compareBranch string) ([]string, error) {
+	compareRef, err := resolveLocalCompareRef(repoRoot, compareBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	diffCmd := exec.Command("git", "diff", "--name-only", "-z", "--diff-filter=d", compareRef, "--")
+	diffCmd.Dir = repoRoot
+	diffOut, err := diffCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("getLocalChangedYamlFiles: failed to diff against %s: %w", compareRef, err)
+	}
+
+	untrackedCmd := exec.Command("git", "ls-files", "-z", "--others", "--exclude-standard")
+	untrackedCmd.Dir = repoRoot
+	untrackedOut, err := untrackedCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("getLocalChangedYamlFiles: failed to list untracked files: %w", err)
+	}
+
+	// Paths are separated by NUL, as git quotes paths with special characters in line separated output
+	changedYamlFiles := make([]string, 0)
+	for _, file := range strings.Split(string(diffOut)+string(untrackedOut), "\x00") {
+		if file == "" {
+			continue
+		}
+		if filepath.Ext(file) == ".yaml" || filepath.Ext(file) == ".yml" {
+			changedYamlFiles = append(changedYamlFiles, "/"+file)
+		}
+	}
+
+	return changedYamlFiles, nil
+}
+
+// resolveLocalCompareRef returns a git ref for the given branch that exists locally, falling back to the origin remote
+// tracking branch when there is no local branch with that name.
+func resolveLocalCompareRef(repoRoot string, branch string) (string, error) {
+	branch = strings.TrimPrefix(branch, "refs/heads/")
+	for _, ref := range []string{branch, "origin/" + branch} {
+		cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref)
+		cmd.Dir = repoRoot
+		if err := cmd.Run(); err == nil {
+			return ref, nil
+		}
+	}
+
+	return "", fmt.Errorf("resolveLocalCompareRef: branch %s not found locally or on origin", branch)
+}
+
+// localRepoPath converts a path in the working tree to a repository path in the Azure DevOps format
+func localRepoPath(repoRoot string, file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", fmt.Errorf("localRepoPath: %w", err)
+	}
+	rel, err := filepath.Rel(repoRoot, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("localRepoPath: %s is outside of the repository %s", file, repoRoot)
+	}
+
+	return normalizePipelinePath(filepath.ToSlash(rel)), nil
+}
+
+// validatePipelineLocally validates a single pipeline using the local contents of its YAML file as an override. Local
+// templates that changed, directly or through templates they reference, are inlined into the override, as the Preview
+// API would otherwise resolve them from the run branch.
+func (c ValidationClient) validatePipelineLocally(ctx context.Context, repoRoot string, changed map[string]bool, pipeline Pipeline) (ValidationResult, error) {
+	result := ValidationResult{
+		pipelineId:   pipeline.Id,
+		pipelinePath: pipeline.FilePath,
+	}
+
+	sources := os.DirFS(repoRoot)
+	var content []byte
+	var err error
+	if pipeline.host != nil {
+		var wrapper string
+		wrapper, err = c.templateWrapper(pipeline, sources, c.environment.runBranch)
+		content = []byte(wrapper)
+	} else {
+		content, err = fs.ReadFile(sources, strings.TrimPrefix(normalizePipelinePath(pipeline.FilePath), "/"))
+	}
+	if err != nil {
+		return result, fmt.Errorf("validatePipelineLocally: failed to read %s: %w", pipeline.FilePath, err)
+	}
+
+	override, err := inlineLocalTemplates(sources, c.environment.project, c.environment.repositoryName, pipeline.FilePath, content, changed)
+	if err != nil {
+		return result, err
+	}
+
+	args := c.newPreviewPipelineArgs(pipeline, withYamlOverride(override))
+	start := time.Now()
+	run, err := c.callPreviewApi(ctx, args)
+	result.duration = time.Since(start)
+	result.setPreviewRun(run, err)
+	if override != string(content) {
+		result.dropLocations(pipeline.FilePath)
+	}
+
+	return result, nil
+}
+
+// localPipelines are pipelines selected for validation with the local working tree
+type localPipelines struct {
+	repoRoot string
+	// changed holds the repository paths of the YAML files that differ from the run branch
+	changed map[string]bool
+	pipes   []Pipeline
+}
+
+// newLocalPipelines collects the pipelines to validate locally, one per parameter combination when validating a
+// parameter matrix
+func (c ValidationClient) newLocalPipelines(repoRoot string, changes []string, pipes []Pipeline) *localPipelines {
+	changed := make(map[string]bool, len(changes))
+	for _, file := range changes {
+		changed[normalizePipelinePath(file)] = true
+	}
+
+	return &localPipelines{
+		repoRoot: repoRoot,
+		changed:  changed,
+		pipes:    c.expandPipelineParameters(pipes, os.DirFS(repoRoot)),
+	}
+}
+
+// ValidateAllLocalChanges validates all pipelines whose YAML files differ from the run branch in the local working
+// tree. The local file contents are sent as a YAML override, so the changes do not need to be pushed first.
+func (c ValidationClient) ValidateAllLocalChanges(ctx context.Context) (*Report, error) {
+	local, err := c.getLocalChangedPipelines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateAllLocalChanges: %w", err)
+	}
+
+	return c.validateLocalPipelines(ctx, local), nil
+}
+
+// getLocalChangedPipelines returns the pipelines affected by the YAML files that differ from the run branch in the
+// local working tree
+func (c ValidationClient) getLocalChangedPipelines(ctx context.Context) (*localPipelines, error) {
+	repoRoot, err := getLocalRepoRoot()
+	if err != nil {
+		return nil, fmt.Errorf("getLocalChangedPipelines: %w", err)
+	}
+
+	changes, err := getLocalChangedYamlFiles(repoRoot, c.environment.runBranch)
+	if err != nil {
+		return nil, fmt.Errorf("getLocalChangedPipelines: failed to get changed files: %w", err)
+	}
+	repo, err := c.getRepositoryPipelineGraph(ctx, os.DirFS(repoRoot))
+	if err != nil {
+		return nil, fmt.Errorf("getLocalChangedPipelines: %w", err)
+	}
+
+	return c.newLocalPipelines(repoRoot, changes, c.getChangedPipelines(repo, changes)), nil
+}
+
+// ValidateLocalPipelines validates the pipelines whose root files are given as paths in the working tree, whether they
+// changed or not, using the local working tree. Files no pipeline uses as its root file are validated through their
+// template host.
+func (c ValidationClient) ValidateLocalPipelines(ctx context.Context, files []string) (*Report, error) {
+	local, err := c.getLocalPipelines(ctx, files)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateLocalPipelines: %w", err)
+	}
+
+	return c.validateLocalPipelines(ctx, local), nil
+}
+
+// ValidateAllLocalPipelines validates every pipeline of the repository whose root file is in the local working tree,
+// whether it changed or not
+func (c ValidationClient) ValidateAllLocalPipelines(ctx context.Context) (*Report, error) {
+	repoRoot, err := getLocalRepoRoot()
+	if err != nil {
+		return nil, fmt.Errorf("ValidateAllLocalPipelines: %w", err)
+	}
+
+	changes, err := getLocalChangedYamlFiles(repoRoot, c.environment.runBranch)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateAllLocalPipelines: failed to get changed files: %w", err)
+	}
+	pipes, err := c.getRepositoryPipelines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateAllLocalPipelines: failed to get repository pipelines: %w", err)
+	}
+
+	sources := os.DirFS(repoRoot)
+	selected := make([]Pipeline, 0, len(pipes))
+	for _, pipeline := range pipes {
+		if _, err := fs.Stat(sources, strings.TrimPrefix(normalizePipelinePath(pipeline.FilePath), "/")); err == nil {
+			selected = append(selected, pipeline)
+		}
+	}
+
+	return c.validateLocalPipelines(ctx, c.newLocalPipelines(repoRoot, changes, selected)), nil
+}
+
+// getLocalPipelines returns the pipelines whose root files are given as paths in the working tree, falling back to
+// template hosts for files that are not the root file of any pipeline
+func (c ValidationClient) getLocalPipelines(ctx context.Context, files []string) (*localPipelines, error) {
+	repoRoot, err := getLocalRepoRoot()
+	if err != nil {
+		return nil, fmt.Errorf("getLocalPipelines: %w", err)
+	}
+
+	changes, err := getLocalChangedYamlFiles(repoRoot, c.environment.runBranch)
+	if err != nil {
+		return nil, fmt.Errorf("getLocalPipelines: failed to get changed files: %w", err)
+	}
+	pipes, err := c.getRepositoryPipelines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getLocalPipelines: failed to get repository pipelines: %w", err)
+	}
+
+	repoPaths := make([]string, 0, len(files))
+	requested := make(map[string]bool, len(files))
+	for _, file := range files {
+		repoPath, err := localRepoPath(repoRoot, file)
+		if err != nil {
+			return nil, fmt.Errorf("getLocalPipelines: %w", err)
+		}
+		repoPaths = append(repoPaths, repoPath)
+		requested[pipelinePathKey(repoPath)] = true
+	}
+
+	selected := make([]Pipeline, 0)
+	covered := make(map[string]bool)
+	for _, pipeline := range pipes {
+		if requested[pipelinePathKey(pipeline.FilePath)] {
+			selected = append(selected, pipeline)
+			covered[pipelinePathKey(pipeline.FilePath)] = true
+		}
+	}
+	hosted := c.getTemplateHostPipelines(repoPaths, covered)
+	for _, pipeline := range hosted {
+		covered[pipelinePathKey(pipeline.FilePath)] = true
+	}
+	selected = append(selected, hosted...)
+
+	for _, file := range repoPaths {
+		if !covered[pipelinePathKey(file)] {
+			return nil, fmt.Errorf("getLocalPipelines: no pipeline or template host found for %s", file)
+		}
+	}
+
+	return c.newLocalPipelines(repoRoot, changes, selected), nil
+}
+
+// validateLocalPipelines validates the given pipelines with their local files, inlining the changed templates. With a
+// baseline comparison, they are also validated on the run branch.
+func (c ValidationClient) validateLocalPipelines(ctx context.Context, local *localPipelines) *Report {
+	report := c.validatePipelines(ctx, local.pipes, func(ctx context.Context, pipeline Pipeline) (ValidationResult, error) {
+		return c.validatePipelineLocally(ctx, local.repoRoot, local.changed, pipeline)
+	})
+	report.annotateDiagnostics(os.DirFS(local.repoRoot))
+	if c.baseline {
+		report.ApplyBaseline(c.validatePipelinesOnBranch(ctx, local.pipes, c.environment.runBranch, os.DirFS(local.repoRoot)))
+	}
+
+	return report
+}
@@ -0,0 +1,140 @@
+package ado
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// runGit runs git with the given arguments in dir and fails the test if it does not succeed
+func runGit(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_GLOBAL="+os.DevNull,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+}
+
+// writeTestFile writes content to the file at the slash separated path below dir, creating its directories
+func writeTestFile(t *testing.T, dir string, file string, content string) {
+	t.Helper()
+
+	path := filepath.Join(dir, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestRepo creates a git repository with a commit on main and returns its root directory
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repoRoot := t.TempDir()
+	runGit(t, repoRoot, "init", "--quiet", "--initial-branch=main")
+	writeTestFile(t, repoRoot, "azure-pipelines.yml", "steps: []\n")
+	writeTestFile(t, repoRoot, "templates/steps.yml", "steps: []\n")
+	writeTestFile(t, repoRoot, "templates/removed.yml", "steps: []\n")
+	writeTestFile(t, repoRoot, "README.md", "readme\n")
+	runGit(t, repoRoot, "add", "-A")
+	runGit(t, repoRoot, "commit", "--quiet", "-m", "initial")
+
+	return repoRoot
+}
+
+func TestGetLocalChangedYamlFiles(t *testing.T) {
+	repoRoot := newTestRepo(t)
+	// git quotes paths with non-ASCII characters, quotes or control characters unless they are NUL separated
+	writeTestFile(t, repoRoot, "templates/ä.yml", "steps: []\n")
+	runGit(t, repoRoot, "add", "--all")
+	runGit(t, repoRoot, "commit", "--quiet", "-m", "Add a template with a non-ASCII name")
+	runGit(t, repoRoot, "checkout", "--quiet", "-b", "feature")
+	writeTestFile(t, repoRoot, "azure-pipelines.yml", "steps:\n- script: echo changed\n")
+	writeTestFile(t, repoRoot, "pipelines/new.yaml", "steps: []\n")
+	writeTestFile(t, repoRoot, "templates/ä.yml", "steps:\n- script: echo changed\n")
+	writeTestFile(t, repoRoot, "pipelines/deploy \"app\".yml", "steps: []\n")
+	writeTestFile(t, repoRoot, "README.md", "changed\n")
+	writeTestFile(t, repoRoot, "notes.txt", "untracked\n")
+	if err := os.Remove(filepath.Join(repoRoot, "templates", "removed.yml")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := getLocalChangedYamlFiles(repoRoot, "refs/heads/main")
+	if err != nil {
+		t.Fatalf("getLocalChangedYamlFiles() error = %v", err)
+	}
+	sort.Strings(got)
+	want := []string{"/azure-pipelines.yml", "/pipelines/deploy \"app\".yml", "/pipelines/new.yaml", "/templates/ä.yml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getLocalChangedYamlFiles() = %v, want %v", got, want)
+	}
+}
+
+func TestResolveLocalCompareRef(t *testing.T) {
+	repoRoot := newTestRepo(t)
+	runGit(t, repoRoot, "update-ref", "refs/remotes/origin/release", "HEAD")
+
+	tests := []struct {
+		branch  string
+		want    string
+		wantErr bool
+	}{
+		{branch: "main", want: "main"},
+		{branch: "refs/heads/main", want: "main"},
+		{branch: "release", want: "origin/release"},
+		{branch: "refs/heads/release", want: "origin/release"},
+		{branch: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveLocalCompareRef(repoRoot, tt.branch)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolveLocalCompareRef(%q) error = %v, wantErr %v", tt.branch, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveLocalCompareRef(%q) = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+}
+
+func TestLocalRepoPath(t *testing.T) {
+	repoRoot := t.TempDir()
+
+	tests := []struct {
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{file: filepath.Join(repoRoot, "azure-pipelines.yml"), want: "/azure-pipelines.yml"},
+		{file: filepath.Join(repoRoot, "pipelines", "ci.yml"), want: "/pipelines/ci.yml"},
+		{file: filepath.Join(repoRoot, "pipelines", "..", "ci.yml"), want: "/ci.yml"},
+		{file: filepath.Join(repoRoot, "..", "outside.yml"), wantErr: true},
+		{file: filepath.Dir(repoRoot), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := localRepoPath(repoRoot, tt.file)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("localRepoPath(%q) error = %v, wantErr %v", tt.file, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("localRepoPath(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
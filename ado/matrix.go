@@ -0,0 +1,329 @@
+package ado
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// MatrixStrategy selects the combinations of runtime parameter values validated when there are more than the maximum
+type MatrixStrategy string
+
+const (
+	// MatrixPairwise covers every pair of values of any two parameters with as few combinations as it can
+	MatrixPairwise MatrixStrategy = "pairwise"
+	// MatrixRandom picks random combinations, seeded by the pipeline ID so runs are reproducible
+	MatrixRandom MatrixStrategy = "random"
+)
+
+var MatrixStrategies = []MatrixStrategy{MatrixPairwise, MatrixRandom}
+
+// DefaultMatrixMaxCombinations is the default maximum number of combinations validated per pipeline
+const DefaultMatrixMaxCombinations = 32
+
+// MatrixOptions configure validating every combination of the runtime parameter values of a pipeline
+type MatrixOptions struct {
+	// MaxCombinations caps the number of Preview calls per pipeline
+	MaxCombinations int
+	// Strategy selects the combinations when there are more than MaxCombinations
+	Strategy MatrixStrategy
+}
+
+// ParseMatrixStrategy parses a matrix strategy name, case-insensitively
+func ParseMatrixStrategy(strategy string) (MatrixStrategy, error) {
+	for _, s := range MatrixStrategies {
+		if strings.EqualFold(strategy, string(s)) {
+			return s, nil
+		}
+	}
+
+	return "", fmt.Errorf("ParseMatrixStrategy: unknown matrix strategy %q", strategy)
+}
+
+// WithParameterMatrix validates each pipeline once per combination of its boolean parameters and parameters with a
+// list of allowed values, instead of once with the default values
+func WithParameterMatrix(options MatrixOptions) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		if options.MaxCombinations <= 0 {
+			return fmt.Errorf("WithParameterMatrix: maximum number of combinations must be positive, got %d", options.MaxCombinations)
+		}
+		if _, err := ParseMatrixStrategy(string(options.Strategy)); err != nil {
+			return fmt.Errorf("WithParameterMatrix: %w", err)
+		}
+		c.matrix = &options
+		return nil
+	}
+}
+
+// matrixParameter is a runtime parameter with a finite set of values
+type matrixParameter struct {
+	name   string
+	values []string
+}
+
+// expandPipelineParameters records the runtime parameters each pipeline declares, so parameter values given for all
+// pipelines are only sent to the pipelines that declare them. When validating a parameter matrix, it returns one
+// pipeline per parameter combination for every pipeline whose root file declares parameters with a finite set of
+// values. Parameters given a value by configuration or flags are not varied. Template host pipelines and pipelines
+// whose root file can't be read are returned as is.
+func (c ValidationClient) expandPipelineParameters(pipes []Pipeline, sources fs.FS) []Pipeline {
+	if sources == nil {
+		if c.matrix != nil {
+			log.Printf("expandPipelineParameters: no sources directory available, validating default parameter values only")
+		}
+		return pipes
+	}
+
+	result := make([]Pipeline, 0, len(pipes))
+	for _, pipeline := range pipes {
+		if pipeline.host != nil {
+			// Generated wrappers declare no parameters, while configured ones are part of the configuration
+			pipeline.declaredParameters, _ = parseDeclaredParameters([]byte(pipeline.host.Wrapper))
+			result = append(result, pipeline)
+			continue
+		}
+
+		content, err := fs.ReadFile(sources, strings.TrimPrefix(normalizePipelinePath(pipeline.FilePath), "/"))
+		if err != nil {
+			result = append(result, pipeline)
+			continue
+		}
+		if declared, err := parseDeclaredParameters(content); err == nil {
+			pipeline.declaredParameters = declared
+		}
+		if c.matrix == nil {
+			result = append(result, pipeline)
+			continue
+		}
+
+		parameters, err := parseMatrixParameters(content, c.previewValuesFor(pipeline).parameters)
+		if err != nil || len(parameters) == 0 {
+			result = append(result, pipeline)
+			continue
+		}
+
+		combinations := c.matrixCombinations(parameters, int64(pipeline.Id))
+		for _, combination := range combinations {
+			variant := pipeline
+			variant.parameters = combination
+			result = append(result, variant)
+		}
+	}
+
+	return result
+}
+
+// parseMatrixParameters returns the runtime parameters of a pipeline that have a finite set of values: booleans and
+// parameters with a values list. Parameters in fixed are left out.
+func parseMatrixParameters(content []byte, fixed map[string]string) ([]matrixParameter, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("parseMatrixParameters: failed to parse YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	declarations := mappingValue(doc.Content[0], "parameters")
+	if declarations == nil || declarations.Kind != yaml.SequenceNode {
+		return nil, nil
+	}
+
+	parameters := make([]matrixParameter, 0)
+	for _, declaration := range declarations.Content {
+		name := mappingValue(declaration, "name")
+		if name == nil || name.Kind != yaml.ScalarNode {
+			continue
+		}
+		if _, ok := fixed[name.Value]; ok {
+			continue
+		}
+
+		parameter := matrixParameter{name: name.Value}
+		if values := mappingValue(declaration, "values"); values != nil && values.Kind == yaml.SequenceNode {
+			for _, value := range values.Content {
+				if value.Kind == yaml.ScalarNode {
+					parameter.values = append(parameter.values, value.Value)
+				}
+			}
+		} else if parameterType := mappingValue(declaration, "type"); parameterType != nil && strings.EqualFold(parameterType.Value, "boolean") {
+			parameter.values = []string{"true", "false"}
+		}
+
+		// A single value does not add combinations
+		if len(parameter.values) > 1 {
+			parameters = append(parameters, parameter)
+		}
+	}
+
+	return parameters, nil
+}
+
+// matrixCombinations returns the combinations of parameter values to validate, all of them if there are at most the
+// maximum, otherwise the ones the strategy selects
+func (c ValidationClient) matrixCombinations(parameters []matrixParameter, seed int64) []map[string]string {
+	total := 1
+	for _, parameter := range parameters {
+		total *= len(parameter.values)
+		if total > c.matrix.MaxCombinations {
+			break
+		}
+	}
+
+	var indices [][]int
+	switch {
+	case total <= c.matrix.MaxCombinations:
+		indices = allCombinations(parameters)
+	case c.matrix.Strategy == MatrixRandom:
+		indices = randomCombinations(parameters, c.matrix.MaxCombinations, seed)
+	case len(parameters) == 1:
+		// Pairs need two parameters, a single one is validated with as many of its values as allowed
+		indices = allCombinations(parameters)[:c.matrix.MaxCombinations]
+	default:
+		indices = pairwiseCombinations(parameters)
+		if len(indices) > c.matrix.MaxCombinations {
+			log.Printf("matrixCombinations: %d combinations are needed to cover all pairs, validating the first %d", len(indices), c.matrix.MaxCombinations)
+			indices = indices[:c.matrix.MaxCombinations]
+		}
+	}
+
+	combinations := make([]map[string]string, 0, len(indices))
+	for _, index := range indices {
+		combination := make(map[string]string, len(parameters))
+		for i, parameter := range parameters {
+			combination[parameter.name] = parameter.values[index[i]]
+		}
+		combinations = append(combinations, combination)
+	}
+
+	return combinations
+}
+
+// allCombinations returns the value indices of every combination, varying the last parameter fastest
+func allCombinations(parameters []matrixParameter) [][]int {
+	result := [][]int{{}}
+	for _, parameter := range parameters {
+		next := make([][]int, 0, len(result)*len(parameter.values))
+		for _, prefix := range result {
+			for v := range parameter.values {
+				combination := append(append(make([]int, 0, len(prefix)+1), prefix...), v)
+				next = append(next, combination)
+			}
+		}
+		result = next
+	}
+
+	return result
+}
+
+// randomCombinations returns up to count distinct random combinations
+func randomCombinations(parameters []matrixParameter, count int, seed int64) [][]int {
+	random := rand.New(rand.NewSource(seed))
+	seen := make(map[string]bool)
+	result := make([][]int, 0, count)
+
+	// The combination space is larger than count, but duplicates can still be drawn, so attempts are bounded
+	for attempts := 0; len(result) < count && attempts < count*10; attempts++ {
+		combination := make([]int, len(parameters))
+		for i, parameter := range parameters {
+			combination[i] = random.Intn(len(parameter.values))
+		}
+
+		key := fmt.Sprint(combination)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, combination)
+		}
+	}
+
+	return result
+}
+
+// pairwiseCombinations greedily builds combinations until every pair of values of any two parameters is covered by at
+// least one of them
+func pairwiseCombinations(parameters []matrixParameter) [][]int {
+	type pair struct{ p1, v1, p2, v2 int }
+
+	uncovered := make(map[pair]bool)
+	for p1 := range parameters {
+		for p2 := p1 + 1; p2 < len(parameters); p2++ {
+			for v1 := range parameters[p1].values {
+				for v2 := range parameters[p2].values {
+					uncovered[pair{p1, v1, p2, v2}] = true
+				}
+			}
+		}
+	}
+
+	// Pairs are picked in a fixed order so the result is the same on every run
+	ordered := make([]pair, 0, len(uncovered))
+	for p := range uncovered {
+		ordered = append(ordered, p)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if a.p1 != b.p1 {
+			return a.p1 < b.p1
+		}
+		if a.p2 != b.p2 {
+			return a.p2 < b.p2
+		}
+		if a.v1 != b.v1 {
+			return a.v1 < b.v1
+		}
+		return a.v2 < b.v2
+	})
+
+	result := make([][]int, 0)
+	for _, start := range ordered {
+		if !uncovered[start] {
+			continue
+		}
+
+		combination := make([]int, len(parameters))
+		assigned := make([]bool, len(parameters))
+		combination[start.p1], combination[start.p2] = start.v1, start.v2
+		assigned[start.p1], assigned[start.p2] = true, true
+
+		// Every other parameter gets the value that covers the most pairs with the values assigned so far
+		for p := range parameters {
+			if assigned[p] {
+				continue
+			}
+			best, bestCovered := 0, -1
+			for v := range parameters[p].values {
+				covered := 0
+				for q := range parameters {
+					if !assigned[q] {
+						continue
+					}
+					key := pair{q, combination[q], p, v}
+					if p < q {
+						key = pair{p, v, q, combination[q]}
+					}
+					if uncovered[key] {
+						covered++
+					}
+				}
+				if covered > bestCovered {
+					best, bestCovered = v, covered
+				}
+			}
+			combination[p] = best
+			assigned[p] = true
+		}
+
+		for p1 := range parameters {
+			for p2 := p1 + 1; p2 < len(parameters); p2++ {
+				delete(uncovered, pair{p1, combination[p1], p2, combination[p2]})
+			}
+		}
+		result = append(result, combination)
+	}
+
+	return result
+}
@@ -0,0 +1,135 @@
+package ado
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testMatrixParameters returns parameters with the given numbers of values
+func testMatrixParameters(sizes ...int) []matrixParameter {
+	parameters := make([]matrixParameter, len(sizes))
+	for i, size := range sizes {
+		parameters[i].name = fmt.Sprintf("p%d", i)
+		for v := 0; v < size; v++ {
+			parameters[i].values = append(parameters[i].values, fmt.Sprintf("v%d", v))
+		}
+	}
+
+	return parameters
+}
+
+func TestPairwiseCombinations(t *testing.T) {
+	tests := []struct {
+		sizes []int
+		// max is the most combinations the pairs may take. The greedy search does not always find the fewest, which are
+		// at least the product of the two largest parameters, but stays far below all combinations.
+		max int
+	}{
+		{sizes: []int{2, 2}, max: 4},
+		{sizes: []int{2, 2, 2}, max: 6},
+		{sizes: []int{2, 2, 2, 2, 2, 2, 2, 2, 2, 2}, max: 16},
+		{sizes: []int{3, 3, 3, 3}, max: 15},
+		{sizes: []int{5, 2, 4, 3}, max: 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.sizes), func(t *testing.T) {
+			parameters := testMatrixParameters(tt.sizes...)
+			combinations := pairwiseCombinations(parameters)
+
+			if len(combinations) > tt.max {
+				t.Errorf("got %d combinations, want at most %d", len(combinations), tt.max)
+			}
+			if again := pairwiseCombinations(parameters); !reflect.DeepEqual(again, combinations) {
+				t.Errorf("got %v on the second run, want the same combinations as %v", again, combinations)
+			}
+
+			for p1 := range parameters {
+				for p2 := p1 + 1; p2 < len(parameters); p2++ {
+					for v1 := range parameters[p1].values {
+						for v2 := range parameters[p2].values {
+							if !coversPair(combinations, p1, v1, p2, v2) {
+								t.Errorf("no combination has %s=%s and %s=%s", parameters[p1].name, parameters[p1].values[v1], parameters[p2].name, parameters[p2].values[v2])
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func coversPair(combinations [][]int, p1 int, v1 int, p2 int, v2 int) bool {
+	for _, combination := range combinations {
+		if combination[p1] == v1 && combination[p2] == v2 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestMatrixCombinations(t *testing.T) {
+	tests := []struct {
+		name     string
+		sizes    []int
+		options  MatrixOptions
+		wantSize int
+	}{
+		{
+			name:     "all combinations within the maximum",
+			sizes:    []int{2, 3},
+			options:  MatrixOptions{MaxCombinations: 6, Strategy: MatrixPairwise},
+			wantSize: 6,
+		},
+		{
+			name:     "pairwise",
+			sizes:    []int{2, 2, 2, 2},
+			options:  MatrixOptions{MaxCombinations: 8, Strategy: MatrixPairwise},
+			wantSize: 6,
+		},
+		{
+			name:     "pairwise capped at the maximum",
+			sizes:    []int{4, 4, 4},
+			options:  MatrixOptions{MaxCombinations: 5, Strategy: MatrixPairwise},
+			wantSize: 5,
+		},
+		{
+			name:     "single parameter capped at the maximum",
+			sizes:    []int{10},
+			options:  MatrixOptions{MaxCombinations: 3, Strategy: MatrixPairwise},
+			wantSize: 3,
+		},
+		{
+			name:     "random",
+			sizes:    []int{3, 3, 3},
+			options:  MatrixOptions{MaxCombinations: 7, Strategy: MatrixRandom},
+			wantSize: 7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ValidationClient{matrix: &tt.options}
+			parameters := testMatrixParameters(tt.sizes...)
+			combinations := c.matrixCombinations(parameters, 42)
+
+			if len(combinations) != tt.wantSize {
+				t.Errorf("got %d combinations, want %d", len(combinations), tt.wantSize)
+			}
+
+			seen := make(map[string]bool)
+			for _, combination := range combinations {
+				if len(combination) != len(parameters) {
+					t.Errorf("combination %v does not set every parameter", combination)
+				}
+				key := fmt.Sprint(combination)
+				if seen[key] {
+					t.Errorf("combination %v is validated twice", combination)
+				}
+				seen[key] = true
+			}
+		})
+	}
+}
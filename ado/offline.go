@@ -0,0 +1,98 @@
+package ado
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// validateFileOffline validates a single file against the schema. displayPath is used in the report and diagnostics.
+func validateFileOffline(schema *Schema, displayPath string, osPath string) ValidationResult {
+	result := ValidationResult{
+		pipelinePath: displayPath,
+	}
+
+	start := time.Now()
+	content, err := os.ReadFile(osPath)
+	if err != nil {
+		result.err = fmt.Errorf("validateFileOffline: failed to read %s: %w", displayPath, err)
+		return result
+	}
+
+	result.diagnostics = schema.ValidateFile(displayPath, content)
+	result.duration = time.Since(start)
+
+	return result
+}
+
+// ValidateFilesOffline validates the given pipeline and template files against the schema without calling Azure DevOps.
+// The files are reported by their path in the git repository of the working directory, or relative to the working
+// directory outside of a repository.
+func ValidateFilesOffline(schema *Schema, files []string) (*Report, error) {
+	repoRoot, err := getLocalRepoRoot()
+	if err != nil {
+		if repoRoot, err = os.Getwd(); err != nil {
+			return nil, fmt.Errorf("ValidateFilesOffline: %w", err)
+		}
+	}
+
+	report, err := validateFilesOffline(schema, repoRoot, files)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateFilesOffline: %w", err)
+	}
+
+	return report, nil
+}
+
+// validateFilesOffline validates the given files against the schema. Ignore comments and fingerprints are looked up by
+// repository path, so they apply whether a file is given as a relative, ../ or absolute path.
+func validateFilesOffline(schema *Schema, repoRoot string, files []string) (*Report, error) {
+	results := make([]ValidationResult, 0, len(files))
+	for _, file := range files {
+		repoPath, err := localRepoPath(repoRoot, file)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, validateFileOffline(schema, repoPath, file))
+	}
+
+	report := newReport(results)
+	report.annotateDiagnostics(os.DirFS(repoRoot))
+	return report, nil
+}
+
+// ValidateLocalChangesOffline validates the pipeline and template files that differ from the given branch in the local
+// working tree and that the filter selects against the schema, without calling Azure DevOps. Changed YAML files that
+// are not pipeline files are skipped.
+func ValidateLocalChangesOffline(schema *Schema, compareBranch string, filter FileFilter) (*Report, error) {
+	repoRoot, err := getLocalRepoRoot()
+	if err != nil {
+		return nil, fmt.Errorf("ValidateLocalChangesOffline: %w", err)
+	}
+
+	changes, err := getLocalChangedYamlFiles(repoRoot, compareBranch)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateLocalChangesOffline: failed to get changed files: %w", err)
+	}
+
+	changes = filter.filter(changes)
+	results := make([]ValidationResult, 0, len(changes))
+	for _, file := range changes {
+		osPath := filepath.Join(repoRoot, filepath.FromSlash(strings.TrimPrefix(file, "/")))
+		content, err := os.ReadFile(osPath)
+		if err != nil {
+			return nil, fmt.Errorf("ValidateLocalChangesOffline: failed to read %s: %w", file, err)
+		}
+		if !looksLikePipeline(content) {
+			continue
+		}
+
+		results = append(results, validateFileOffline(schema, file, osPath))
+	}
+
+	report := newReport(results)
+	report.annotateDiagnostics(os.DirFS(repoRoot))
+	return report, nil
+}
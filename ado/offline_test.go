@@ -0,0 +1,72 @@
+package ado
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateFilesOfflineResolvesRepositoryPaths(t *testing.T) {
+	schema, err := DefaultSchema()
+	if err != nil {
+		t.Fatalf("failed to load the bundled schema: %v", err)
+	}
+
+	repoRoot := t.TempDir()
+	writeTestFile(t, repoRoot, "pipelines/ci.yml", `steps:
+- script: echo
+  reportedKeyword: true
+  ignoredKeyword: true # ado-yaml-validator: ignore schema-unknown-key
+`)
+	file := filepath.Join(repoRoot, "pipelines", "ci.yml")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relative, err := filepath.Rel(wd, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprints := make(map[string]bool)
+	for _, arg := range []string{file, relative} {
+		report, err := validateFilesOffline(schema, repoRoot, []string{arg})
+		if err != nil {
+			t.Fatalf("%s: validateFilesOffline() error = %v", arg, err)
+		}
+
+		if len(report.Results) != 1 {
+			t.Fatalf("%s: got %d results, want 1", arg, len(report.Results))
+		}
+		result := report.Results[0]
+		if result.Path != "/pipelines/ci.yml" {
+			t.Errorf("%s: got path %q, want the repository path", arg, result.Path)
+		}
+		if len(result.Suppressed) != 1 || result.Suppressed[0].Line != 4 {
+			t.Errorf("%s: got suppressed %+v, want the key with the ignore comment", arg, result.Suppressed)
+		}
+		if len(result.Diagnostics) != 1 || result.Diagnostics[0].Line != 3 || result.Diagnostics[0].File != "/pipelines/ci.yml" {
+			t.Fatalf("%s: got diagnostics %+v, want the key without an ignore comment", arg, result.Diagnostics)
+		}
+		fingerprints[result.Diagnostics[0].Fingerprint] = true
+	}
+
+	// The fingerprint includes the line content, which is only found with the repository path
+	want := newSourceLines(os.DirFS(repoRoot)).fingerprint(Diagnostic{File: "/pipelines/ci.yml", Line: 3, Message: "Unexpected value 'reportedKeyword'", Rule: RuleSchemaUnknownKey})
+	if len(fingerprints) != 1 || !fingerprints[want] {
+		t.Errorf("got fingerprints %v, want %s for all paths", fingerprints, want)
+	}
+}
+
+func TestValidateFilesOfflineOutsideRepository(t *testing.T) {
+	schema, err := DefaultSchema()
+	if err != nil {
+		t.Fatalf("failed to load the bundled schema: %v", err)
+	}
+
+	repoRoot := t.TempDir()
+	outside := filepath.Join(filepath.Dir(repoRoot), "outside.yml")
+	if _, err := validateFilesOffline(schema, repoRoot, []string{outside}); err == nil {
+		t.Errorf("got no error for %s outside of the repository %s", outside, repoRoot)
+	}
+}
@@ -0,0 +1,210 @@
+package ado
+
+import (
+	"fmt"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/pipelines"
+	"gopkg.in/yaml.v3"
+	"strings"
+)
+
+// PipelineConfig holds the runtime parameter and variable values sent with the Preview call of matching pipelines
+type PipelineConfig struct {
+	// Path is a glob pattern of the pipeline file paths the values apply to, for example /ci/*.yml
+	Path string `yaml:"path"`
+	// Id is the ID of the pipeline the values apply to
+	Id int `yaml:"id"`
+	// Parameters are runtime parameter values. Objects and lists are sent as YAML.
+	Parameters map[string]any `yaml:"parameters"`
+	// Variables are variable values. Only variables that are settable at queue time can be set.
+	Variables map[string]string `yaml:"variables"`
+	// StagesToSkip are the names of stages left out of the Preview, for example stages that only run on the main branch
+	StagesToSkip []string `yaml:"stagesToSkip"`
+}
+
+// matches checks whether the values apply to the given pipeline
+func (p PipelineConfig) matches(pipeline Pipeline) bool {
+	if p.Id != 0 && p.Id != pipeline.Id {
+		return false
+	}
+	if p.Path != "" && !matchPathGlob(p.Path, pipeline.FilePath) {
+		return false
+	}
+
+	return p.Id != 0 || p.Path != ""
+}
+
+// previewValues are the runtime parameter and variable values and the stages to skip sent with a Preview call
+type previewValues struct {
+	parameters   map[string]string
+	variables    map[string]string
+	stagesToSkip []string
+}
+
+// WithPipelineConfigs sets the parameter and variable values of pipelines. When several configurations match a
+// pipeline, later ones take precedence.
+func WithPipelineConfigs(configs []PipelineConfig) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		for i, config := range configs {
+			if config.Id == 0 && config.Path == "" {
+				return fmt.Errorf("WithPipelineConfigs: pipeline configuration %d has neither a path nor an ID", i)
+			}
+			for name, value := range config.Parameters {
+				if _, err := parameterString(value); err != nil {
+					return fmt.Errorf("WithPipelineConfigs: parameter %s of pipeline configuration %d: %w", name, i, err)
+				}
+			}
+		}
+		c.pipelineConfigs = configs
+		return nil
+	}
+}
+
+// WithPreviewValues sets parameter and variable values sent for all pipelines. They take precedence over the values of
+// pipeline configurations. Azure DevOps rejects values of parameters a pipeline does not declare, so parameter values
+// are only sent to the pipelines that declare them, when their root file can be read.
+func WithPreviewValues(parameters map[string]string, variables map[string]string) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		c.previewValues.parameters = parameters
+		c.previewValues.variables = variables
+		return nil
+	}
+}
+
+// WithStagesToSkip leaves the given stages out of the Preview of all pipelines, in addition to the stages to skip of
+// pipeline configurations
+func WithStagesToSkip(stages []string) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		for _, stage := range stages {
+			if strings.TrimSpace(stage) == "" {
+				return fmt.Errorf("WithStagesToSkip: stage name must not be empty")
+			}
+		}
+		c.previewValues.stagesToSkip = stages
+		return nil
+	}
+}
+
+// previewValuesFor returns the parameter and variable values and the stages to skip to send with the Preview call of a
+// pipeline
+func (c ValidationClient) previewValuesFor(pipeline Pipeline) previewValues {
+	values := previewValues{
+		parameters: make(map[string]string),
+		variables:  make(map[string]string),
+	}
+
+	for _, config := range c.pipelineConfigs {
+		if !config.matches(pipeline) {
+			continue
+		}
+		for name, value := range config.Parameters {
+			// Values were checked when the configurations were set
+			values.parameters[name], _ = parameterString(value)
+		}
+		for name, value := range config.Variables {
+			values.variables[name] = value
+		}
+		values.stagesToSkip = appendStages(values.stagesToSkip, config.StagesToSkip)
+	}
+
+	for name, value := range c.previewValues.parameters {
+		if pipeline.declaredParameters != nil && !pipeline.declaredParameters[name] {
+			continue
+		}
+		values.parameters[name] = value
+	}
+	for name, value := range c.previewValues.variables {
+		values.variables[name] = value
+	}
+	values.stagesToSkip = appendStages(values.stagesToSkip, c.previewValues.stagesToSkip)
+
+	return values
+}
+
+// appendStages appends the stages that are not in the list yet
+func appendStages(stages []string, more []string) []string {
+	for _, stage := range more {
+		found := false
+		for _, existing := range stages {
+			if existing == stage {
+				found = true
+				break
+			}
+		}
+		if !found {
+			stages = append(stages, stage)
+		}
+	}
+
+	return stages
+}
+
+// parseDeclaredParameters returns the names of the runtime parameters a pipeline file declares
+func parseDeclaredParameters(content []byte) (map[string]bool, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("parseDeclaredParameters: failed to parse YAML: %w", err)
+	}
+
+	declared := make(map[string]bool)
+	if len(doc.Content) == 0 {
+		return declared, nil
+	}
+
+	declarations := mappingValue(doc.Content[0], "parameters")
+	if declarations == nil {
+		return declared, nil
+	}
+	switch declarations.Kind {
+	case yaml.SequenceNode:
+		for _, declaration := range declarations.Content {
+			if name := mappingValue(declaration, "name"); name != nil && name.Kind == yaml.ScalarNode {
+				declared[name.Value] = true
+			}
+		}
+	case yaml.MappingNode:
+		// Old syntax, where parameters map names to default values
+		for i := 0; i+1 < len(declarations.Content); i += 2 {
+			declared[declarations.Content[i].Value] = true
+		}
+	}
+
+	return declared, nil
+}
+
+// parameterString converts a parameter value from the configuration to the string form the Preview API expects.
+// Objects and lists are sent as YAML, which Azure DevOps parses for object parameters.
+func parameterString(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case map[string]any, []any:
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// withPreviewValues sends the given parameter and variable values and the stages to skip with the Preview call
+func withPreviewValues(values previewValues) previewPipelineArgOpt {
+	return func(args *previewPipelineArgs) {
+		if len(values.parameters) > 0 {
+			args.PreviewParameters.TemplateParameters = &values.parameters
+		}
+		if len(values.variables) > 0 {
+			variables := make(map[string]pipelines.Variable, len(values.variables))
+			for name, value := range values.variables {
+				variables[name] = pipelines.Variable{Value: Pointer(value)}
+			}
+			args.PreviewParameters.Variables = &variables
+		}
+		if len(values.stagesToSkip) > 0 {
+			args.PreviewParameters.StagesToSkip = &values.stagesToSkip
+		}
+	}
+}
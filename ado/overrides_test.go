@@ -0,0 +1,123 @@
+package ado
+
+import (
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPreviewValuesOnlyForDeclaredParameters(t *testing.T) {
+	sources := fstest.MapFS{
+		"deploy.yml": {Data: []byte(`parameters:
+- name: environment
+  type: string
+  default: test
+steps:
+- script: echo ${{ parameters.environment }}
+`)},
+		"legacy.yml": {Data: []byte(`parameters:
+  environment: test
+steps:
+- script: echo
+`)},
+		"build.yml": {Data: []byte("steps:\n- script: echo\n")},
+	}
+	c := ValidationClient{
+		environment: &AzureDevOpsEnvironment{project: "project", runBranch: "main"},
+		previewValues: previewValues{
+			parameters: map[string]string{"environment": "dev"},
+			variables:  map[string]string{"verbose": "true"},
+		},
+		pipelineConfigs: []PipelineConfig{
+			{Path: "/build.yml", Parameters: map[string]any{"configuration": "release"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		pipeline Pipeline
+		want     map[string]string
+	}{
+		{
+			name:     "declared parameter",
+			pipeline: Pipeline{Id: 1, FilePath: "/deploy.yml"},
+			want:     map[string]string{"environment": "dev"},
+		},
+		{
+			name:     "declared with the old syntax",
+			pipeline: Pipeline{Id: 2, FilePath: "/legacy.yml"},
+			want:     map[string]string{"environment": "dev"},
+		},
+		{
+			name:     "undeclared parameter",
+			pipeline: Pipeline{Id: 3, FilePath: "/build.yml"},
+			// Values configured for a pipeline are sent as they are
+			want: map[string]string{"configuration": "release"},
+		},
+		{
+			name:     "generated template wrapper",
+			pipeline: Pipeline{Id: 4, FilePath: "/templates/steps.yml", host: &TemplateHost{PipelineId: 4}},
+			want:     nil,
+		},
+		{
+			name:     "unreadable root file",
+			pipeline: Pipeline{Id: 5, FilePath: "/missing.yml"},
+			want:     map[string]string{"environment": "dev"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipes := c.expandPipelineParameters([]Pipeline{tt.pipeline}, sources)
+			if len(pipes) != 1 {
+				t.Fatalf("got %d pipelines, want 1", len(pipes))
+			}
+
+			args := c.newPreviewPipelineArgs(pipes[0])
+			var got map[string]string
+			if args.PreviewParameters.TemplateParameters != nil {
+				got = *args.PreviewParameters.TemplateParameters
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got parameters %v, want %v", got, tt.want)
+			}
+			if variables := args.PreviewParameters.Variables; variables == nil || len(*variables) != 1 {
+				t.Errorf("got variables %v, want the variable sent to every pipeline", variables)
+			}
+		})
+	}
+}
+
+func TestStagesToSkip(t *testing.T) {
+	c := ValidationClient{environment: &AzureDevOpsEnvironment{project: "project", runBranch: "main"}}
+	opts := []ValidationClientOpt{
+		WithPipelineConfigs([]PipelineConfig{{Path: "/deploy.yml", StagesToSkip: []string{"production", "smoke"}}}),
+		WithStagesToSkip([]string{"smoke", "release"}),
+		// Setting the other values keeps the stages
+		WithPreviewValues(map[string]string{"environment": "dev"}, nil),
+	}
+	for _, opt := range opts {
+		if err := opt(&c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file string
+		want []string
+	}{
+		{file: "/deploy.yml", want: []string{"production", "smoke", "release"}},
+		{file: "/build.yml", want: []string{"smoke", "release"}},
+	}
+
+	for _, tt := range tests {
+		args := c.newPreviewPipelineArgs(Pipeline{Id: 1, FilePath: tt.file})
+		if args.PreviewParameters.StagesToSkip == nil || !reflect.DeepEqual(*args.PreviewParameters.StagesToSkip, tt.want) {
+			t.Errorf("%s: got stages to skip %v, want %v", tt.file, args.PreviewParameters.StagesToSkip, tt.want)
+		}
+	}
+
+	if err := WithStagesToSkip([]string{" "})(&c); err == nil {
+		t.Errorf("got no error for an empty stage name")
+	}
+}
@@ -0,0 +1,59 @@
+package ado
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultConcurrency is the default number of concurrent requests to Azure DevOps
+const DefaultConcurrency = 8
+
+// poolResult is the outcome of processing a single item in a worker pool
+type poolResult[T any, R any] struct {
+	item  T
+	value R
+	err   error
+}
+
+// runPool processes the items with at most concurrency workers and sends exactly one result per item on the returned
+// channel, which is closed after the last result. Once ctx is cancelled, the remaining items are not processed and
+// their results carry the context error instead. The caller must drain the channel.
+func runPool[T any, R any](ctx context.Context, concurrency int, items []T, fn func(context.Context, T) (R, error)) <-chan poolResult[T, R] {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > len(items) {
+		concurrency = len(items)
+	}
+
+	jobs := make(chan T)
+	results := make(chan poolResult[T, R])
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				result := poolResult[T, R]{item: item}
+				if err := ctx.Err(); err != nil {
+					result.err = err
+				} else {
+					result.value, result.err = fn(ctx, item)
+				}
+				results <- result
+			}
+		}()
+	}
+
+	go func() {
+		for _, item := range items {
+			jobs <- item
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
@@ -0,0 +1,144 @@
+package ado
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunPool(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	square := func(ctx context.Context, item int) (int, error) {
+		return item * item, nil
+	}
+
+	seen := make(map[int]bool)
+	for result := range runPool(context.Background(), 3, items, square) {
+		if seen[result.item] {
+			t.Errorf("got item %d twice", result.item)
+		}
+		seen[result.item] = true
+		if result.err != nil || result.value != result.item*result.item {
+			t.Errorf("item %d: got %d, %v", result.item, result.value, result.err)
+		}
+	}
+	if len(seen) != len(items) {
+		t.Errorf("got %d results, want %d", len(seen), len(items))
+	}
+}
+
+func TestRunPoolEmpty(t *testing.T) {
+	fn := func(ctx context.Context, item string) (string, error) {
+		t.Errorf("called for %q without items", item)
+		return item, nil
+	}
+
+	done := make(chan int)
+	go func() {
+		count := 0
+		for range runPool(context.Background(), 4, nil, fn) {
+			count++
+		}
+		done <- count
+	}()
+
+	select {
+	case count := <-done:
+		if count != 0 {
+			t.Errorf("got %d results, want none", count)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("results were not closed")
+	}
+}
+
+func TestRunPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	items := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	fn := func(ctx context.Context, item int) (int, error) {
+		if item == 2 {
+			cancel()
+		}
+		return item, nil
+	}
+
+	// A single worker processes the items in order, so exactly the items after the cancelling one are skipped
+	results := make(map[int]error)
+	for result := range runPool(ctx, 1, items, fn) {
+		if _, ok := results[result.item]; ok {
+			t.Errorf("got item %d twice", result.item)
+		}
+		results[result.item] = result.err
+	}
+
+	if len(results) != len(items) {
+		t.Fatalf("got %d results, want one per item", len(results))
+	}
+	for _, item := range items {
+		err := results[item]
+		if item <= 2 && err != nil {
+			t.Errorf("item %d: got %v, want it processed", item, err)
+		}
+		if item > 2 && !errors.Is(err, context.Canceled) {
+			t.Errorf("item %d: got %v, want context.Canceled", item, err)
+		}
+	}
+}
+
+func TestRunPoolConcurrency(t *testing.T) {
+	const concurrency = 3
+
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	entered := make(chan struct{}, 20)
+	release := make(chan struct{})
+	fn := func(ctx context.Context, item int) (int, error) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+
+		entered <- struct{}{}
+		<-release
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return item, nil
+	}
+
+	items := make([]int, 20)
+	results := runPool(context.Background(), concurrency, items, fn)
+
+	for i := 0; i < concurrency; i++ {
+		select {
+		case <-entered:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d workers started, want %d", i, concurrency)
+		}
+	}
+	select {
+	case <-entered:
+		t.Errorf("more than %d items are processed at the same time", concurrency)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	count := 0
+	for range results {
+		count++
+	}
+
+	if count != len(items) {
+		t.Errorf("got %d results, want %d", count, len(items))
+	}
+	if maxActive != concurrency {
+		t.Errorf("got at most %d items processed at the same time, want %d", maxActive, concurrency)
+	}
+}
@@ -0,0 +1,168 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"github.com/microsoft/azure-devops-go-api/azuredevops/git"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// summaryThreadMarker identifies the summary comment thread created by this tool. It is an HTML comment, so it is not
+// rendered in the pull request.
+const summaryThreadMarker = "<!-- ado-yaml-validator:summary -->"
+
+// PublishPullRequestComment creates a summary comment thread with the validation results on the pull request, or
+// updates the existing one from a previous run. The thread is resolved when all pipelines pass and reactivated when
+// any pipeline fails.
+func (c ValidationClient) PublishPullRequestComment(ctx context.Context, report *Report) error {
+	if c.environment.pullRequestId == 0 {
+		return fmt.Errorf("PublishPullRequestComment: pull request ID is not set")
+	}
+
+	content := renderPullRequestSummary(report)
+	status := git.CommentThreadStatusValues.Fixed
+	if report.Failed() > 0 {
+		status = git.CommentThreadStatusValues.Active
+	}
+
+	client := c.environment.sdkClient()
+	threadsUrl := c.pullRequestApiUrl("threads")
+
+	var threads struct {
+		Value []git.GitPullRequestCommentThread `json:"value"`
+	}
+	if err := c.environment.sendRequest(ctx, client, http.MethodGet, threadsUrl, nil, &threads); err != nil {
+		return fmt.Errorf("PublishPullRequestComment: failed to get comment threads: %w", err)
+	}
+
+	if thread, comment := findSummaryThread(&threads.Value); thread != nil && thread.Id != nil && comment.Id != nil {
+		// Updates set the content and status, so repeating them is safe
+		commentUrl := c.pullRequestApiUrl(fmt.Sprintf("threads/%d/comments/%d", *thread.Id, *comment.Id))
+		err := c.environment.sendRequest(withRetryable(ctx), client, http.MethodPatch, commentUrl, git.Comment{Content: Pointer(content)}, nil)
+		if err != nil {
+			return fmt.Errorf("PublishPullRequestComment: failed to update summary comment: %w", err)
+		}
+
+		threadUrl := c.pullRequestApiUrl(fmt.Sprintf("threads/%d", *thread.Id))
+		err = c.environment.sendRequest(withRetryable(ctx), client, http.MethodPatch, threadUrl, git.GitPullRequestCommentThread{Status: &status}, nil)
+		if err != nil {
+			return fmt.Errorf("PublishPullRequestComment: failed to update summary thread status: %w", err)
+		}
+
+		return nil
+	}
+
+	thread := git.GitPullRequestCommentThread{
+		Comments: &[]git.Comment{
+			{
+				Content:     Pointer(content),
+				CommentType: &git.CommentTypeValues.Text,
+			},
+		},
+		Status: &status,
+	}
+	// Creating the thread is not retried unless it was throttled, as a repeated request could create a second thread
+	if err := c.environment.sendRequest(ctx, client, http.MethodPost, threadsUrl, thread, nil); err != nil {
+		return fmt.Errorf("PublishPullRequestComment: failed to create summary thread: %w", err)
+	}
+
+	return nil
+}
+
+// pullRequestApiUrl returns the URL of a REST API of the environment's pull request. The path is relative to the pull
+// request, and its segments must be escaped.
+func (c ValidationClient) pullRequestApiUrl(path string) string {
+	return c.environment.projectApiUrl(fmt.Sprintf("git/repositories/%s/pullRequests/%d/%s", url.PathEscape(c.environment.repositoryId), c.environment.pullRequestId, path), nil)
+}
+
+// findSummaryThread returns the summary thread and its first comment, or nil if there is no summary thread yet
+func findSummaryThread(threads *[]git.GitPullRequestCommentThread) (*git.GitPullRequestCommentThread, *git.Comment) {
+	if threads == nil {
+		return nil, nil
+	}
+
+	for i := range *threads {
+		thread := &(*threads)[i]
+		if thread.Comments == nil || len(*thread.Comments) == 0 || (thread.IsDeleted != nil && *thread.IsDeleted) {
+			continue
+		}
+		comment := &(*thread.Comments)[0]
+		if comment.Content != nil && strings.HasPrefix(*comment.Content, summaryThreadMarker) {
+			return thread, comment
+		}
+	}
+
+	return nil, nil
+}
+
+// renderPullRequestSummary renders the report as a markdown summary for a pull request comment
+func renderPullRequestSummary(report *Report) string {
+	var sb strings.Builder
+	sb.WriteString(summaryThreadMarker + "\n")
+
+	if len(report.Results) == 0 {
+		sb.WriteString("### :white_check_mark: Pipeline validation\n\nNo pipelines are affected by this pull request.\n")
+		return sb.String()
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		sb.WriteString(fmt.Sprintf("### :x: Pipeline validation: %d of %d pipeline(s) failed\n\n", failed, len(report.Results)))
+	} else {
+		sb.WriteString(fmt.Sprintf("### :white_check_mark: Pipeline validation: all %d pipeline(s) passed\n\n", len(report.Results)))
+	}
+
+	sb.WriteString("| Pipeline | Path | Result |\n|---|---|---|\n")
+	for _, result := range report.Results {
+		outcome := ":white_check_mark: passed"
+		if result.Status == StatusFailed {
+			outcome = ":x: " + escapeMarkdownTableCell(strings.Join(result.Messages(), "\n"))
+		}
+		sb.WriteString(fmt.Sprintf("| %d | `%s` | %s |\n", result.PipelineId, result.Name(), outcome))
+	}
+
+	return sb.String()
+}
+
+// escapeMarkdownTableCell makes text safe to use inside a single markdown table cell
+func escapeMarkdownTableCell(text string) string {
+	text = strings.ReplaceAll(text, "|", "\\|")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(strings.TrimSpace(text), "\n", "<br>")
+}
+
+// PublishPullRequestStatus publishes the overall validation result as a pull request status with the given genre and
+// name, which branch policies can require.
+func (c ValidationClient) PublishPullRequestStatus(ctx context.Context, report *Report, genre string, name string) error {
+	if c.environment.pullRequestId == 0 {
+		return fmt.Errorf("PublishPullRequestStatus: pull request ID is not set")
+	}
+
+	state := git.GitStatusStateValues.Succeeded
+	description := fmt.Sprintf("All %d pipeline(s) passed validation", len(report.Results))
+	if failed := report.Failed(); failed > 0 {
+		state = git.GitStatusStateValues.Failed
+		description = fmt.Sprintf("%d of %d pipeline(s) failed validation", failed, len(report.Results))
+	}
+
+	status := git.GitPullRequestStatus{
+		Context: &git.GitStatusContext{
+			Genre: Pointer(genre),
+			Name:  Pointer(name),
+		},
+		State:       &state,
+		Description: Pointer(description),
+	}
+	if c.environment.buildId != 0 {
+		status.TargetUrl = Pointer(fmt.Sprintf("%s/%s/_build/results?buildId=%d", strings.TrimRight(c.environment.organizationUrl, "/"), url.PathEscape(c.environment.project), c.environment.buildId))
+	}
+
+	// Only the latest status of a genre and name counts, so a status posted twice is harmless
+	err := c.environment.sendRequest(withRetryable(ctx), c.environment.sdkClient(), http.MethodPost, c.pullRequestApiUrl("statuses"), status, nil)
+	if err != nil {
+		return fmt.Errorf("PublishPullRequestStatus: failed to create pull request status: %w", err)
+	}
+
+	return nil
+}
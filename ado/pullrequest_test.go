@@ -0,0 +1,240 @@
+package ado
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordedRequest is a request received by a pull request server
+type recordedRequest struct {
+	method string
+	path   string
+	body   map[string]any
+}
+
+// newPullRequestServer answers listing the comment threads of pull request 42 with the given threads and records all
+// requests
+func newPullRequestServer(t *testing.T, threads []map[string]any, requests *[]recordedRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := recordedRequest{method: r.Method, path: r.URL.Path}
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read request body: %v", err)
+		}
+		if len(content) > 0 {
+			if err := json.Unmarshal(content, &request.body); err != nil {
+				t.Errorf("failed to unmarshal request body %s: %v", content, err)
+			}
+		}
+		*requests = append(*requests, request)
+
+		if r.Method == http.MethodGet && r.URL.Path == "/project/_apis/git/repositories/repo-id/pullRequests/42/threads" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"count": len(threads), "value": threads})
+			return
+		}
+		_, _ = io.WriteString(w, "{}")
+	}))
+}
+
+// newPullRequestClient returns a client for pull request 42 of build 7 that sends its requests to the server
+func newPullRequestClient(server *httptest.Server) ValidationClient {
+	return ValidationClient{
+		environment: &AzureDevOpsEnvironment{
+			connection:      NewOauthConnection(server.URL, "token"),
+			organizationUrl: server.URL,
+			project:         "project",
+			repositoryId:    "repo-id",
+			pullRequestId:   42,
+			buildId:         7,
+			httpClient:      http.DefaultClient,
+		},
+	}
+}
+
+func TestPublishPullRequestCommentUpdatesSummaryThread(t *testing.T) {
+	threads := []map[string]any{
+		{"id": 1, "comments": []map[string]any{{"id": 1, "content": "Looks good to me"}}},
+		{"id": 2, "isDeleted": true, "comments": []map[string]any{{"id": 1, "content": summaryThreadMarker + "\nold"}}},
+		{"id": 3, "comments": []map[string]any{{"id": 5, "content": summaryThreadMarker + "\nprevious run"}, {"id": 6, "content": "reply"}}},
+	}
+
+	var requests []recordedRequest
+	server := newPullRequestServer(t, threads, &requests)
+	defer server.Close()
+
+	report := testReport()
+	if err := newPullRequestClient(server).PublishPullRequestComment(context.Background(), report); err != nil {
+		t.Fatalf("PublishPullRequestComment failed: %v", err)
+	}
+
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests %v, want listing the threads and updating the comment and the thread", len(requests), requests)
+	}
+	comment, thread := requests[1], requests[2]
+	if comment.method != http.MethodPatch || comment.path != "/project/_apis/git/repositories/repo-id/pullRequests/42/threads/3/comments/5" {
+		t.Errorf("got %s %s, want the summary comment to be updated", comment.method, comment.path)
+	}
+	if comment.body["content"] != renderPullRequestSummary(report) {
+		t.Errorf("got comment content %v, want the summary", comment.body["content"])
+	}
+	if thread.method != http.MethodPatch || thread.path != "/project/_apis/git/repositories/repo-id/pullRequests/42/threads/3" {
+		t.Errorf("got %s %s, want the summary thread to be updated", thread.method, thread.path)
+	}
+	if thread.body["status"] != "active" {
+		t.Errorf("got thread status %v, want active for failed pipelines", thread.body["status"])
+	}
+}
+
+func TestPublishPullRequestCommentCreatesSummaryThread(t *testing.T) {
+	threads := []map[string]any{
+		{"id": 1, "comments": []map[string]any{{"id": 1, "content": "Mentions " + summaryThreadMarker + " later on"}}},
+	}
+
+	var requests []recordedRequest
+	server := newPullRequestServer(t, threads, &requests)
+	defer server.Close()
+
+	report := &Report{Results: []PipelineReport{{PipelineId: 1, Path: "/azure-pipelines.yml", Status: StatusPassed}}}
+	if err := newPullRequestClient(server).PublishPullRequestComment(context.Background(), report); err != nil {
+		t.Fatalf("PublishPullRequestComment failed: %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests %v, want listing the threads and creating one", len(requests), requests)
+	}
+	created := requests[1]
+	if created.method != http.MethodPost || created.path != "/project/_apis/git/repositories/repo-id/pullRequests/42/threads" {
+		t.Errorf("got %s %s, want a new thread", created.method, created.path)
+	}
+	if created.body["status"] != "fixed" {
+		t.Errorf("got thread status %v, want fixed when all pipelines pass", created.body["status"])
+	}
+	comments, _ := created.body["comments"].([]any)
+	if len(comments) != 1 {
+		t.Fatalf("got comments %v, want the summary comment", created.body["comments"])
+	}
+	comment, _ := comments[0].(map[string]any)
+	if comment["content"] != renderPullRequestSummary(report) || comment["commentType"] != "text" {
+		t.Errorf("got comment %v, want the summary as a text comment", comment)
+	}
+}
+
+func TestRenderPullRequestSummary(t *testing.T) {
+	tests := []struct {
+		name   string
+		report *Report
+		want   string
+	}{
+		{
+			name:   "no pipelines",
+			report: &Report{},
+			want: summaryThreadMarker + `
+### :white_check_mark: Pipeline validation
+
+No pipelines are affected by this pull request.
+`,
+		},
+		{
+			name: "passed",
+			report: &Report{Results: []PipelineReport{
+				{PipelineId: 1, Path: "/azure-pipelines.yml", Status: StatusPassed},
+			}},
+			want: summaryThreadMarker + `
+### :white_check_mark: Pipeline validation: all 1 pipeline(s) passed
+
+| Pipeline | Path | Result |
+|---|---|---|
+| 1 | ` + "`/azure-pipelines.yml`" + ` | :white_check_mark: passed |
+`,
+		},
+		{
+			name: "failed",
+			report: &Report{Results: []PipelineReport{
+				{PipelineId: 1, Path: "/azure-pipelines.yml", Status: StatusPassed},
+				{
+					PipelineId: 2,
+					Path:       "/pipelines/build.yml",
+					Parameters: map[string]string{"environment": "dev"},
+					Status:     StatusFailed,
+					Error:      "a | b\r\nc",
+					Diagnostics: []Diagnostic{
+						{File: "/templates/steps.yml", Line: 3, Column: 5, Message: "Unexpected value 'scripts'", Severity: SeverityError},
+						{File: "/templates/old.yml", Message: "File not found", Severity: SeverityError, Baseline: BaselineExisting},
+					},
+				},
+			}},
+			want: summaryThreadMarker + `
+### :x: Pipeline validation: 1 of 2 pipeline(s) failed
+
+| Pipeline | Path | Result |
+|---|---|---|
+| 1 | ` + "`/azure-pipelines.yml`" + ` | :white_check_mark: passed |
+| 2 | ` + "`/pipelines/build.yml (environment=dev)`" + ` | :x: a \| b<br>c<br>/templates/steps.yml (Line: 3, Col: 5): Unexpected value 'scripts'<br>/templates/old.yml: File not found (pre-existing) |
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := renderPullRequestSummary(tt.report); got != tt.want {
+			t.Errorf("%s: got summary\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPublishPullRequestStatus(t *testing.T) {
+	tests := []struct {
+		name            string
+		report          *Report
+		wantState       string
+		wantDescription string
+	}{
+		{
+			name:            "passed",
+			report:          &Report{Results: []PipelineReport{{PipelineId: 1, Path: "/azure-pipelines.yml", Status: StatusPassed}}},
+			wantState:       "succeeded",
+			wantDescription: "All 1 pipeline(s) passed validation",
+		},
+		{
+			name:            "failed",
+			report:          testReport(),
+			wantState:       "failed",
+			wantDescription: "3 of 4 pipeline(s) failed validation",
+		},
+	}
+
+	for _, tt := range tests {
+		var requests []recordedRequest
+		server := newPullRequestServer(t, nil, &requests)
+
+		err := newPullRequestClient(server).PublishPullRequestStatus(context.Background(), tt.report, "validation", "pipelines")
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: PublishPullRequestStatus failed: %v", tt.name, err)
+		}
+
+		if len(requests) != 1 {
+			t.Fatalf("%s: got %d requests %v, want a single status", tt.name, len(requests), requests)
+		}
+		request := requests[0]
+		if request.method != http.MethodPost || request.path != "/project/_apis/git/repositories/repo-id/pullRequests/42/statuses" {
+			t.Errorf("%s: got %s %s, want a new pull request status", tt.name, request.method, request.path)
+		}
+		statusContext, _ := request.body["context"].(map[string]any)
+		if statusContext["genre"] != "validation" || statusContext["name"] != "pipelines" {
+			t.Errorf("%s: got context %v, want genre validation and name pipelines", tt.name, request.body["context"])
+		}
+		if request.body["state"] != tt.wantState {
+			t.Errorf("%s: got state %v, want %s", tt.name, request.body["state"], tt.wantState)
+		}
+		if request.body["description"] != tt.wantDescription {
+			t.Errorf("%s: got description %v, want %q", tt.name, request.body["description"], tt.wantDescription)
+		}
+		if want := server.URL + "/project/_build/results?buildId=7"; request.body["targetUrl"] != want {
+			t.Errorf("%s: got target URL %v, want %s", tt.name, request.body["targetUrl"], want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tokenRefreshMargin is how long before it expires a token is replaced, so requests started shortly before expiry
+// do not fail
+const tokenRefreshMargin = 5 * time.Minute
+
+// refreshingToken caches the token of a provider and gets a new one when it is about to expire or Azure DevOps
+// rejects it
+type refreshingToken struct {
+	provider TokenProvider
+
+	mu            sync.Mutex
+	token         Token
+	authorization string
+}
+
+// authorizationHeader returns the Authorization header value for the token
+func authorizationHeader(token Token) string {
+	if token.Pat {
+		return azuredevops.CreateBasicAuthHeaderValue("", token.Value)
+	}
+
+	return "Bearer " + token.Value
+}
+
+// current returns the Authorization header value of the cached token, getting a new token first if there is none or
+// it is about to expire
+func (t *refreshingToken) current(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.authorization != "" && (t.token.ExpiresOn.IsZero() || time.Until(t.token.ExpiresOn) > tokenRefreshMargin) {
+		return t.authorization, nil
+	}
+
+	return t.refreshLocked(ctx)
+}
+
+// refresh gets a new token after the one with the given Authorization header value was rejected. If another request
+// has refreshed the token in the meantime, the newer token is returned without asking the provider again.
+func (t *refreshingToken) refresh(ctx context.Context, rejected string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.authorization != rejected {
+		return t.authorization, nil
+	}
+
+	return t.refreshLocked(ctx)
+}
+
+func (t *refreshingToken) refreshLocked(ctx context.Context) (string, error) {
+	token, err := t.provider.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh token from %s: %w", t.provider.Name(), err)
+	}
+
+	t.token = token
+	t.authorization = authorizationHeader(token)
+
+	return t.authorization, nil
+}
+
+// AuthTransport sends requests with the current token of a provider. Tokens are replaced before they expire and
+// requests rejected with 401 Unauthorized are retried once with a new token.
+//
+// The Azure DevOps SDK copies the Authorization header of a connection into every client it creates, so the header
+// can only be replaced on the way out. Requests created by SDK clients are sent through the environment's HTTP client
+// as well, see sendRequest, so they get new tokens too. Only the connection keeps the token it was created with.
+type AuthTransport struct {
+	base  http.RoundTripper
+	token *refreshingToken
+}
+
+// NewAuthTransport wraps a transport so requests are authenticated with tokens from the provider. The first token is
+// requested right away, so missing credentials are reported before any request is sent.
+func NewAuthTransport(ctx context.Context, base http.RoundTripper, provider TokenProvider) (*AuthTransport, error) {
+	token := &refreshingToken{provider: provider}
+	if _, err := token.current(ctx); err != nil {
+		return nil, fmt.Errorf("NewAuthTransport: %w", err)
+	}
+
+	return &AuthTransport{
+		base:  base,
+		token: token,
+	}, nil
+}
+
+// Connection creates a connection to the organization authenticated with the current token, for the SDK clients
+func (t *AuthTransport) Connection(organizationUrl string) *azuredevops.Connection {
+	t.token.mu.Lock()
+	defer t.token.mu.Unlock()
+
+	return &azuredevops.Connection{
+		AuthorizationString:     t.token.authorization,
+		BaseUrl:                 normalizeUrl(organizationUrl),
+		SuppressFedAuthRedirect: true,
+	}
+}
+
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authorization, err := t.token.current(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(withAuthorization(req, authorization, req.Body))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	fresh, err := t.token.refresh(req.Context(), authorization)
+	if err != nil {
+		log.Printf("AuthTransport: %v", err)
+		return resp, nil
+	}
+	if fresh == authorization {
+		// The provider returned the rejected token again, so there is no point in repeating the request
+		return resp, nil
+	}
+
+	// Bodies can only be read once, so the request can only be repeated if a fresh copy is available. Later requests
+	// use the new token either way.
+	body := req.Body
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		if body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	return t.base.RoundTrip(withAuthorization(req, fresh, body))
+}
+
+// withAuthorization copies the request with another Authorization header and body, as transports must not modify
+// requests
+func withAuthorization(req *http.Request, authorization string, body io.ReadCloser) *http.Request {
+	clone := req.Clone(req.Context())
+	clone.Header.Set("Authorization", authorization)
+	clone.Body = body
+
+	return clone
+}
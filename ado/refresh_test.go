@@ -0,0 +1,130 @@
+package ado
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countingTokenProvider returns a new bearer token every time it is asked
+type countingTokenProvider struct {
+	prefix string
+	calls  int
+}
+
+func (p *countingTokenProvider) Name() string {
+	return p.prefix
+}
+
+func (p *countingTokenProvider) Token(ctx context.Context) (Token, error) {
+	p.calls++
+	return Token{Value: fmt.Sprintf("%s-%d", p.prefix, p.calls)}, nil
+}
+
+func TestAuthTransportRefreshesRejectedToken(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer first-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	provider := &countingTokenProvider{prefix: "first"}
+	auth, err := NewAuthTransport(context.Background(), http.DefaultTransport, provider)
+	if err != nil {
+		t.Fatalf("NewAuthTransport failed: %v", err)
+	}
+	if got := auth.Connection(server.URL).AuthorizationString; got != "Bearer first-1" {
+		t.Errorf("connection is authorized with %q, want the first token", got)
+	}
+
+	client := &http.Client{Transport: auth}
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want the request repeated with a new token", resp.StatusCode)
+	}
+	if len(received) != 2 || received[0] != "Bearer first-1" || received[1] != "Bearer first-2" {
+		t.Errorf("got Authorization headers %q, want the first and then the refreshed token", received)
+	}
+}
+
+func TestAuthTransportKeepsTokensPerInstance(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Authorization"))
+	}))
+	defer server.Close()
+
+	first, err := NewAuthTransport(context.Background(), http.DefaultTransport, &countingTokenProvider{prefix: "first"})
+	if err != nil {
+		t.Fatalf("NewAuthTransport failed: %v", err)
+	}
+	second, err := NewAuthTransport(context.Background(), http.DefaultTransport, &countingTokenProvider{prefix: "second"})
+	if err != nil {
+		t.Fatalf("NewAuthTransport failed: %v", err)
+	}
+
+	for _, transport := range []http.RoundTripper{first, second} {
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		// Requests carry the header of whatever connection created them, the transport replaces it
+		req.Header.Set("Authorization", "Bearer stale")
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if len(received) != 2 || received[0] != "Bearer first-1" || received[1] != "Bearer second-1" {
+		t.Errorf("got Authorization headers %q, want the token of each transport", received)
+	}
+}
+
+func TestSdkRequestsRefreshRejectedToken(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer first-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"value":[{"id":"5f0e0b1e-3c4a-4d58-9a1e-2b6f7c8d9e0f","name":"Repo"}]}`))
+	}))
+	defer server.Close()
+
+	auth, err := NewAuthTransport(context.Background(), http.DefaultTransport, &countingTokenProvider{prefix: "first"})
+	if err != nil {
+		t.Fatalf("NewAuthTransport failed: %v", err)
+	}
+	env := &AzureDevOpsEnvironment{
+		connection:      auth.Connection(server.URL),
+		organizationUrl: server.URL,
+		project:         "project",
+		httpClient:      &http.Client{Transport: auth},
+	}
+
+	// The repository lookup is a request created by the SDK client with the connection's token
+	id, err := env.getRepoId("repo")
+	if err != nil {
+		t.Fatalf("getRepoId failed: %v", err)
+	}
+
+	if id != "5f0e0b1e-3c4a-4d58-9a1e-2b6f7c8d9e0f" {
+		t.Errorf("got repository ID %q, want the ID of Repo", id)
+	}
+	if len(received) != 2 || received[0] != "Bearer first-1" || received[1] != "Bearer first-2" {
+		t.Errorf("got Authorization headers %q, want the first and then the refreshed token", received)
+	}
+}
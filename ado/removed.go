@@ -0,0 +1,91 @@
+package ado
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strings"
+)
+
+// removedFile is a YAML file a pull request deletes or renames
+type removedFile struct {
+	path string
+	// renamedTo is the new path of a renamed file, empty if the file is deleted
+	renamedTo string
+}
+
+// describe names what happened to the file, for diagnostics
+func (f removedFile) describe() string {
+	if f.renamedTo != "" {
+		return fmt.Sprintf("%s is renamed to %s", normalizePipelinePath(f.path), normalizePipelinePath(f.renamedTo))
+	}
+
+	return fmt.Sprintf("%s is deleted", normalizePipelinePath(f.path))
+}
+
+// checkRemovedFiles reports the pipelines of the repository that still use a YAML file the pull request deletes or
+// renames, either as their root file or, in the source branch, as a template. The Preview API can't catch these, as
+// neither the pipeline definition nor unchanged pipelines are part of the pull request. Template references can only
+// be checked when the template graph is known.
+func (c ValidationClient) checkRemovedFiles(repo *repositoryPipelines, removed []removedFile, sources fs.FS) []ValidationResult {
+	removedFiles := make(map[string]removedFile, len(removed))
+	for _, file := range removed {
+		// A file that is added back under the same path still exists
+		if sources != nil {
+			if _, err := fs.Stat(sources, strings.TrimPrefix(normalizePipelinePath(file.path), "/")); err == nil {
+				continue
+			}
+		}
+		removedFiles[pipelinePathKey(file.path)] = file
+	}
+	if len(removedFiles) == 0 {
+		return nil
+	}
+
+	if repo.graph == nil {
+		log.Printf("checkRemovedFiles: no sources directory available, templates of deleted or renamed files will not be checked")
+	}
+
+	results := make([]ValidationResult, 0)
+	for _, pipeline := range repo.pipes {
+		diagnostics := make([]Diagnostic, 0)
+		if file, ok := removedFiles[pipelinePathKey(pipeline.FilePath)]; ok {
+			diagnostics = append(diagnostics, Diagnostic{
+				File:     normalizePipelinePath(file.path),
+				Message:  fmt.Sprintf("%s, but pipeline %d still uses it as its YAML file. Update the pipeline definition or keep the file.", file.describe(), pipeline.Id),
+				Severity: SeverityError,
+				Rule:     RuleRemovedFileInUse,
+			})
+		} else if repo.graph != nil {
+			// Dependencies are a set, so they are sorted for a stable report
+			templates := make([]string, 0)
+			for file := range repo.graph.dependencies[pipeline.Id] {
+				if _, ok := removedFiles[pipelinePathKey(file)]; ok {
+					templates = append(templates, file)
+				}
+			}
+			sort.Strings(templates)
+
+			for _, template := range templates {
+				file := removedFiles[pipelinePathKey(template)]
+				diagnostics = append(diagnostics, Diagnostic{
+					File:     normalizePipelinePath(file.path),
+					Message:  fmt.Sprintf("%s, but the pipeline still references it as a template", file.describe()),
+					Severity: SeverityError,
+					Rule:     RuleRemovedFileInUse,
+				})
+			}
+		}
+
+		if len(diagnostics) > 0 {
+			results = append(results, ValidationResult{
+				pipelineId:   pipeline.Id,
+				pipelinePath: pipeline.FilePath,
+				diagnostics:  diagnostics,
+			})
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,88 @@
+package ado
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCheckRemovedFiles(t *testing.T) {
+	// The sources are the source branch of the pull request, so deleted files are not in them
+	sources := fstest.MapFS{
+		"pipelines/ci.yml":        {Data: []byte("jobs:\n- template: /templates/jobs.yml\n")},
+		"pipelines/release.yml":   {Data: []byte("steps:\n- template: ../templates/old-steps.yml\n")},
+		"templates/jobs.yml":      {Data: []byte("jobs:\n- job: build\n  steps:\n  - template: steps.yml\n")},
+		"templates/new-steps.yml": {Data: []byte("steps:\n- script: echo\n")},
+		"templates/restored.yml":  {Data: []byte("steps:\n- script: echo\n")},
+	}
+	pipes := []Pipeline{
+		{Id: 1, FilePath: "/pipelines/ci.yml"},
+		{Id: 2, FilePath: "/pipelines/release.yml"},
+		{Id: 3, FilePath: "/Pipelines/Deleted.yml"},
+		{Id: 4, FilePath: "/pipelines/renamed.yml"},
+	}
+	removed := []removedFile{
+		{path: "/pipelines/deleted.yml"},
+		{path: "/pipelines/renamed.yml", renamedTo: "/pipelines/moved.yml"},
+		{path: "/templates/steps.yml"},
+		{path: "/templates/old-steps.yml", renamedTo: "/templates/new-steps.yml"},
+		{path: "/templates/restored.yml"},
+		{path: "/templates/unused.yml"},
+	}
+
+	tests := []struct {
+		name    string
+		sources fs.FS
+		want    map[int][]Diagnostic
+	}{
+		{
+			name:    "with sources",
+			sources: sources,
+			want: map[int][]Diagnostic{
+				1: {{File: "/templates/steps.yml", Message: "/templates/steps.yml is deleted, but the pipeline still references it as a template", Severity: SeverityError, Rule: RuleRemovedFileInUse}},
+				2: {{File: "/templates/old-steps.yml", Message: "/templates/old-steps.yml is renamed to /templates/new-steps.yml, but the pipeline still references it as a template", Severity: SeverityError, Rule: RuleRemovedFileInUse}},
+				3: {{File: "/pipelines/deleted.yml", Message: "/pipelines/deleted.yml is deleted, but pipeline 3 still uses it as its YAML file. Update the pipeline definition or keep the file.", Severity: SeverityError, Rule: RuleRemovedFileInUse}},
+				4: {{File: "/pipelines/renamed.yml", Message: "/pipelines/renamed.yml is renamed to /pipelines/moved.yml, but pipeline 4 still uses it as its YAML file. Update the pipeline definition or keep the file.", Severity: SeverityError, Rule: RuleRemovedFileInUse}},
+			},
+		},
+		{
+			// Without sources, template references are unknown and only root files are checked
+			name: "without sources",
+			want: map[int][]Diagnostic{
+				3: {{File: "/pipelines/deleted.yml", Message: "/pipelines/deleted.yml is deleted, but pipeline 3 still uses it as its YAML file. Update the pipeline definition or keep the file.", Severity: SeverityError, Rule: RuleRemovedFileInUse}},
+				4: {{File: "/pipelines/renamed.yml", Message: "/pipelines/renamed.yml is renamed to /pipelines/moved.yml, but pipeline 4 still uses it as its YAML file. Update the pipeline definition or keep the file.", Severity: SeverityError, Rule: RuleRemovedFileInUse}},
+				// A file restored under the same path can't be told apart without sources
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c := ValidationClient{environment: &AzureDevOpsEnvironment{project: "project", repositoryName: "repo"}}
+		repo := &repositoryPipelines{pipes: pipes}
+		if tt.sources != nil {
+			graph := buildTemplateGraph(tt.sources, "project", "repo", pipes)
+			repo.graph = &graph
+		}
+
+		got := make(map[int][]Diagnostic)
+		for _, result := range c.checkRemovedFiles(repo, removed, tt.sources) {
+			got[result.pipelineId] = result.diagnostics
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got diagnostics %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRemovedFilesNothingRemoved(t *testing.T) {
+	sources := fstest.MapFS{
+		"pipelines/ci.yml": {Data: []byte("steps:\n- script: echo\n")},
+	}
+	repo := &repositoryPipelines{pipes: []Pipeline{{Id: 1, FilePath: "/pipelines/ci.yml"}}}
+
+	// The file is deleted and added back, so it still exists in the source branch
+	if got := (ValidationClient{}).checkRemovedFiles(repo, []removedFile{{path: "/pipelines/ci.yml"}}, sources); got != nil {
+		t.Errorf("got results %+v, want none", got)
+	}
+}
@@ -0,0 +1,358 @@
+package ado
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ValidationStatus string
+
+const (
+	StatusPassed ValidationStatus = "passed"
+	StatusFailed ValidationStatus = "failed"
+)
+
+// PipelineReport is the validation outcome of a single pipeline
+type PipelineReport struct {
+	PipelineId int
+	Path       string
+	// Parameters is the combination of runtime parameter values the pipeline was validated with, nil unless a
+	// parameter matrix was validated
+	Parameters map[string]string
+	Status     ValidationStatus
+	// Error is the validation error message, empty if the pipeline passed or only has diagnostics
+	Error       string
+	Diagnostics []Diagnostic
+	Duration    time.Duration
+	// FinalYaml is the fully expanded pipeline, empty if validation failed
+	FinalYaml string
+	// Fixed are the diagnostics found on the baseline but no longer with the changes, nil unless compared with a
+	// baseline
+	Fixed []Diagnostic
+	// Suppressed are the diagnostics ignored through inline comments or the baseline file
+	Suppressed []Diagnostic
+}
+
+// Name returns the pipeline path, followed by the parameter values it was validated with if any
+func (p PipelineReport) Name() string {
+	if len(p.Parameters) == 0 {
+		return p.Path
+	}
+
+	return fmt.Sprintf("%s (%s)", p.Path, p.parameterLabel())
+}
+
+// parameterLabel formats the parameter values as a sorted, comma separated list of name=value pairs
+func (p PipelineReport) parameterLabel() string {
+	names := make([]string, 0, len(p.Parameters))
+	for name := range p.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+p.Parameters[name])
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// key identifies a validated pipeline, including its parameter values, across reports
+func (p PipelineReport) key() string {
+	return fmt.Sprintf("%d:%s:%s", p.PipelineId, p.Path, p.parameterLabel())
+}
+
+// Messages returns the error message and all diagnostics of the pipeline as display strings
+func (p PipelineReport) Messages() []string {
+	messages := make([]string, 0, len(p.Diagnostics)+1)
+	if p.Error != "" {
+		messages = append(messages, p.Error)
+	}
+	for _, d := range p.Diagnostics {
+		if d.Baseline == BaselineExisting {
+			messages = append(messages, d.String()+" (pre-existing)")
+			continue
+		}
+		messages = append(messages, d.String())
+	}
+
+	return messages
+}
+
+// updateStatus sets the status from the error and the diagnostics of the pipeline
+func (p *PipelineReport) updateStatus() {
+	p.Status = StatusPassed
+	if p.Error != "" || hasErrors(p.Diagnostics) {
+		p.Status = StatusFailed
+	}
+}
+
+// Report is the structured result of a validation run
+type Report struct {
+	Results []PipelineReport
+}
+
+// newReport creates a report from validation results, ordered by pipeline path
+func newReport(results []ValidationResult) *Report {
+	report := &Report{
+		Results: make([]PipelineReport, 0, len(results)),
+	}
+
+	for _, result := range results {
+		entry := PipelineReport{
+			PipelineId:  result.pipelineId,
+			Path:        result.pipelinePath,
+			Parameters:  result.parameters,
+			Diagnostics: result.diagnostics,
+			Duration:    result.duration,
+			FinalYaml:   result.finalYaml,
+		}
+		if result.err != nil {
+			entry.Error = result.err.Error()
+		}
+		entry.updateStatus()
+		report.Results = append(report.Results, entry)
+	}
+
+	report.sort()
+
+	return report
+}
+
+// sort orders the results by pipeline path, ID and parameter values
+func (r *Report) sort() {
+	sort.SliceStable(r.Results, func(i, j int) bool {
+		a, b := r.Results[i], r.Results[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.PipelineId != b.PipelineId {
+			return a.PipelineId < b.PipelineId
+		}
+		return a.parameterLabel() < b.parameterLabel()
+	})
+}
+
+// addResults adds the outcome of checks other than the Preview call to the report. Diagnostics of pipelines that are
+// already in the report are added to every entry of the pipeline, other pipelines are added as new entries.
+func (r *Report) addResults(results []ValidationResult) {
+	for _, added := range newReport(results).Results {
+		found := false
+		for i := range r.Results {
+			result := &r.Results[i]
+			if result.PipelineId != added.PipelineId || result.Path != added.Path {
+				continue
+			}
+			found = true
+			result.Diagnostics = append(result.Diagnostics, added.Diagnostics...)
+			if result.Error == "" {
+				result.Error = added.Error
+			}
+			result.updateStatus()
+		}
+		if !found {
+			r.Results = append(r.Results, added)
+		}
+	}
+
+	r.sort()
+}
+
+// ApplyRuleSeverities changes the severity of diagnostics by rule and updates the pipeline statuses accordingly.
+// Diagnostics of rules set to off are removed.
+func (r *Report) ApplyRuleSeverities(severities map[string]Severity) {
+	if len(severities) == 0 {
+		return
+	}
+
+	for i := range r.Results {
+		result := &r.Results[i]
+		if result.Diagnostics == nil {
+			continue
+		}
+
+		diagnostics := make([]Diagnostic, 0, len(result.Diagnostics))
+		for _, d := range result.Diagnostics {
+			if severity, ok := severities[d.Rule]; ok {
+				if severity == SeverityOff {
+					continue
+				}
+				d.Severity = severity
+			}
+			diagnostics = append(diagnostics, d)
+		}
+		result.Diagnostics = diagnostics
+		result.updateStatus()
+	}
+}
+
+// Failed returns the number of pipelines that failed validation
+func (r *Report) Failed() int {
+	failed := 0
+	for _, result := range r.Results {
+		if result.Status == StatusFailed {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// Duration returns the summed validation duration of all pipelines
+func (r *Report) Duration() time.Duration {
+	var total time.Duration
+	for _, result := range r.Results {
+		total += result.Duration
+	}
+
+	return total
+}
+
+type OutputFormat string
+
+const (
+	OutputText  OutputFormat = "text"
+	OutputJson  OutputFormat = "json"
+	OutputJUnit OutputFormat = "junit"
+	OutputSarif OutputFormat = "sarif"
+)
+
+var OutputFormats = []OutputFormat{OutputText, OutputJson, OutputJUnit, OutputSarif}
+
+// ParseOutputFormat parses an output format name, case-insensitively
+func ParseOutputFormat(format string) (OutputFormat, error) {
+	for _, f := range OutputFormats {
+		if strings.EqualFold(format, string(f)) {
+			return f, nil
+		}
+	}
+
+	return "", fmt.Errorf("ParseOutputFormat: unknown output format %q", format)
+}
+
+// Render writes the report to w in the given format
+func (r *Report) Render(w io.Writer, format OutputFormat) error {
+	switch format {
+	case OutputText:
+		return r.renderText(w)
+	case OutputJson:
+		return r.renderJson(w)
+	case OutputJUnit:
+		return r.renderJUnit(w)
+	case OutputSarif:
+		return r.renderSarif(w)
+	default:
+		return fmt.Errorf("Render: unknown output format %q", format)
+	}
+}
+
+func (r *Report) renderText(w io.Writer) error {
+	if len(r.Results) == 0 {
+		_, err := fmt.Fprintln(w, "no changed pipelines found")
+		return err
+	}
+
+	for _, result := range r.Results {
+		var err error
+		switch {
+		case result.Status == StatusFailed && result.Error != "":
+			_, err = fmt.Fprintf(w, "pipeline %s failed validation: %s\n", result.Name(), result.Error)
+		case result.Status == StatusFailed:
+			_, err = fmt.Fprintf(w, "pipeline %s failed validation\n", result.Name())
+		default:
+			_, err = fmt.Fprintf(w, "pipeline %s passed validation\n", result.Name())
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, d := range result.Diagnostics {
+			severity := string(d.Severity)
+			if d.Baseline == BaselineExisting {
+				severity += " (pre-existing)"
+			}
+			if _, err := fmt.Fprintf(w, "  %s: %s\n", severity, d); err != nil {
+				return err
+			}
+		}
+		for _, d := range result.Fixed {
+			if _, err := fmt.Fprintf(w, "  fixed: %s\n", d); err != nil {
+				return err
+			}
+		}
+		if len(result.Suppressed) > 0 {
+			if _, err := fmt.Fprintf(w, "  %d diagnostic(s) suppressed\n", len(result.Suppressed)); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d of %d pipeline(s) failed validation\n", r.Failed(), len(r.Results))
+	return err
+}
+
+type jsonDiagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	Message  string   `json:"message"`
+	Severity Severity `json:"severity"`
+	Rule     string   `json:"rule,omitempty"`
+	// Fingerprint identifies the diagnostic in baseline files
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Baseline is new or existing when compared with a baseline
+	Baseline BaselineState `json:"baseline,omitempty"`
+}
+
+type jsonPipelineReport struct {
+	PipelineId  int               `json:"pipelineId"`
+	Path        string            `json:"path"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+	Status      ValidationStatus  `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Diagnostics []jsonDiagnostic  `json:"diagnostics,omitempty"`
+	Fixed       []jsonDiagnostic  `json:"fixed,omitempty"`
+	Suppressed  int               `json:"suppressed,omitempty"`
+	DurationMs  int64             `json:"durationMs"`
+}
+
+type jsonReport struct {
+	Total   int                  `json:"total"`
+	Failed  int                  `json:"failed"`
+	Results []jsonPipelineReport `json:"results"`
+}
+
+func (r *Report) renderJson(w io.Writer) error {
+	out := jsonReport{
+		Total:   len(r.Results),
+		Failed:  r.Failed(),
+		Results: make([]jsonPipelineReport, 0, len(r.Results)),
+	}
+	for _, result := range r.Results {
+		entry := jsonPipelineReport{
+			PipelineId: result.PipelineId,
+			Path:       result.Path,
+			Parameters: result.Parameters,
+			Status:     result.Status,
+			Error:      result.Error,
+			Suppressed: len(result.Suppressed),
+			DurationMs: result.Duration.Milliseconds(),
+		}
+		for _, d := range result.Diagnostics {
+			entry.Diagnostics = append(entry.Diagnostics, jsonDiagnostic(d))
+		}
+		for _, d := range result.Fixed {
+			entry.Fixed = append(entry.Fixed, jsonDiagnostic(d))
+		}
+		out.Results = append(out.Results, entry)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
@@ -0,0 +1,139 @@
+package ado
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testReport returns a report with a passed pipeline, a pipeline failed by diagnostics that were compared with a
+// baseline, a pipeline whose validation errored and a pipeline skipped because the run was cancelled
+func testReport() *Report {
+	report := newReport([]ValidationResult{
+		{
+			pipelineId:   1,
+			pipelinePath: "/azure-pipelines.yml",
+			duration:     1500 * time.Millisecond,
+			diagnostics: []Diagnostic{
+				{File: "/azure-pipelines.yml", Message: "Unknown key 'trigger2'", Severity: SeverityWarning, Rule: RuleSchemaUnknownKey},
+			},
+		},
+		{
+			pipelineId:   2,
+			pipelinePath: "/pipelines/build.yml",
+			parameters:   map[string]string{"environment": "dev"},
+			duration:     250 * time.Millisecond,
+			diagnostics: []Diagnostic{
+				{File: "/templates/steps #1.yml", Line: 12, Column: 5, Message: "Unexpected value 'scripts'", Severity: SeverityError, Rule: RulePreviewUnexpectedValue, Fingerprint: "f1"},
+				{File: "/pipelines/build.yml", Line: 3, Column: 1, Message: "A template expression is not allowed here", Severity: SeverityError, Rule: RulePreviewError},
+			},
+		},
+		{
+			pipelineId:   3,
+			pipelinePath: "/pipelines/deploy app.yml",
+			err:          errors.New("TF401019: The Git repository does not exist"),
+		},
+		{
+			pipelineId:   4,
+			pipelinePath: "/pipelines/release.yml",
+			err:          context.Canceled,
+		},
+	})
+	report.ApplyBaseline(newReport([]ValidationResult{
+		{
+			pipelineId:   2,
+			pipelinePath: "/pipelines/build.yml",
+			parameters:   map[string]string{"environment": "dev"},
+			diagnostics: []Diagnostic{
+				{File: "/pipelines/build.yml", Line: 4, Column: 1, Message: "A template expression is not allowed here", Severity: SeverityError, Rule: RulePreviewError},
+				{File: "/templates/old.yml", Message: "File not found", Severity: SeverityError, Rule: RulePreviewTemplateNotFound},
+			},
+		},
+	}))
+	report.Results[0].Suppressed = []Diagnostic{
+		{File: "/azure-pipelines.yml", Line: 7, Column: 3, Message: "Unknown key 'pool2'", Severity: SeverityError, Rule: RuleSchemaUnknownKey},
+	}
+
+	return report
+}
+
+// assertJsonEqual compares the JSON documents structurally, so neither the key order nor the indentation matters
+func assertJsonEqual(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("failed to unmarshal output %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("failed to unmarshal expected output: %v", err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("got output\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRenderJson(t *testing.T) {
+	var out bytes.Buffer
+	if err := testReport().Render(&out, OutputJson); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	assertJsonEqual(t, out.Bytes(), `{
+  "total": 4,
+  "failed": 3,
+  "results": [
+    {
+      "pipelineId": 1,
+      "path": "/azure-pipelines.yml",
+      "status": "passed",
+      "diagnostics": [
+        {"file": "/azure-pipelines.yml", "message": "Unknown key 'trigger2'", "severity": "warning", "rule": "schema-unknown-key", "baseline": "new"}
+      ],
+      "suppressed": 1,
+      "durationMs": 1500
+    },
+    {
+      "pipelineId": 2,
+      "path": "/pipelines/build.yml",
+      "parameters": {"environment": "dev"},
+      "status": "failed",
+      "diagnostics": [
+        {"file": "/templates/steps #1.yml", "line": 12, "column": 5, "message": "Unexpected value 'scripts'", "severity": "error", "rule": "preview-unexpected-value", "fingerprint": "f1", "baseline": "new"},
+        {"file": "/pipelines/build.yml", "line": 3, "column": 1, "message": "A template expression is not allowed here", "severity": "error", "rule": "preview-error", "baseline": "existing"}
+      ],
+      "fixed": [
+        {"file": "/templates/old.yml", "message": "File not found", "severity": "error", "rule": "preview-template-not-found", "baseline": "fixed"}
+      ],
+      "durationMs": 250
+    },
+    {
+      "pipelineId": 3,
+      "path": "/pipelines/deploy app.yml",
+      "status": "failed",
+      "error": "TF401019: The Git repository does not exist",
+      "durationMs": 0
+    },
+    {
+      "pipelineId": 4,
+      "path": "/pipelines/release.yml",
+      "status": "failed",
+      "error": "context canceled",
+      "durationMs": 0
+    }
+  ]
+}`)
+}
+
+func TestRenderJsonEmpty(t *testing.T) {
+	var out bytes.Buffer
+	if err := (&Report{}).Render(&out, OutputJson); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	assertJsonEqual(t, out.Bytes(), `{"total": 0, "failed": 0, "results": []}`)
+}
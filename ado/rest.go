@@ -0,0 +1,75 @@
+package ado
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// restApiVersion is the version of the REST APIs called without the SDK's generated clients
+const restApiVersion = "7.0"
+
+// projectApiUrl returns the URL of a REST API of the environment's project. The path is relative to the project's
+// _apis, and its segments must be escaped.
+func (e *AzureDevOpsEnvironment) projectApiUrl(path string, query url.Values) string {
+	apiUrl := fmt.Sprintf("%s/%s/_apis/%s", strings.TrimRight(e.organizationUrl, "/"), url.PathEscape(e.project), path)
+	if len(query) > 0 {
+		apiUrl += "?" + query.Encode()
+	}
+
+	return apiUrl
+}
+
+// sendRequest sends a REST request with the environment's HTTP client and decodes the JSON response into
+// responseValue, unless it is nil. The request is created by the SDK client, which sets the headers Azure DevOps
+// expects, but the SDK's own http.Client can't be given a transport, so requests sent by the SDK are neither retried
+// nor get refreshed tokens. Error responses are returned as azuredevops.WrappedError, like the SDK does.
+func (e *AzureDevOpsEnvironment) sendRequest(ctx context.Context, client *azuredevops.Client, method string, requestUrl string, requestBody interface{}, responseValue interface{}) error {
+	_, err := e.sendRequestWithHeader(ctx, client, method, requestUrl, requestBody, responseValue)
+	return err
+}
+
+// sendRequestWithHeader sends a REST request like sendRequest and also returns the response headers, for example the
+// continuation token of a paged list
+func (e *AzureDevOpsEnvironment) sendRequestWithHeader(ctx context.Context, client *azuredevops.Client, method string, requestUrl string, requestBody interface{}, responseValue interface{}) (http.Header, error) {
+	var body io.Reader
+	mediaType := ""
+	if requestBody != nil {
+		content, err := json.Marshal(requestBody)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		body = bytes.NewReader(content)
+		mediaType = "application/json"
+	}
+
+	req, err := client.CreateRequestMessage(ctx, method, requestUrl, restApiVersion, body, mediaType, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, client.UnwrapError(resp)
+	}
+	if responseValue == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.Header, resp.Body.Close()
+	}
+
+	return resp.Header, client.UnmarshalBody(resp, responseValue)
+}
+
+// sdkClient returns the SDK client for the organization, which creates the requests sent with sendRequest
+func (e *AzureDevOpsEnvironment) sdkClient() *azuredevops.Client {
+	return e.connection.GetClientByUrl(e.connection.BaseUrl)
+}
@@ -0,0 +1,195 @@
+package ado
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryOptions configure how requests to Azure DevOps are retried
+type RetryOptions struct {
+	// MaxAttempts is the total number of attempts per request, including the first one
+	MaxAttempts int
+	// BaseDelay is the backoff delay before the first retry, doubled for every following retry
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff delay. Delays requested by the server with Retry-After are not capped.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryOptions returns the retry options used unless configured otherwise
+func DefaultRetryOptions() RetryOptions {
+	return RetryOptions{
+		MaxAttempts: 5,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+	}
+}
+
+type retryTransport struct {
+	base    http.RoundTripper
+	options RetryOptions
+}
+
+// NewRetryTransport wraps a transport so throttled (429) and temporarily unavailable (5xx) responses and network
+// errors are retried with exponential backoff and jitter. Retry-After and X-RateLimit-Reset headers sent by Azure
+// DevOps take precedence over the backoff. Only idempotent requests and requests marked with withRetryable are
+// retried after failures, as repeating other requests, such as creating a pull request thread, could apply them twice.
+// Throttled requests are always retried.
+//
+// The transport is passed to the environment with WithHTTPClient or NewAzureDevOpsEnvironmentFromPR, whose HTTP client
+// sends all REST calls, including the ones whose requests are created by the SDK, see sendRequest.
+func NewRetryTransport(base http.RoundTripper, options RetryOptions) http.RoundTripper {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 1
+	}
+
+	return &retryTransport{
+		base:    base,
+		options: options,
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			// Bodies can only be read once, so every retry needs a fresh copy
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(attemptReq)
+		if attempt >= t.options.MaxAttempts || !t.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay := t.retryDelay(resp, attempt)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryableKey marks the context of POST and PATCH requests that can be retried
+type retryableKey struct{}
+
+// withRetryable marks a POST or PATCH request as safe to retry, for requests that only read, like pipeline previews, or
+// that have the same effect when sent twice, like setting the content of a comment
+func withRetryable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryableKey{}, true)
+}
+
+// isIdempotent checks whether sending the request more than once has the same effect as sending it once
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		retryable, _ := req.Context().Value(retryableKey{}).(bool)
+		return retryable
+	}
+}
+
+// shouldRetry checks whether a request can and should be retried after the given response or error
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		// Cancellation is intentional and not a transient failure
+		return isIdempotent(req) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	// Throttled requests are rejected before they are processed, so any request can be repeated. After other failures
+	// the request may have been applied.
+	if !isIdempotent(req) {
+		return resp.StatusCode == http.StatusTooManyRequests
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay returns how long to wait before the next attempt, preferring the delay requested by the server
+func (t *retryTransport) retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return delay
+		}
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+				if delay := time.Until(time.Unix(reset, 0)); delay > 0 {
+					return delay
+				}
+			}
+		}
+	}
+
+	backoff := t.options.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > t.options.MaxDelay {
+		backoff = t.options.MaxDelay
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	// Full jitter spreads out retries of concurrent requests that were throttled together
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+// sleepContext waits for the given duration or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
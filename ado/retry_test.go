@@ -0,0 +1,205 @@
+package ado
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testRetryOptions retry without noticeable delays
+var testRetryOptions = RetryOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+// statusSequence serves the given status codes in order, repeating the last one, and records the bodies it receives
+type statusSequence struct {
+	mu       sync.Mutex
+	statuses []int
+	headers  http.Header
+	bodies   []string
+}
+
+func (s *statusSequence) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+	status := s.statuses[len(s.statuses)-1]
+	if len(s.bodies) <= len(s.statuses) {
+		status = s.statuses[len(s.bodies)-1]
+	}
+	if status != http.StatusOK {
+		for key, values := range s.headers {
+			w.Header()[key] = values
+		}
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(`{"value":[]}`))
+}
+
+func (s *statusSequence) requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.bodies)
+}
+
+func TestRetryTransportRetryAfter(t *testing.T) {
+	handler := &statusSequence{
+		statuses: []int{http.StatusTooManyRequests, http.StatusOK},
+		headers:  http.Header{"Retry-After": []string{"1"}},
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(http.DefaultTransport, testRetryOptions)}
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || handler.requests() != 2 {
+		t.Errorf("got status %d after %d requests, want 200 after 2", resp.StatusCode, handler.requests())
+	}
+	// Retry-After takes precedence over the backoff and is not capped by MaxDelay
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want the second requested by Retry-After", elapsed)
+	}
+}
+
+func TestRetryTransportMaxAttempts(t *testing.T) {
+	handler := &statusSequence{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := &http.Client{Transport: NewRetryTransport(http.DefaultTransport, testRetryOptions)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || handler.requests() != testRetryOptions.MaxAttempts {
+		t.Errorf("got status %d after %d requests, want 503 after %d", resp.StatusCode, handler.requests(), testRetryOptions.MaxAttempts)
+	}
+}
+
+func TestRetryTransportPost(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryable    bool
+		statuses     []int
+		wantRequests int
+		wantStatus   int
+	}{
+		{
+			name:         "not retried after a server error",
+			statuses:     []int{http.StatusInternalServerError, http.StatusOK},
+			wantRequests: 1,
+			wantStatus:   http.StatusInternalServerError,
+		},
+		{
+			name:         "retried after throttling",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			wantRequests: 2,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "marked as retryable",
+			retryable:    true,
+			statuses:     []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK},
+			wantRequests: 3,
+			wantStatus:   http.StatusOK,
+		},
+		{
+			name:         "client errors are not retried",
+			retryable:    true,
+			statuses:     []int{http.StatusBadRequest, http.StatusOK},
+			wantRequests: 1,
+			wantStatus:   http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &statusSequence{statuses: tt.statuses}
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			ctx := context.Background()
+			if tt.retryable {
+				ctx = withRetryable(ctx)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader(`{"previewRun":true}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := &http.Client{Transport: NewRetryTransport(http.DefaultTransport, testRetryOptions)}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus || handler.requests() != tt.wantRequests {
+				t.Errorf("got status %d after %d requests, want %d after %d", resp.StatusCode, handler.requests(), tt.wantStatus, tt.wantRequests)
+			}
+			for i, body := range handler.bodies {
+				if body != `{"previewRun":true}` {
+					t.Errorf("request %d has body %q, want the original body", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{value: "", wantOk: false},
+		{value: "0", want: 0, wantOk: true},
+		{value: "30", want: 30 * time.Second, wantOk: true},
+		{value: "-1", wantOk: false},
+		{value: "soon", wantOk: false},
+		{value: "Wed, 21 Oct 2015 07:28:00 GMT", want: 0, wantOk: true},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value)
+		if got != tt.want || ok != tt.wantOk {
+			t.Errorf("parseRetryAfter(%q) = %s, %v, want %s, %v", tt.value, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestSdkRequestsAreRetried(t *testing.T) {
+	handler := &statusSequence{statuses: []int{http.StatusServiceUnavailable, http.StatusOK}}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	env := &AzureDevOpsEnvironment{
+		connection:      NewOauthConnection(server.URL, "token"),
+		organizationUrl: server.URL,
+		project:         "project",
+		httpClient:      &http.Client{Transport: NewRetryTransport(http.DefaultTransport, testRetryOptions)},
+	}
+
+	// The repository lookup is a request created by the SDK client
+	_, err := env.getRepoId("repo")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got %v, want the repository not to be found in the empty list", err)
+	}
+	if handler.requests() != 2 {
+		t.Errorf("got %d requests, want the failed one to be retried", handler.requests())
+	}
+}
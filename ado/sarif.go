@@ -0,0 +1,216 @@
+package ado
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SARIF 2.1.0 output: https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifToolUri = "https://github.com/drbushytop/ado-yaml-validator"
+
+	// ruleIdPipelineValidation is reported when the Preview API rejects a pipeline
+	ruleIdPipelineValidation = "pipeline-validation"
+
+	// sarifFingerprintKey names the diagnostic fingerprint among the partial fingerprints of a result
+	sarifFingerprintKey = "adoYamlValidatorFingerprint/v1"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationUri string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	Id               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleId    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+	// BaselineState is new or unchanged when compared with a baseline
+	BaselineState string `json:"baselineState,omitempty"`
+	// PartialFingerprints let code scanning tools track results across runs
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	Uri       string `json:"uri"`
+	UriBaseId string `json:"uriBaseId"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// sarifRuleDescriptions describes the rules that can appear in reports
+var sarifRuleDescriptions = map[string]string{
+	ruleIdPipelineValidation: "Pipeline failed validation by Azure DevOps",
+	RuleYamlSyntax:           "File is not valid YAML",
+	RuleSchemaUnknownKey:     "Key or value is not allowed by the Azure Pipelines schema",
+	RuleSchemaType:           "Value has the wrong type",
+	RuleSchemaRequired:       "Required property is missing",
+	RuleSchemaValue:          "Value is not one of the allowed values",
+
+	RulePreviewUnexpectedValue:  "Key or value is not allowed by Azure DevOps",
+	RulePreviewMissingParameter: "Required parameter has no value",
+	RulePreviewTemplateNotFound: "Template or file could not be found",
+	RulePreviewError:            "Pipeline failed validation by Azure DevOps",
+
+	RuleRemovedFileInUse: "Deleted or renamed file is still used by a pipeline",
+}
+
+func (r *Report) renderSarif(w io.Writer) error {
+	results := make([]sarifResult, 0)
+	usedRules := make(map[string]bool)
+
+	for _, result := range r.Results {
+		// Results of different parameter combinations are told apart by their message
+		suffix := ""
+		if len(result.Parameters) > 0 {
+			suffix = " (" + result.parameterLabel() + ")"
+		}
+
+		for _, d := range result.Diagnostics {
+			ruleId := d.Rule
+			if ruleId == "" {
+				ruleId = ruleIdPipelineValidation
+			}
+			usedRules[ruleId] = true
+
+			level := "error"
+			if d.Severity == SeverityWarning {
+				level = "warning"
+			}
+			line := d.Line
+			if line == 0 {
+				line = 1
+			}
+			baselineState := ""
+			switch d.Baseline {
+			case BaselineNew:
+				baselineState = "new"
+			case BaselineExisting:
+				baselineState = "unchanged"
+			}
+			result := sarifResult{
+				RuleId:        ruleId,
+				Level:         level,
+				Message:       sarifMessage{Text: d.Message + suffix},
+				Locations:     []sarifLocation{newSarifLocation(d.File, line, d.Column)},
+				BaselineState: baselineState,
+			}
+			if d.Fingerprint != "" {
+				result.PartialFingerprints = map[string]string{sarifFingerprintKey: d.Fingerprint}
+			}
+			results = append(results, result)
+		}
+
+		// Failures without diagnostics are reported against the pipeline's root file
+		if result.Status == StatusFailed && result.Error != "" {
+			usedRules[ruleIdPipelineValidation] = true
+			results = append(results, sarifResult{
+				RuleId:    ruleIdPipelineValidation,
+				Level:     "error",
+				Message:   sarifMessage{Text: result.Error + suffix},
+				Locations: []sarifLocation{newSarifLocation(result.Path, 1, 0)},
+			})
+		}
+	}
+
+	ruleIds := make([]string, 0, len(usedRules))
+	for id := range usedRules {
+		ruleIds = append(ruleIds, id)
+	}
+	sort.Strings(ruleIds)
+
+	rules := make([]sarifRule, 0, len(ruleIds))
+	for _, id := range ruleIds {
+		description, ok := sarifRuleDescriptions[id]
+		if !ok {
+			description = id
+		}
+		rules = append(rules, sarifRule{
+			Id:               id,
+			ShortDescription: sarifMessage{Text: description},
+		})
+	}
+
+	out := sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{
+			{
+				Tool: sarifTool{
+					Driver: sarifDriver{
+						Name:           "ado-yaml-validator",
+						InformationUri: sarifToolUri,
+						Rules:          rules,
+					},
+				},
+				Results: results,
+			},
+		},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(out)
+}
+
+// newSarifLocation creates a location relative to the source root for a repository path. The path segments are
+// percent-encoded, as SARIF locations are URI references.
+func newSarifLocation(path string, line int, column int) sarifLocation {
+	uri := &url.URL{Path: strings.TrimPrefix(path, "/")}
+	return sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{
+				Uri:       uri.EscapedPath(),
+				UriBaseId: "%SRCROOT%",
+			},
+			Region: &sarifRegion{
+				StartLine:   line,
+				StartColumn: column,
+			},
+		},
+	}
+}
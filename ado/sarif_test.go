@@ -0,0 +1,97 @@
+package ado
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRenderSarif(t *testing.T) {
+	var out bytes.Buffer
+	if err := testReport().Render(&out, OutputSarif); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	assertJsonEqual(t, out.Bytes(), `{
+  "version": "2.1.0",
+  "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+  "runs": [
+    {
+      "tool": {
+        "driver": {
+          "name": "ado-yaml-validator",
+          "informationUri": "https://github.com/drbushytop/ado-yaml-validator",
+          "rules": [
+            {"id": "pipeline-validation", "shortDescription": {"text": "Pipeline failed validation by Azure DevOps"}},
+            {"id": "preview-error", "shortDescription": {"text": "Pipeline failed validation by Azure DevOps"}},
+            {"id": "preview-unexpected-value", "shortDescription": {"text": "Key or value is not allowed by Azure DevOps"}},
+            {"id": "schema-unknown-key", "shortDescription": {"text": "Key or value is not allowed by the Azure Pipelines schema"}}
+          ]
+        }
+      },
+      "results": [
+        {
+          "ruleId": "schema-unknown-key",
+          "level": "warning",
+          "message": {"text": "Unknown key 'trigger2'"},
+          "locations": [
+            {"physicalLocation": {"artifactLocation": {"uri": "azure-pipelines.yml", "uriBaseId": "%SRCROOT%"}, "region": {"startLine": 1}}}
+          ],
+          "baselineState": "new"
+        },
+        {
+          "ruleId": "preview-unexpected-value",
+          "level": "error",
+          "message": {"text": "Unexpected value 'scripts' (environment=dev)"},
+          "locations": [
+            {"physicalLocation": {"artifactLocation": {"uri": "templates/steps%20%231.yml", "uriBaseId": "%SRCROOT%"}, "region": {"startLine": 12, "startColumn": 5}}}
+          ],
+          "baselineState": "new",
+          "partialFingerprints": {"adoYamlValidatorFingerprint/v1": "f1"}
+        },
+        {
+          "ruleId": "preview-error",
+          "level": "error",
+          "message": {"text": "A template expression is not allowed here (environment=dev)"},
+          "locations": [
+            {"physicalLocation": {"artifactLocation": {"uri": "pipelines/build.yml", "uriBaseId": "%SRCROOT%"}, "region": {"startLine": 3, "startColumn": 1}}}
+          ],
+          "baselineState": "unchanged"
+        },
+        {
+          "ruleId": "pipeline-validation",
+          "level": "error",
+          "message": {"text": "TF401019: The Git repository does not exist"},
+          "locations": [
+            {"physicalLocation": {"artifactLocation": {"uri": "pipelines/deploy%20app.yml", "uriBaseId": "%SRCROOT%"}, "region": {"startLine": 1}}}
+          ]
+        },
+        {
+          "ruleId": "pipeline-validation",
+          "level": "error",
+          "message": {"text": "context canceled"},
+          "locations": [
+            {"physicalLocation": {"artifactLocation": {"uri": "pipelines/release.yml", "uriBaseId": "%SRCROOT%"}, "region": {"startLine": 1}}}
+          ]
+        }
+      ]
+    }
+  ]
+}`)
+}
+
+func TestNewSarifLocationEscapesUri(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/azure-pipelines.yml", want: "azure-pipelines.yml"},
+		{path: "/pipelines/build app.yml", want: "pipelines/build%20app.yml"},
+		{path: "/templates/#1/steps?.yml", want: "templates/%231/steps%3F.yml"},
+		{path: "/templates/100%.yml", want: "templates/100%25.yml"},
+	}
+	for _, tt := range tests {
+		if got := newSarifLocation(tt.path, 1, 0).PhysicalLocation.ArtifactLocation.Uri; got != tt.want {
+			t.Errorf("newSarifLocation(%q) uri = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
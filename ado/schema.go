@@ -0,0 +1,520 @@
+package ado
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultSchema is a hand-written subset of the official Azure Pipelines schema, bundled so offline validation works out
+// of the box. It covers the pipeline, stage, job and step structure, but not every keyword and task input.
+//
+//go:embed schema/pipelines.schema.json
+var defaultSchema []byte
+
+// Schema is a JSON schema for Azure Pipelines YAML files. Only the keywords used by the official Azure Pipelines schema
+// are supported: $ref, anyOf, oneOf, allOf, type, enum, pattern, properties, patternProperties, additionalProperties,
+// required and items, plus the firstProperty and ignoreCase extensions.
+type Schema struct {
+	root map[string]interface{}
+	// partial tells that the schema leaves out valid keywords, so unknown keys are reported as warnings
+	partial bool
+
+	patternsLock sync.Mutex
+	patterns     map[string]*regexp.Regexp
+}
+
+// DefaultSchema returns the bundled Azure Pipelines schema. It is a subset of the official schema, so unknown keys found
+// with it are reported as warnings.
+func DefaultSchema() (*Schema, error) {
+	schema, err := newSchema(defaultSchema)
+	if err != nil {
+		return nil, err
+	}
+	schema.partial = true
+
+	return schema, nil
+}
+
+// LoadSchema loads a schema from a file, for example a newer service-schema.json from the Azure Pipelines VS Code
+// extension
+func LoadSchema(path string) (*Schema, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadSchema: failed to read schema file: %w", err)
+	}
+
+	return newSchema(content)
+}
+
+// LoadPartialSchema loads a schema from a file that leaves out valid keywords, such as a hand-written subset of the
+// official schema. Unknown keys found with it are reported as warnings, as they may be valid.
+func LoadPartialSchema(path string) (*Schema, error) {
+	schema, err := LoadSchema(path)
+	if err != nil {
+		return nil, err
+	}
+	schema.partial = true
+
+	return schema, nil
+}
+
+func newSchema(content []byte) (*Schema, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("newSchema: failed to parse schema: %w", err)
+	}
+
+	return &Schema{
+		root:     root,
+		patterns: make(map[string]*regexp.Regexp),
+	}, nil
+}
+
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// ValidateFile validates the YAML content of a file against the schema. The file name is only used in the diagnostics.
+func (s *Schema) ValidateFile(file string, content []byte) []Diagnostic {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		d := Diagnostic{
+			File:     file,
+			Message:  err.Error(),
+			Severity: SeverityError,
+			Rule:     RuleYamlSyntax,
+		}
+		if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+			d.Line, _ = strconv.Atoi(match[1])
+			d.Column = 1
+		}
+		return []Diagnostic{d}
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	v := schemaValidator{schema: s, file: file}
+	v.validate(doc.Content[0], s.root)
+
+	return v.diagnostics
+}
+
+// looksLikePipeline checks whether a YAML file has any of the top level keys of a pipeline or template file. It is used
+// to skip other YAML files, like Kubernetes manifests, when validating all changed files.
+func looksLikePipeline(content []byte) bool {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil || len(doc.Content) == 0 {
+		// Broken YAML can't be classified, so it is validated to surface the syntax error
+		return err != nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return false
+	}
+	for _, key := range []string{"steps", "jobs", "stages", "extends", "trigger", "pr", "resources", "variables", "parameters", "pool", "schedules"} {
+		if mappingValue(root, key) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+type schemaValidator struct {
+	schema      *Schema
+	file        string
+	diagnostics []Diagnostic
+}
+
+func (v *schemaValidator) report(node *yaml.Node, rule string, format string, args ...interface{}) {
+	severity := SeverityError
+	if v.schema.partial && rule == RuleSchemaUnknownKey {
+		severity = SeverityWarning
+	}
+
+	v.diagnostics = append(v.diagnostics, Diagnostic{
+		File:     v.file,
+		Line:     node.Line,
+		Column:   node.Column,
+		Message:  fmt.Sprintf(format, args...),
+		Severity: severity,
+		Rule:     rule,
+	})
+}
+
+// resolve follows $ref pointers within the schema document
+func (v *schemaValidator) resolve(sch map[string]interface{}) map[string]interface{} {
+	for i := 0; i < 32; i++ {
+		ref, ok := sch["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#") {
+			return sch
+		}
+
+		var current interface{} = v.schema.root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+			if part == "" {
+				continue
+			}
+			part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+			m, ok := current.(map[string]interface{})
+			if !ok {
+				return map[string]interface{}{}
+			}
+			current = m[part]
+		}
+
+		next, ok := current.(map[string]interface{})
+		if !ok {
+			return map[string]interface{}{}
+		}
+		// Keywords next to a $ref are ignored, as in draft-07
+		sch = next
+	}
+
+	return sch
+}
+
+func (v *schemaValidator) validate(node *yaml.Node, sch map[string]interface{}) {
+	sch = v.resolve(sch)
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	// Template expressions are evaluated by Azure DevOps, so their result can't be validated here
+	if isTemplateExpression(node) {
+		return
+	}
+
+	if allOf, ok := sch["allOf"].([]interface{}); ok {
+		for _, s := range allOf {
+			if m, ok := s.(map[string]interface{}); ok {
+				v.validate(node, m)
+			}
+		}
+	}
+
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		if alternatives, ok := sch[keyword].([]interface{}); ok {
+			v.validateAlternatives(node, alternatives)
+		}
+	}
+
+	if t, ok := sch["type"]; ok && !typeMatches(node, t) {
+		v.report(node, RuleSchemaType, "Expected %s but found %s", describeType(t), nodeType(node))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.ScalarNode:
+		v.validateScalar(node, sch)
+	case yaml.MappingNode:
+		v.validateMapping(node, sch)
+	case yaml.SequenceNode:
+		v.validateSequence(node, sch)
+	}
+}
+
+// validateAlternatives validates a node against anyOf or oneOf alternatives. Like Azure DevOps, the alternative is
+// picked by the first property of a mapping, so errors are reported for the alternative the author meant instead of
+// for every alternative.
+func (v *schemaValidator) validateAlternatives(node *yaml.Node, alternatives []interface{}) {
+	candidates := v.narrowAlternatives(node, alternatives)
+	if len(candidates) == 0 {
+		v.report(node, RuleSchemaType, "Unexpected %s", describeNode(node))
+		return
+	}
+
+	var firstDiagnostics []Diagnostic
+	for i, candidate := range candidates {
+		sub := schemaValidator{schema: v.schema, file: v.file}
+		sub.validate(node, candidate)
+		if len(sub.diagnostics) == 0 {
+			return
+		}
+		if i == 0 {
+			firstDiagnostics = sub.diagnostics
+		}
+	}
+
+	if len(candidates) == 1 {
+		v.diagnostics = append(v.diagnostics, firstDiagnostics...)
+		return
+	}
+
+	if node.Kind == yaml.MappingNode && len(node.Content) > 0 {
+		v.report(node.Content[0], RuleSchemaUnknownKey, "Unexpected value '%s'", node.Content[0].Value)
+		return
+	}
+	v.report(node, RuleSchemaValue, "Unexpected %s", describeNode(node))
+}
+
+// narrowAlternatives returns the alternatives matching the kind of the node, narrowed down by first property and
+// required properties for mappings
+func (v *schemaValidator) narrowAlternatives(node *yaml.Node, alternatives []interface{}) []map[string]interface{} {
+	compatible := make([]map[string]interface{}, 0, len(alternatives))
+	for _, alternative := range alternatives {
+		m, ok := alternative.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		m = v.resolve(m)
+		if t, ok := m["type"]; ok && !typeMatches(node, t) {
+			continue
+		}
+		compatible = append(compatible, m)
+	}
+
+	if node.Kind != yaml.MappingNode || len(node.Content) == 0 {
+		return compatible
+	}
+
+	firstKey := node.Content[0].Value
+	byFirstProperty := make([]map[string]interface{}, 0)
+	byRequired := make([]map[string]interface{}, 0)
+	for _, m := range compatible {
+		if firstProperties, ok := m["firstProperty"].([]interface{}); ok {
+			for _, p := range firstProperties {
+				if p == firstKey {
+					byFirstProperty = append(byFirstProperty, m)
+				}
+			}
+		}
+		if required, ok := m["required"].([]interface{}); ok && len(required) > 0 {
+			allPresent := true
+			for _, r := range required {
+				if key, ok := r.(string); !ok || mappingValue(node, key) == nil {
+					allPresent = false
+				}
+			}
+			if allPresent {
+				byRequired = append(byRequired, m)
+			}
+		}
+	}
+
+	if len(byFirstProperty) > 0 {
+		return byFirstProperty
+	}
+	if len(byRequired) > 0 {
+		return byRequired
+	}
+
+	return compatible
+}
+
+func (v *schemaValidator) validateScalar(node *yaml.Node, sch map[string]interface{}) {
+	ignoreCase := sch["ignoreCase"] == "value" || sch["ignoreCase"] == "all"
+
+	if enum, ok := sch["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			value := fmt.Sprint(e)
+			if value == node.Value || (ignoreCase && strings.EqualFold(value, node.Value)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.report(node, RuleSchemaValue, "Unexpected value '%s'", node.Value)
+			return
+		}
+	}
+
+	if pattern, ok := sch["pattern"].(string); ok {
+		if ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		if re := v.schema.compilePattern(pattern); re != nil && !re.MatchString(node.Value) {
+			if nodeType(node) == "null" {
+				v.report(node, RuleSchemaValue, "A value is required")
+				return
+			}
+			v.report(node, RuleSchemaValue, "Unexpected value '%s'", node.Value)
+		}
+	}
+}
+
+func (v *schemaValidator) validateMapping(node *yaml.Node, sch map[string]interface{}) {
+	properties, _ := sch["properties"].(map[string]interface{})
+	patternProperties, _ := sch["patternProperties"].(map[string]interface{})
+	hasExpressionKey := false
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if isTemplateExpression(key) {
+			hasExpressionKey = true
+			continue
+		}
+
+		if propertySchema, ok := properties[key.Value].(map[string]interface{}); ok {
+			v.validate(value, propertySchema)
+			continue
+		}
+
+		matched := false
+		for pattern, patternSchema := range patternProperties {
+			re := v.schema.compilePattern(pattern)
+			if m, ok := patternSchema.(map[string]interface{}); ok && re != nil && re.MatchString(key.Value) {
+				v.validate(value, m)
+				matched = true
+			}
+		}
+		if matched {
+			continue
+		}
+
+		switch additional := sch["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.report(key, RuleSchemaUnknownKey, "Unexpected value '%s'", key.Value)
+			}
+		case map[string]interface{}:
+			v.validate(value, additional)
+		}
+	}
+
+	// Expression keys can add any property, so missing properties can't be reported reliably
+	if hasExpressionKey {
+		return
+	}
+	if required, ok := sch["required"].([]interface{}); ok {
+		for _, r := range required {
+			if key, ok := r.(string); ok && mappingValue(node, key) == nil {
+				v.report(node, RuleSchemaRequired, "Required property '%s' is missing", key)
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateSequence(node *yaml.Node, sch map[string]interface{}) {
+	items, ok := sch["items"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, item := range node.Content {
+		v.validateSequenceItem(item, items)
+	}
+}
+
+// validateSequenceItem validates a sequence item, looking through conditional and each insertions like
+// `- ${{ if ... }}:` whose values are lists of items themselves
+func (v *schemaValidator) validateSequenceItem(item *yaml.Node, items map[string]interface{}) {
+	if item.Kind == yaml.MappingNode && len(item.Content) == 2 && isTemplateExpression(item.Content[0]) {
+		if inserted := item.Content[1]; inserted.Kind == yaml.SequenceNode {
+			for _, child := range inserted.Content {
+				v.validateSequenceItem(child, items)
+			}
+		}
+		return
+	}
+
+	v.validate(item, items)
+}
+
+func (s *Schema) compilePattern(pattern string) *regexp.Regexp {
+	s.patternsLock.Lock()
+	defer s.patternsLock.Unlock()
+
+	if re, ok := s.patterns[pattern]; ok {
+		return re
+	}
+	// Patterns using JavaScript only regex features can't be checked and are ignored
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		re = nil
+	}
+	s.patterns[pattern] = re
+
+	return re
+}
+
+// isTemplateExpression checks whether a scalar contains a ${{ }} template expression
+func isTemplateExpression(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && strings.Contains(node.Value, "${{")
+}
+
+// nodeType returns the JSON schema type of a YAML node
+func nodeType(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	}
+
+	switch node.ShortTag() {
+	case "!!null":
+		return "null"
+	case "!!bool":
+		return "boolean"
+	case "!!int":
+		return "integer"
+	case "!!float":
+		return "number"
+	default:
+		return "string"
+	}
+}
+
+// typeMatches checks a node against a JSON schema type, which is either a single type name or a list of them. Azure
+// DevOps reads every scalar as a string, so any scalar is accepted where a string is expected.
+func typeMatches(node *yaml.Node, t interface{}) bool {
+	var types []string
+	switch typed := t.(type) {
+	case string:
+		types = []string{typed}
+	case []interface{}:
+		for _, name := range typed {
+			types = append(types, fmt.Sprint(name))
+		}
+	default:
+		return true
+	}
+
+	actual := nodeType(node)
+	for _, expected := range types {
+		switch {
+		case expected == actual:
+			return true
+		case expected == "string" && node.Kind == yaml.ScalarNode:
+			return true
+		case expected == "number" && actual == "integer":
+			return true
+		case actual == "null" && expected != "object" && expected != "array":
+			// Empty values are read as empty strings by Azure DevOps
+			return true
+		}
+	}
+
+	return false
+}
+
+func describeType(t interface{}) string {
+	if types, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(types))
+		for _, name := range types {
+			names = append(names, fmt.Sprint(name))
+		}
+		return strings.Join(names, " or ")
+	}
+
+	return fmt.Sprint(t)
+}
+
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "sequence"
+	default:
+		return fmt.Sprintf("value '%s'", node.Value)
+	}
+}
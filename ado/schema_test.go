@@ -0,0 +1,250 @@
+package ado
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testSchema covers the keywords the schema validator supports, in the style of the official Azure Pipelines schema
+const testSchema = `{
+  "$ref": "#/definitions/pipeline",
+  "definitions": {
+    "pipeline": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string"},
+        "timeout": {"type": "integer"},
+        "pool": {"$ref": "#/definitions/pool"},
+        "steps": {"type": "array", "items": {"$ref": "#/definitions/step"}},
+        "trigger": {
+          "anyOf": [
+            {"type": "string", "pattern": "^none$", "ignoreCase": "value"},
+            {"type": "array", "items": {"type": "string"}}
+          ]
+        }
+      },
+      "additionalProperties": false
+    },
+    "pool": {
+      "type": "object",
+      "properties": {"vmImage": {"type": "string"}, "demands": {"type": "array"}},
+      "additionalProperties": false,
+      "required": ["vmImage"]
+    },
+    "step": {
+      "oneOf": [
+        {
+          "type": "object",
+          "properties": {
+            "task": {"type": "string", "pattern": "\\S"},
+            "inputs": {"type": "object"}
+          },
+          "additionalProperties": false,
+          "required": ["task"],
+          "firstProperty": ["task"]
+        },
+        {
+          "type": "object",
+          "properties": {
+            "script": {"type": "string"},
+            "condition": {"type": "string", "enum": ["always()", "succeeded()"]}
+          },
+          "additionalProperties": false,
+          "required": ["script"],
+          "firstProperty": ["script"]
+        }
+      ]
+    }
+  }
+}`
+
+func TestSchemaValidateFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Diagnostic
+	}{
+		{
+			name: "valid",
+			content: `name: ci
+timeout: 10
+trigger: None
+pool:
+  vmImage: ubuntu-latest
+steps:
+- task: Bash@3
+  inputs:
+    script: echo
+- script: echo
+  condition: always()
+`,
+		},
+		{
+			name:    "unknown key",
+			content: "name: ci\nstepz: []\n",
+			want:    []Diagnostic{{Line: 2, Column: 1, Rule: RuleSchemaUnknownKey, Message: "Unexpected value 'stepz'"}},
+		},
+		{
+			name:    "unknown key in a nested mapping",
+			content: "pool:\n  vmImage: ubuntu-latest\n  image: ubuntu-latest\n",
+			want:    []Diagnostic{{Line: 3, Column: 3, Rule: RuleSchemaUnknownKey, Message: "Unexpected value 'image'"}},
+		},
+		{
+			name:    "wrong type",
+			content: "pool: ubuntu-latest\n",
+			want:    []Diagnostic{{Line: 1, Column: 7, Rule: RuleSchemaType, Message: "Expected object but found string"}},
+		},
+		{
+			name:    "wrong scalar type",
+			content: "timeout: ten\n",
+			want:    []Diagnostic{{Line: 1, Column: 10, Rule: RuleSchemaType, Message: "Expected integer but found string"}},
+		},
+		{
+			name:    "required key",
+			content: "pool:\n  demands: []\n",
+			want:    []Diagnostic{{Line: 2, Column: 3, Rule: RuleSchemaRequired, Message: "Required property 'vmImage' is missing"}},
+		},
+		{
+			name:    "oneOf alternatives neither matched by first nor by required property",
+			content: "steps:\n- inputs: {}\n",
+			want:    []Diagnostic{{Line: 2, Column: 3, Rule: RuleSchemaUnknownKey, Message: "Unexpected value 'inputs'"}},
+		},
+		{
+			name:    "oneOf alternative picked by the first property",
+			content: "steps:\n- script: echo\n  inputs: {}\n",
+			want:    []Diagnostic{{Line: 3, Column: 3, Rule: RuleSchemaUnknownKey, Message: "Unexpected value 'inputs'"}},
+		},
+		{
+			name:    "oneOf without a matching alternative",
+			content: "steps:\n- bash: echo\n",
+			want:    []Diagnostic{{Line: 2, Column: 3, Rule: RuleSchemaUnknownKey, Message: "Unexpected value 'bash'"}},
+		},
+		{
+			name:    "anyOf without a matching alternative",
+			content: "trigger: main\n",
+			want:    []Diagnostic{{Line: 1, Column: 10, Rule: RuleSchemaValue, Message: "Unexpected value 'main'"}},
+		},
+		{
+			name:    "enum",
+			content: "steps:\n- script: echo\n  condition: failed()\n",
+			want:    []Diagnostic{{Line: 3, Column: 14, Rule: RuleSchemaValue, Message: "Unexpected value 'failed()'"}},
+		},
+		{
+			name:    "missing value",
+			content: "steps:\n- task:\n",
+			want:    []Diagnostic{{Line: 2, Column: 8, Rule: RuleSchemaValue, Message: "A value is required"}},
+		},
+		{
+			name: "template expressions",
+			content: `steps:
+- ${{ if eq(parameters.debug, true) }}:
+  - script: echo
+  - bash: echo
+- ${{ parameters.steps }}
+pool: ${{ parameters.pool }}
+`,
+			want: []Diagnostic{{Line: 4, Column: 5, Rule: RuleSchemaUnknownKey, Message: "Unexpected value 'bash'"}},
+		},
+		{
+			name:    "invalid YAML",
+			content: "steps: [\n",
+			want:    []Diagnostic{{Line: 1, Column: 1, Rule: RuleYamlSyntax, Message: "yaml: line 1: did not find expected node content"}},
+		},
+	}
+
+	schema, err := newSchema([]byte(testSchema))
+	if err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := schema.ValidateFile("/azure-pipelines.yml", []byte(tt.content))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d diagnostics, want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				want.File = "/azure-pipelines.yml"
+				want.Severity = SeverityError
+				if got[i] != want {
+					t.Errorf("diagnostic %d:\ngot  %+v\nwant %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultSchema(t *testing.T) {
+	schema, err := DefaultSchema()
+	if err != nil {
+		t.Fatalf("failed to load the bundled schema: %v", err)
+	}
+
+	valid := `trigger:
+  branches:
+    include:
+    - main
+pool:
+  vmImage: ubuntu-latest
+stages:
+- stage: build
+  jobs:
+  - job: build
+    steps:
+    - checkout: self
+    - task: DotNetCoreCLI@2
+      inputs:
+        command: build
+    - script: echo done
+      displayName: Done
+`
+	if diagnostics := schema.ValidateFile("/azure-pipelines.yml", []byte(valid)); len(diagnostics) != 0 {
+		t.Errorf("got diagnostics for a valid pipeline: %+v", diagnostics)
+	}
+
+	diagnostics := schema.ValidateFile("/azure-pipelines.yml", []byte("steps:\n- task:\n"))
+	if len(diagnostics) != 1 || diagnostics[0].Rule != RuleSchemaValue || diagnostics[0].Severity != SeverityError {
+		t.Errorf("got %+v, want an error for the task without a name", diagnostics)
+	}
+
+	// The bundled schema is a subset of the official one, so an unknown key may be valid
+	diagnostics = schema.ValidateFile("/azure-pipelines.yml", []byte("steps:\n- script: echo\n  someNewKeyword: true\n"))
+	if len(diagnostics) != 1 || diagnostics[0].Rule != RuleSchemaUnknownKey || diagnostics[0].Severity != SeverityWarning {
+		t.Errorf("got %+v, want a warning for the unknown key", diagnostics)
+	}
+}
+
+func TestLoadPartialSchema(t *testing.T) {
+	schemaFile := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(schemaFile, []byte(testSchema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("steps:\n- script: echo\n  someNewKeyword: true\n- task:\n")
+
+	tests := []struct {
+		name        string
+		load        func(string) (*Schema, error)
+		wantUnknown Severity
+	}{
+		{name: "complete", load: LoadSchema, wantUnknown: SeverityError},
+		// A partial schema leaves out valid keywords, so only the unknown key is downgraded
+		{name: "partial", load: LoadPartialSchema, wantUnknown: SeverityWarning},
+	}
+	for _, tt := range tests {
+		schema, err := tt.load(schemaFile)
+		if err != nil {
+			t.Fatalf("%s: failed to load the schema: %v", tt.name, err)
+		}
+
+		severities := make(map[string]Severity)
+		for _, d := range schema.ValidateFile("/azure-pipelines.yml", content) {
+			severities[d.Rule] = d.Severity
+		}
+		want := map[string]Severity{RuleSchemaUnknownKey: tt.wantUnknown, RuleSchemaValue: SeverityError}
+		if !reflect.DeepEqual(severities, want) {
+			t.Errorf("%s: got severities %v, want %v", tt.name, severities, want)
+		}
+	}
+}
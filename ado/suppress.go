@@ -0,0 +1,328 @@
+package ado
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultBaselineFileName is the name of the baseline file used when none is configured
+const DefaultBaselineFileName = ".ado-yaml-validator-baseline.yaml"
+
+// ignoreComment matches comments suppressing diagnostics, for example "# ado-yaml-validator: ignore schema-type".
+// Without rules, all diagnostics are suppressed.
+var ignoreComment = regexp.MustCompile(`^#\s*ado-yaml-validator:\s*ignore\b([^#]*)`)
+
+// sourceLines reads the lines of the files diagnostics are reported in, caching each file
+type sourceLines struct {
+	sources fs.FS
+	files   map[string]*sourceFile
+}
+
+// sourceFile holds the lines of a file and the lines of its YAML comments
+type sourceFile struct {
+	lines []string
+	// comments are the lines of the comments the YAML parser found, so a # inside a quoted or block scalar is not taken
+	// for a comment, unless the same text is also a comment in the file. It is empty if the file is not valid YAML.
+	comments map[string]bool
+}
+
+func newSourceLines(sources fs.FS) *sourceLines {
+	return &sourceLines{
+		sources: sources,
+		files:   make(map[string]*sourceFile),
+	}
+}
+
+// file returns a file of the sources, or nil if it is not in the sources
+func (s *sourceLines) file(name string) *sourceFile {
+	if s.sources == nil {
+		return nil
+	}
+
+	name = normalizePipelinePath(name)
+	file, ok := s.files[name]
+	if !ok {
+		// Files of other repositories, such as template@repository, are not in the sources
+		content, err := fs.ReadFile(s.sources, strings.TrimPrefix(name, "/"))
+		if err == nil {
+			file = &sourceFile{
+				lines:    strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n"),
+				comments: yamlComments(content),
+			}
+		}
+		s.files[name] = file
+	}
+
+	return file
+}
+
+// line returns the line with the given 1-based number of a file, if the file is in the sources
+func (s *sourceLines) line(file string, number int) (string, bool) {
+	f := s.file(file)
+	if f == nil || number <= 0 || number > len(f.lines) {
+		return "", false
+	}
+
+	return f.lines[number-1], true
+}
+
+// comment returns the comment at the end of the line with the given 1-based number of a file, if it has one
+func (s *sourceLines) comment(file string, number int) (string, bool) {
+	text, ok := s.line(file, number)
+	if !ok {
+		return "", false
+	}
+
+	comments := s.file(file).comments
+	for i := 0; i < len(text); i++ {
+		// Comments start at the beginning of the line or after whitespace
+		if text[i] != '#' || (i > 0 && text[i-1] != ' ' && text[i-1] != '\t') {
+			continue
+		}
+		if comment := strings.TrimRight(text[i:], " \t"); comments[comment] {
+			return comment, true
+		}
+	}
+
+	return "", false
+}
+
+// yamlComments returns the lines of all comments in a YAML file
+func yamlComments(content []byte) map[string]bool {
+	comments := make(map[string]bool)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return comments
+	}
+
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		for _, comment := range []string{node.HeadComment, node.LineComment, node.FootComment} {
+			for _, line := range strings.Split(comment, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					comments[line] = true
+				}
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(&doc)
+
+	return comments
+}
+
+// fingerprint identifies a diagnostic by its file, rule, message and the content of its line, so it stays the same when
+// the line moves but changes when the line does
+func (s *sourceLines) fingerprint(d Diagnostic) string {
+	text, _ := s.line(d.File, d.Line)
+
+	hash := sha256.New()
+	for _, part := range []string{normalizePipelinePath(d.File), d.Rule, d.Message, strings.TrimSpace(text)} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+// ignored checks whether the diagnostic's line, or the line above it, has an ignore comment for its rule
+func (s *sourceLines) ignored(d Diagnostic) bool {
+	for _, number := range []int{d.Line, d.Line - 1} {
+		comment, ok := s.comment(d.File, number)
+		if !ok {
+			continue
+		}
+		match := ignoreComment.FindStringSubmatch(comment)
+		if match == nil {
+			continue
+		}
+
+		rules := strings.FieldsFunc(match[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(rules) == 0 {
+			return true
+		}
+		for _, rule := range rules {
+			if rule == d.Rule {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// annotateDiagnostics sets the fingerprints of all diagnostics from the lines they are reported on and suppresses the
+// diagnostics that have an inline ignore comment. sources holds the files by repository path and may be nil, in which
+// case fingerprints do not include line contents and no comments are honored.
+func (r *Report) annotateDiagnostics(sources fs.FS) {
+	lines := newSourceLines(sources)
+
+	for i := range r.Results {
+		result := &r.Results[i]
+		if result.Diagnostics == nil {
+			continue
+		}
+
+		diagnostics := make([]Diagnostic, 0, len(result.Diagnostics))
+		for _, d := range result.Diagnostics {
+			d.Fingerprint = lines.fingerprint(d)
+			if lines.ignored(d) {
+				result.Suppressed = append(result.Suppressed, d)
+				continue
+			}
+			diagnostics = append(diagnostics, d)
+		}
+		result.Diagnostics = diagnostics
+		result.updateStatus()
+	}
+}
+
+// BaselineFile lists known diagnostics that are not reported until they change, so strict validation can be adopted
+// incrementally. It is generated with the baseline command.
+type BaselineFile struct {
+	Findings []BaselineFinding `yaml:"findings"`
+}
+
+// BaselineFinding is a known diagnostic of a pipeline
+type BaselineFinding struct {
+	// Pipeline is the root file path of the pipeline the diagnostic was reported for
+	Pipeline string `yaml:"pipeline"`
+	// File is the file the diagnostic is in
+	File string `yaml:"file"`
+	Rule string `yaml:"rule,omitempty"`
+	// Fingerprint identifies the diagnostic, see Diagnostic.Fingerprint
+	Fingerprint string `yaml:"fingerprint"`
+	// Message is the diagnostic's message at the time the baseline was generated, for reference only
+	Message string `yaml:"message,omitempty"`
+}
+
+// key identifies the finding when matching it with diagnostics
+func (f BaselineFinding) key() string {
+	return normalizePipelinePath(f.Pipeline) + "\x00" + normalizePipelinePath(f.File) + "\x00" + f.Fingerprint
+}
+
+// LoadBaselineFile reads a baseline file
+func LoadBaselineFile(file string) (*BaselineFile, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("LoadBaselineFile: failed to read %s: %w", file, err)
+	}
+
+	baseline := &BaselineFile{}
+	if err := yaml.Unmarshal(content, baseline); err != nil {
+		return nil, fmt.Errorf("LoadBaselineFile: failed to parse %s: %w", file, err)
+	}
+	for i, finding := range baseline.Findings {
+		if finding.Pipeline == "" || finding.Fingerprint == "" {
+			return nil, fmt.Errorf("LoadBaselineFile: finding %d in %s needs a pipeline and a fingerprint", i, file)
+		}
+	}
+
+	return baseline, nil
+}
+
+// NewBaselineFile creates a baseline of the diagnostics in the report. Findings of previous for pipelines that are not
+// in the report are kept, so a baseline can be updated one pipeline at a time. previous may be nil.
+func NewBaselineFile(report *Report, previous *BaselineFile) *BaselineFile {
+	validated := make(map[string]bool, len(report.Results))
+	for _, result := range report.Results {
+		validated[normalizePipelinePath(result.Path)] = true
+	}
+
+	baseline := &BaselineFile{
+		Findings: make([]BaselineFinding, 0),
+	}
+	seen := make(map[string]bool)
+	add := func(finding BaselineFinding) {
+		if !seen[finding.key()] {
+			seen[finding.key()] = true
+			baseline.Findings = append(baseline.Findings, finding)
+		}
+	}
+
+	if previous != nil {
+		for _, finding := range previous.Findings {
+			if !validated[normalizePipelinePath(finding.Pipeline)] {
+				add(finding)
+			}
+		}
+	}
+	for _, result := range report.Results {
+		for _, d := range result.Diagnostics {
+			add(BaselineFinding{
+				Pipeline:    result.Path,
+				File:        d.File,
+				Rule:        d.Rule,
+				Fingerprint: d.Fingerprint,
+				Message:     d.Message,
+			})
+		}
+	}
+
+	sort.SliceStable(baseline.Findings, func(i, j int) bool {
+		a, b := baseline.Findings[i], baseline.Findings[j]
+		if a.Pipeline != b.Pipeline {
+			return a.Pipeline < b.Pipeline
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+
+	return baseline
+}
+
+// Save writes the baseline file
+func (b *BaselineFile) Save(file string) error {
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(b); err != nil {
+		return fmt.Errorf("Save: failed to serialize baseline: %w", err)
+	}
+	if err := os.WriteFile(file, content.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("Save: failed to write %s: %w", file, err)
+	}
+
+	return nil
+}
+
+// ApplyBaselineFile suppresses the diagnostics listed in the baseline file and updates the pipeline statuses
+// accordingly
+func (r *Report) ApplyBaselineFile(baseline *BaselineFile) {
+	known := make(map[string]bool, len(baseline.Findings))
+	for _, finding := range baseline.Findings {
+		known[finding.key()] = true
+	}
+
+	for i := range r.Results {
+		result := &r.Results[i]
+		if result.Diagnostics == nil {
+			continue
+		}
+
+		diagnostics := make([]Diagnostic, 0, len(result.Diagnostics))
+		for _, d := range result.Diagnostics {
+			finding := BaselineFinding{Pipeline: result.Path, File: d.File, Fingerprint: d.Fingerprint}
+			if d.Fingerprint != "" && known[finding.key()] {
+				result.Suppressed = append(result.Suppressed, d)
+				continue
+			}
+			diagnostics = append(diagnostics, d)
+		}
+		result.Diagnostics = diagnostics
+		result.updateStatus()
+	}
+}
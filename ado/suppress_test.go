@@ -0,0 +1,190 @@
+package ado
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestIgnoreComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		rule    string
+		want    bool
+	}{
+		{
+			name:    "same line",
+			content: "steps:\n- script: echo # ado-yaml-validator: ignore\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    true,
+		},
+		{
+			name:    "line above",
+			content: "steps:\n# ado-yaml-validator: ignore\n- script: echo\n",
+			line:    3,
+			rule:    RuleSchemaType,
+			want:    true,
+		},
+		{
+			name:    "two lines above",
+			content: "# ado-yaml-validator: ignore\nsteps:\n- script: echo\n",
+			line:    3,
+			rule:    RuleSchemaType,
+			want:    false,
+		},
+		{
+			name:    "line below",
+			content: "steps:\n- script: echo\n# ado-yaml-validator: ignore\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    false,
+		},
+		{
+			name:    "rule in the list",
+			content: "steps:\n- script: echo # ado-yaml-validator: ignore schema-value, schema-type\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    true,
+		},
+		{
+			name:    "rule not in the list",
+			content: "steps:\n- script: echo # ado-yaml-validator: ignore schema-value schema-required # reason\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    false,
+		},
+		{
+			name:    "reason after the rules",
+			content: "steps:\n- script: echo # ado-yaml-validator: ignore schema-type # accepted for now\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    true,
+		},
+		{
+			name:    "double quoted scalar",
+			content: "steps:\n- script: \"echo # ado-yaml-validator: ignore\"\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    false,
+		},
+		{
+			name:    "single quoted scalar",
+			content: "steps:\n- script: 'echo # ado-yaml-validator: ignore'\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    false,
+		},
+		{
+			name:    "block scalar",
+			content: "steps:\n- script: |\n    # ado-yaml-validator: ignore\n    echo\n",
+			line:    4,
+			rule:    RuleSchemaType,
+			want:    false,
+		},
+		{
+			name:    "comment after a quoted scalar",
+			content: "steps:\n- script: \"echo #1\" # ado-yaml-validator: ignore\n",
+			line:    2,
+			rule:    RuleSchemaType,
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := fstest.MapFS{"ci.yml": {Data: []byte(tt.content)}}
+			report := &Report{Results: []PipelineReport{{
+				Path:        "/ci.yml",
+				Diagnostics: []Diagnostic{{File: "/ci.yml", Line: tt.line, Message: "unexpected type", Severity: SeverityError, Rule: tt.rule}},
+			}}}
+
+			report.annotateDiagnostics(sources)
+
+			result := report.Results[0]
+			if got := len(result.Suppressed) == 1; got != tt.want {
+				t.Errorf("got suppressed %v, want %v", got, tt.want)
+			}
+			if tt.want && (len(result.Diagnostics) != 0 || result.Status != StatusPassed) {
+				t.Errorf("got %d diagnostics and status %s, want none and passed", len(result.Diagnostics), result.Status)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	// fingerprint computes the fingerprint of a diagnostic in a version of the same file
+	fingerprint := func(content string, line int, message string) string {
+		lines := newSourceLines(fstest.MapFS{"ci.yml": {Data: []byte(content)}})
+		return lines.fingerprint(Diagnostic{File: "/ci.yml", Line: line, Message: message, Rule: RulePreviewUnexpectedValue})
+	}
+
+	before := fingerprint("steps:\n- script: echo\n  foo: bar\n", 3, "Unexpected value 'foo'")
+	tests := []struct {
+		name    string
+		content string
+		line    int
+		message string
+		same    bool
+	}{
+		{name: "same line", content: "steps:\n- script: echo\n  foo: bar\n", line: 3, message: "Unexpected value 'foo'", same: true},
+		{name: "moved line", content: "trigger: none\n\nsteps:\n- script: echo\n  foo: bar\n", line: 5, message: "Unexpected value 'foo'", same: true},
+		{name: "reindented line", content: "steps:\n  - script: echo\n    foo: bar\n", line: 3, message: "Unexpected value 'foo'", same: true},
+		{name: "changed line", content: "steps:\n- script: echo\n  foo: baz\n", line: 3, message: "Unexpected value 'foo'", same: false},
+		{name: "changed message", content: "steps:\n- script: echo\n  foo: bar\n", line: 3, message: "Unexpected value 'bar'", same: false},
+	}
+
+	for _, tt := range tests {
+		if got := fingerprint(tt.content, tt.line, tt.message) == before; got != tt.same {
+			t.Errorf("%s: got same fingerprint %v, want %v", tt.name, got, tt.same)
+		}
+	}
+}
+
+func TestBaselineFile(t *testing.T) {
+	sources := fstest.MapFS{
+		"a.yml": {Data: []byte("steps:\n- script: echo\n  foo: bar\n")},
+		"b.yml": {Data: []byte("steps:\n- bash: echo\n  foo: bar\n")},
+	}
+	diagnostic := func(file string, message string) Diagnostic {
+		return Diagnostic{File: file, Line: 3, Message: message, Severity: SeverityError, Rule: RulePreviewUnexpectedValue}
+	}
+	newReport := func(results ...PipelineReport) *Report {
+		report := &Report{Results: results}
+		report.annotateDiagnostics(sources)
+		return report
+	}
+
+	first := newReport(
+		PipelineReport{Path: "/a.yml", Diagnostics: []Diagnostic{diagnostic("/a.yml", "Unexpected value 'foo'")}},
+		PipelineReport{Path: "/b.yml", Diagnostics: []Diagnostic{diagnostic("/b.yml", "Unexpected value 'foo'")}},
+	)
+	baseline := NewBaselineFile(first, nil)
+	if len(baseline.Findings) != 2 {
+		t.Fatalf("got %d findings, want 2", len(baseline.Findings))
+	}
+
+	// Only /a.yml is validated again, with its finding fixed and a new one
+	second := newReport(PipelineReport{Path: "/a.yml", Diagnostics: []Diagnostic{diagnostic("/a.yml", "Unexpected value 'bar'")}})
+	updated := NewBaselineFile(second, baseline)
+	messages := make(map[string]string)
+	for _, finding := range updated.Findings {
+		messages[finding.Pipeline] += finding.Message
+	}
+	if len(updated.Findings) != 2 || messages["/a.yml"] != "Unexpected value 'bar'" || messages["/b.yml"] != "Unexpected value 'foo'" {
+		t.Errorf("got findings %+v, want the new finding of /a.yml and the previous one of /b.yml", updated.Findings)
+	}
+
+	third := newReport(
+		PipelineReport{Path: "/a.yml", Diagnostics: []Diagnostic{diagnostic("/a.yml", "Unexpected value 'foo'")}},
+		PipelineReport{Path: "/b.yml", Diagnostics: []Diagnostic{diagnostic("/b.yml", "Unexpected value 'foo'")}},
+	)
+	third.ApplyBaselineFile(updated)
+	if got := third.Results[0]; len(got.Diagnostics) != 1 || got.Status != StatusFailed {
+		t.Errorf("got %d diagnostics and status %s for /a.yml, want its fixed finding reported again", len(got.Diagnostics), got.Status)
+	}
+	if got := third.Results[1]; len(got.Suppressed) != 1 || got.Status != StatusPassed {
+		t.Errorf("got %d suppressed diagnostics and status %s for /b.yml, want its known finding suppressed", len(got.Suppressed), got.Status)
+	}
+}
@@ -0,0 +1,246 @@
+package ado
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+)
+
+// selfRepositoryAlias is the repository alias Azure Pipelines uses for the repository containing the pipeline
+const selfRepositoryAlias = "self"
+
+// templateReference is a single `template:` reference found in a pipeline or template file
+type templateReference struct {
+	// Path is the template path as written, without the repository alias
+	Path string
+	// RepositoryAlias is the alias after the @ sign, or empty if the template is in the same repository
+	RepositoryAlias string
+}
+
+// pipelineFile is the result of parsing a pipeline or template file for its template references
+type pipelineFile struct {
+	references []templateReference
+	// repositories maps repository resource aliases to the repository names declared in resources.repositories.
+	// Only Azure Repos git repositories are included.
+	repositories map[string]string
+}
+
+// parsePipelineFile parses the given YAML content and collects all template references in it. References can appear
+// in steps, jobs, stages, variables and extends, including inside conditional and each expressions, so the whole
+// document is walked.
+func parsePipelineFile(content []byte) (pipelineFile, error) {
+	result := pipelineFile{
+		repositories: make(map[string]string),
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return result, fmt.Errorf("parsePipelineFile: failed to parse YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return result, nil
+	}
+	root := doc.Content[0]
+
+	walkYamlNodes(root, func(key *yaml.Node, value *yaml.Node) {
+		if key.Value != "template" || value.Kind != yaml.ScalarNode {
+			return
+		}
+		// Template paths built from expressions can't be resolved without evaluating the pipeline
+		if strings.Contains(value.Value, "$") {
+			return
+		}
+		result.references = append(result.references, parseTemplateReference(value.Value))
+	})
+
+	repositories := mappingValue(mappingValue(root, "resources"), "repositories")
+	if repositories != nil && repositories.Kind == yaml.SequenceNode {
+		for _, repo := range repositories.Content {
+			alias := mappingValue(repo, "repository")
+			name := mappingValue(repo, "name")
+			repoType := mappingValue(repo, "type")
+			if alias == nil || name == nil || repoType == nil || repoType.Value != "git" {
+				continue
+			}
+			result.repositories[alias.Value] = name.Value
+		}
+	}
+
+	return result, nil
+}
+
+// parseTemplateReference splits a template reference of the form path[@alias]
+func parseTemplateReference(value string) templateReference {
+	value = strings.TrimSpace(value)
+	if i := strings.LastIndex(value, "@"); i >= 0 {
+		return templateReference{Path: value[:i], RepositoryAlias: value[i+1:]}
+	}
+
+	return templateReference{Path: value}
+}
+
+// walkYamlNodes calls fn for every key value pair in every mapping in the given node tree
+func walkYamlNodes(node *yaml.Node, fn func(key *yaml.Node, value *yaml.Node)) {
+	if node == nil {
+		return
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			walkYamlNodes(child, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			fn(node.Content[i], node.Content[i+1])
+			walkYamlNodes(node.Content[i+1], fn)
+		}
+	case yaml.AliasNode:
+		walkYamlNodes(node.Alias, fn)
+	}
+}
+
+// mappingValue returns the value for the given key in a mapping node, or nil if the node is not a mapping or the key
+// is not present
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// resolveTemplatePath resolves a template path relative to the file that references it. Paths starting with a slash
+// are relative to the repository root. The result always starts with a slash.
+func resolveTemplatePath(includingFile string, templatePath string) string {
+	if strings.HasPrefix(templatePath, "/") {
+		return path.Clean(templatePath)
+	}
+
+	return path.Join(path.Dir(normalizePipelinePath(includingFile)), templatePath)
+}
+
+// normalizePipelinePath returns the given repository path with a single leading slash
+func normalizePipelinePath(p string) string {
+	return path.Clean("/" + strings.TrimLeft(p, "/"))
+}
+
+// pipelinePathKey returns the key paths are compared by. Azure Repos treats pipeline paths case-insensitively, so
+// differently cased paths refer to the same file.
+func pipelinePathKey(p string) string {
+	return strings.ToLower(normalizePipelinePath(p))
+}
+
+// isRepositoryName checks whether a repository name from a repository resource refers to the given repository. The
+// resource name is either "repo" or "project/repo".
+func isRepositoryName(resourceName string, project string, repositoryName string) bool {
+	if repositoryName == "" {
+		return false
+	}
+
+	resourceProject, resourceRepo, found := strings.Cut(resourceName, "/")
+	if !found {
+		return strings.EqualFold(resourceName, repositoryName)
+	}
+
+	return strings.EqualFold(resourceProject, project) && strings.EqualFold(resourceRepo, repositoryName)
+}
+
+// templateGraph holds the files each pipeline depends on, including the pipeline's own root file and all transitively
+// referenced templates in the local repository
+type templateGraph struct {
+	dependencies map[int]map[string]bool
+}
+
+// buildTemplateGraph reads every pipeline's root file from the given file system and follows its template references.
+// Templates referenced through a repository alias are only followed when the alias points to the repository in the
+// file system. Pipelines whose root file can't be read, for example because it lives in another repository, only
+// depend on their root file.
+func buildTemplateGraph(sources fs.FS, project string, repositoryName string, pipes []Pipeline) templateGraph {
+	graph := templateGraph{
+		dependencies: make(map[int]map[string]bool, len(pipes)),
+	}
+
+	// Templates are shared between many pipelines, so each file is only parsed once
+	parsed := make(map[string]*pipelineFile)
+	readFile := func(file string) *pipelineFile {
+		if p, ok := parsed[file]; ok {
+			return p
+		}
+
+		var result *pipelineFile
+		content, err := fs.ReadFile(sources, strings.TrimPrefix(file, "/"))
+		if err == nil {
+			p, parseErr := parsePipelineFile(content)
+			if parseErr != nil {
+				log.Printf("buildTemplateGraph: skipping %s: %v", file, parseErr)
+			} else {
+				result = &p
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("buildTemplateGraph: failed to read %s: %v", file, err)
+		}
+
+		parsed[file] = result
+		return result
+	}
+
+	for _, pipeline := range pipes {
+		rootFile := normalizePipelinePath(pipeline.FilePath)
+		deps := map[string]bool{rootFile: true}
+		graph.dependencies[pipeline.Id] = deps
+
+		root := readFile(rootFile)
+		if root == nil {
+			continue
+		}
+
+		queue := []string{rootFile}
+		for len(queue) > 0 {
+			file := queue[0]
+			queue = queue[1:]
+
+			current := readFile(file)
+			if current == nil {
+				continue
+			}
+
+			for _, ref := range current.references {
+				// Aliases are always declared in the root pipeline's resources
+				if ref.RepositoryAlias != "" && ref.RepositoryAlias != selfRepositoryAlias &&
+					!isRepositoryName(root.repositories[ref.RepositoryAlias], project, repositoryName) {
+					continue
+				}
+
+				templateFile := resolveTemplatePath(file, ref.Path)
+				if !deps[templateFile] {
+					deps[templateFile] = true
+					queue = append(queue, templateFile)
+				}
+			}
+		}
+	}
+
+	return graph
+}
+
+// dependsOnAny checks whether the pipeline with the given ID depends on any of the given files, keyed by pipelinePathKey
+func (g templateGraph) dependsOnAny(pipelineId int, files map[string]bool) bool {
+	for file := range g.dependencies[pipelineId] {
+		if files[pipelinePathKey(file)] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,154 @@
+package ado
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+func TestResolveTemplatePath(t *testing.T) {
+	tests := []struct {
+		includingFile string
+		templatePath  string
+		want          string
+	}{
+		{includingFile: "/pipelines/ci.yml", templatePath: "templates/build.yml", want: "/pipelines/templates/build.yml"},
+		{includingFile: "pipelines/ci.yml", templatePath: "../templates/build.yml", want: "/templates/build.yml"},
+		{includingFile: "/pipelines/ci.yml", templatePath: "/templates/build.yml", want: "/templates/build.yml"},
+		{includingFile: "/pipelines/ci.yml", templatePath: "/templates/../shared/build.yml", want: "/shared/build.yml"},
+		{includingFile: "/azure-pipelines.yml", templatePath: "./build.yml", want: "/build.yml"},
+	}
+
+	for _, tt := range tests {
+		if got := resolveTemplatePath(tt.includingFile, tt.templatePath); got != tt.want {
+			t.Errorf("resolveTemplatePath(%q, %q) = %q, want %q", tt.includingFile, tt.templatePath, got, tt.want)
+		}
+	}
+}
+
+func TestIsRepositoryName(t *testing.T) {
+	tests := []struct {
+		resourceName string
+		want         bool
+	}{
+		{resourceName: "repo", want: true},
+		{resourceName: "Repo", want: true},
+		{resourceName: "project/repo", want: true},
+		{resourceName: "Project/REPO", want: true},
+		{resourceName: "other/repo", want: false},
+		{resourceName: "templates", want: false},
+		{resourceName: "", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isRepositoryName(tt.resourceName, "project", "repo"); got != tt.want {
+			t.Errorf("isRepositoryName(%q) = %v, want %v", tt.resourceName, got, tt.want)
+		}
+	}
+}
+
+func TestBuildTemplateGraph(t *testing.T) {
+	sources := fstest.MapFS{
+		"pipelines/relative.yml": {Data: []byte("steps:\n- template: ../templates/steps.yml\n")},
+		"pipelines/anchored.yml": {Data: []byte("jobs:\n- template: /templates/jobs.yml\n")},
+		"templates/steps.yml":    {Data: []byte("steps:\n- script: echo\n")},
+		"templates/jobs.yml":     {Data: []byte("jobs:\n- job: build\n  steps:\n  - template: steps.yml\n")},
+		"pipelines/repositories.yml": {Data: []byte(`resources:
+  repositories:
+  - repository: shared
+    type: git
+    name: project/shared
+  - repository: same
+    type: git
+    name: project/repo
+  - repository: github
+    type: github
+    name: owner/repo
+steps:
+- template: /templates/self.yml@self
+- template: /templates/shared.yml@shared
+- template: /templates/same.yml@same
+- template: /templates/github.yml@github
+`)},
+		"templates/self.yml":  {Data: []byte("steps: []\n")},
+		"templates/same.yml":  {Data: []byte("steps: []\n")},
+		"pipelines/cycle.yml": {Data: []byte("steps:\n- template: /templates/a.yml\n")},
+		"templates/a.yml":     {Data: []byte("steps:\n- template: b.yml\n")},
+		"templates/b.yml":     {Data: []byte("steps:\n- template: a.yml\n")},
+		"pipelines/missing.yml": {Data: []byte(`steps:
+- template: /templates/missing.yml
+- template: /templates/${{ parameters.name }}.yml
+`)},
+		"pipelines/invalid.yml": {Data: []byte("steps: [\n")},
+	}
+
+	tests := []struct {
+		name     string
+		filePath string
+		want     []string
+	}{
+		{
+			name:     "relative path",
+			filePath: "/pipelines/relative.yml",
+			want:     []string{"/pipelines/relative.yml", "/templates/steps.yml"},
+		},
+		{
+			name:     "root-anchored path with a nested relative template",
+			filePath: "pipelines/anchored.yml",
+			want:     []string{"/pipelines/anchored.yml", "/templates/jobs.yml", "/templates/steps.yml"},
+		},
+		{
+			name:     "self and aliases of the same repository are followed, other repositories are not",
+			filePath: "/pipelines/repositories.yml",
+			want:     []string{"/pipelines/repositories.yml", "/templates/same.yml", "/templates/self.yml"},
+		},
+		{
+			name:     "cycle",
+			filePath: "/pipelines/cycle.yml",
+			want:     []string{"/pipelines/cycle.yml", "/templates/a.yml", "/templates/b.yml"},
+		},
+		{
+			name:     "missing template and expression paths",
+			filePath: "/pipelines/missing.yml",
+			want:     []string{"/pipelines/missing.yml", "/templates/missing.yml"},
+		},
+		{
+			name:     "missing root file",
+			filePath: "/pipelines/other-repository.yml",
+			want:     []string{"/pipelines/other-repository.yml"},
+		},
+		{
+			name:     "invalid YAML",
+			filePath: "/pipelines/invalid.yml",
+			want:     []string{"/pipelines/invalid.yml"},
+		},
+	}
+
+	pipes := make([]Pipeline, 0, len(tests))
+	for i, tt := range tests {
+		pipes = append(pipes, Pipeline{Id: i + 1, FilePath: tt.filePath})
+	}
+	graph := buildTemplateGraph(sources, "project", "repo", pipes)
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for file := range graph.dependencies[i+1] {
+				got = append(got, file)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got dependencies %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	changed := map[string]bool{pipelinePathKey("/Templates/Steps.yml"): true}
+	if !graph.dependsOnAny(2, changed) {
+		t.Errorf("the pipeline using /templates/steps.yml through /templates/jobs.yml does not depend on it")
+	}
+	if graph.dependsOnAny(4, changed) {
+		t.Errorf("the cycle pipeline depends on /templates/steps.yml")
+	}
+}
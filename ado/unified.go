@@ -0,0 +1,184 @@
+package ado
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change in a unified diff
+const diffContextLines = 3
+
+// maxDiffEdits bounds the work of the line diff. Texts that differ in more lines are shown as entirely replaced.
+const maxDiffEdits = 2000
+
+// diffLine is a line of a unified diff, with the kind of change as its prefix: ' ' unchanged, '-' removed or '+' added
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// unifiedDiff returns the unified diff turning from into to, with the given names in the file headers. It is empty if
+// the texts have the same lines.
+func unifiedDiff(fromName string, toName string, from string, to string) string {
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	changes := make([]int, 0)
+	for i, line := range lines {
+		if line.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	// Line numbers of both texts before each diff line
+	fromLine := make([]int, len(lines)+1)
+	toLine := make([]int, len(lines)+1)
+	for i, line := range lines {
+		fromLine[i+1], toLine[i+1] = fromLine[i], toLine[i]
+		if line.kind != '+' {
+			fromLine[i+1]++
+		}
+		if line.kind != '-' {
+			toLine[i+1]++
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(changes); {
+		start := changes[i] - diffContextLines
+		if start < 0 {
+			start = 0
+		}
+		end := changes[i] + 1 + diffContextLines
+		// Changes close enough to share context lines are shown in the same hunk
+		for i++; i < len(changes) && changes[i]-diffContextLines <= end; i++ {
+			end = changes[i] + 1 + diffContextLines
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(fromLine[start], fromLine[end]), hunkRange(toLine[start], toLine[end]))
+		for _, line := range lines[start:end] {
+			out.WriteByte(line.kind)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the lines from start to end of one side of a hunk. Empty ranges are given by the line before them.
+func hunkRange(start int, end int) string {
+	if end == start {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// splitLines splits a text into lines, without a trailing empty line for a final newline
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines returns a shortest edit script turning a into b as diff lines, using Myers' algorithm
+func diffLines(a []string, b []string) []diffLine {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace keeps the furthest reaching x of the diagonals -d-1 to d+1 before each round d, for backtracking
+	trace := make([][]int, 0)
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			return replaceLines(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrackLines(a, b, trace)
+			}
+		}
+	}
+
+	// Not reached, the loop ends at d = n+m at the latest
+	return replaceLines(a, b)
+}
+
+// backtrackLines follows the trace of diffLines back from the end of both texts to build the edit script
+func backtrackLines(a []string, b []string, trace [][]int) []diffLine {
+	lines := make([]diffLine, 0, len(a)+len(b))
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, diffLine{kind: ' ', text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, diffLine{kind: '+', text: b[y-1]})
+			} else {
+				lines = append(lines, diffLine{kind: '-', text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines
+}
+
+// replaceLines returns the edit script removing all lines of a and adding all lines of b
+func replaceLines(a []string, b []string) []diffLine {
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, text := range a {
+		lines = append(lines, diffLine{kind: '-', text: text})
+	}
+	for _, text := range b {
+		lines = append(lines, diffLine{kind: '+', text: text})
+	}
+
+	return lines
+}
@@ -0,0 +1,186 @@
+package ado
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns a text with the lines l1 to ln, replacing the lines in changed with upper case ones
+func numberedLines(n int, changed ...int) string {
+	var sb strings.Builder
+	for i := 1; i <= n; i++ {
+		line := fmt.Sprintf("l%d", i)
+		for _, c := range changed {
+			if c == i {
+				line = strings.ToUpper(line)
+			}
+		}
+		sb.WriteString(line + "\n")
+	}
+
+	return sb.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want string
+	}{
+		{
+			name: "same text",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "only the trailing newline differs",
+			from: "a\nb",
+			to:   "a\nb\n",
+			want: "",
+		},
+		{
+			name: "added to an empty file",
+			from: "",
+			to:   "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "all lines removed",
+			from: "a\nb\n",
+			to:   "",
+			want: "--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "change with context",
+			from: numberedLines(10),
+			to:   numberedLines(10, 5),
+			want: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n l2\n l3\n l4\n-l5\n+L5\n l6\n l7\n l8\n",
+		},
+		{
+			name: "insertion at the start",
+			from: "b\nc\n",
+			to:   "a\nb\nc\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,3 @@\n+a\n b\n c\n",
+		},
+		{
+			name: "changes sharing context are merged",
+			from: numberedLines(12),
+			to:   numberedLines(12, 3, 9),
+			want: "--- old\n+++ new\n@@ -1,12 +1,12 @@\n l1\n l2\n-l3\n+L3\n l4\n l5\n l6\n l7\n l8\n-l9\n+L9\n l10\n l11\n l12\n",
+		},
+		{
+			name: "distant changes are separate hunks",
+			from: numberedLines(14),
+			to:   numberedLines(14, 2, 11),
+			want: "--- old\n+++ new\n@@ -1,5 +1,5 @@\n l1\n-l2\n+L2\n l3\n l4\n l5\n@@ -8,7 +8,7 @@\n l8\n l9\n l10\n-l11\n+L11\n l12\n l13\n l14\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", tt.from, tt.to); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 200; i++ {
+		a, b := randomLines(), randomLines()
+		lines := diffLines(a, b)
+
+		var gotA, gotB []string
+		edits := 0
+		for _, line := range lines {
+			if line.kind != '+' {
+				gotA = append(gotA, line.text)
+			}
+			if line.kind != '-' {
+				gotB = append(gotB, line.text)
+			}
+			if line.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(gotA, ",") != strings.Join(a, ",") || strings.Join(gotB, ",") != strings.Join(b, ",") {
+			t.Fatalf("diffLines(%v, %v) = %v does not turn one into the other", a, b, lines)
+		}
+		if want := len(a) + len(b) - 2*longestCommonSubsequence(a, b); edits != want {
+			t.Fatalf("diffLines(%v, %v) has %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func longestCommonSubsequence(a []string, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] > lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	return lengths[0][0]
+}
+
+func TestDiffLinesMaxEdits(t *testing.T) {
+	distinctLines := func(prefix string, n int) []string {
+		lines := []string{"same"}
+		for i := 0; i < n; i++ {
+			lines = append(lines, fmt.Sprintf("%s%d", prefix, i))
+		}
+		return lines
+	}
+
+	tests := []struct {
+		name          string
+		changed       int
+		wantUnchanged int
+	}{
+		{name: "within the limit", changed: maxDiffEdits / 2, wantUnchanged: 1},
+		{name: "above the limit", changed: maxDiffEdits/2 + 1, wantUnchanged: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := diffLines(distinctLines("a", tt.changed), distinctLines("b", tt.changed))
+
+			if len(lines) != 2*tt.changed+2-tt.wantUnchanged {
+				t.Errorf("got %d diff lines, want %d", len(lines), 2*tt.changed+2-tt.wantUnchanged)
+			}
+			unchanged := 0
+			for _, line := range lines {
+				if line.kind == ' ' {
+					unchanged++
+				}
+			}
+			// Above the limit the texts are shown as entirely replaced, even the line they have in common
+			if unchanged != tt.wantUnchanged {
+				t.Errorf("got %d unchanged lines, want %d", unchanged, tt.wantUnchanged)
+			}
+		})
+	}
+}
@@ -1,34 +1,57 @@
 package ado
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/microsoft/azure-devops-go-api/azuredevops"
 	"github.com/microsoft/azure-devops-go-api/azuredevops/pipelines"
+	"io/fs"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// DefaultPageSize is the number of items requested per page from list APIs
+const DefaultPageSize = 1000
+
 type ValidationClient struct {
 	environment    *AzureDevOpsEnvironment
 	pipelineClient *pipelines.ClientImpl
 	targetBranch   string
+	pageSize       int
+	concurrency    int
+	// pipelineFolder limits pipeline discovery to the pipelines under this folder
+	pipelineFolder  string
+	fileFilter      FileFilter
+	templateHosts   []TemplateHost
+	pipelineConfigs []PipelineConfig
+	previewValues   previewValues
+	matrix          *MatrixOptions
+	// baseline also validates the pipelines on the target branch to tell new diagnostics from existing ones
+	baseline bool
 }
 
-func NewValidationClient(ctx context.Context, environment *AzureDevOpsEnvironment, opts ...ValidationClientOpt) *ValidationClient {
+func NewValidationClient(ctx context.Context, environment *AzureDevOpsEnvironment, opts ...ValidationClientOpt) (*ValidationClient, error) {
 	pClient := environment.connection.GetClientByUrl(environment.connection.BaseUrl)
 
 	valClient := ValidationClient{
 		environment:    environment,
 		pipelineClient: &pipelines.ClientImpl{Client: *pClient},
+		pageSize:       DefaultPageSize,
+		concurrency:    DefaultConcurrency,
+	}
+
+	for _, opt := range opts {
+		if err := opt(&valClient); err != nil {
+			return nil, fmt.Errorf("NewValidationClient: %w", err)
+		}
 	}
 
-	return &valClient
+	return &valClient, nil
 }
 
 type ValidationClientOpt func(*ValidationClient) error
@@ -40,91 +63,239 @@ func WithPipelineClient(client *azuredevops.Client) ValidationClientOpt {
 	}
 }
 
+// WithConcurrency sets the maximum number of pipelines validated at the same time
+func WithConcurrency(concurrency int) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		if concurrency <= 0 {
+			return fmt.Errorf("WithConcurrency: concurrency must be positive, got %d", concurrency)
+		}
+		c.concurrency = concurrency
+		return nil
+	}
+}
+
+// WithPipelineFolder limits pipeline discovery to a pipeline folder, for example \team\ci
+func WithPipelineFolder(folder string) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		c.pipelineFolder = folder
+		return nil
+	}
+}
+
+// WithFileFilter limits the changed files that are validated, including templates, to those the filter selects
+func WithFileFilter(filter FileFilter) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		c.fileFilter = filter
+		return nil
+	}
+}
+
+// WithPageSize sets the number of items requested per page when listing pipelines
+func WithPageSize(pageSize int) ValidationClientOpt {
+	return func(c *ValidationClient) error {
+		if pageSize <= 0 {
+			return fmt.Errorf("WithPageSize: page size must be positive, got %d", pageSize)
+		}
+		c.pageSize = pageSize
+		return nil
+	}
+}
+
 type ValidationResult struct {
+	pipelineId   int
 	pipelinePath string
-	err          error
+	// parameters is the combination of runtime parameter values validated, nil unless validating a parameter matrix
+	parameters  map[string]string
+	err         error
+	diagnostics []Diagnostic
+	duration    time.Duration
+	// finalYaml is the fully expanded pipeline returned by the Preview API, empty if validation failed
+	finalYaml string
 }
 
-// validatePipelineInPR validates a single pipeline with the given ID
+// validatePipelineInPR validates a single pipeline with the given ID on the run branch
 func (c ValidationClient) validatePipelineInPR(ctx context.Context, pipeline Pipeline) (ValidationResult, error) {
-	args := c.newPreviewPipelineArgs(pipeline.Id)
+	return c.validatePipelineOnBranch(ctx, pipeline, c.environment.runBranch, c.sourcesFS())
+}
 
-	// TODO: handle error in case where call does not go through
-	_, err := c.callPreviewApi(ctx, args)
+// validatePipelineOnBranch validates a single pipeline with the files of the given branch. Templates validated through a
+// host pipeline are sent in a wrapper pipeline as a YAML override, which needs the sources to determine the template
+// type.
+func (c ValidationClient) validatePipelineOnBranch(ctx context.Context, pipeline Pipeline, branch string, sources fs.FS) (ValidationResult, error) {
 	result := ValidationResult{
+		pipelineId:   pipeline.Id,
 		pipelinePath: pipeline.FilePath,
 	}
-	if err != nil {
-		result.err = err
+
+	var opts []previewPipelineArgOpt
+	if pipeline.host != nil {
+		wrapper, err := c.templateWrapper(pipeline, sources, branch)
+		if err != nil {
+			return result, err
+		}
+		opts = append(opts, withYamlOverride(wrapper))
+	} else {
+		opts = append(opts, withRefName(branch))
 	}
+	args := c.newPreviewPipelineArgs(pipeline, opts...)
+
+	start := time.Now()
+	run, err := c.callPreviewApi(ctx, args)
+	result.duration = time.Since(start)
+	result.setPreviewRun(run, err)
 
 	return result, nil
 }
 
+// setPreviewRun stores the outcome of a Preview call in the result: the expanded YAML if the call succeeded, otherwise
+// the error
+func (r *ValidationResult) setPreviewRun(run *PreviewRun, err error) {
+	if err == nil && run != nil && run.FinalYaml != nil {
+		r.finalYaml = *run.FinalYaml
+	}
+	r.setPreviewError(err)
+}
+
+// setPreviewError stores the error of a Preview call in the result. Validation errors are split into diagnostics,
+// while failed calls are kept as the result's error.
+func (r *ValidationResult) setPreviewError(err error) {
+	if err == nil {
+		return
+	}
+
+	if diagnostics, ok := parsePreviewError(err, normalizePipelinePath(r.pipelinePath)); ok {
+		r.diagnostics = append(r.diagnostics, diagnostics...)
+		return
+	}
+	r.err = err
+}
+
 type Pipeline struct {
 	FilePath string
 	Id       int
+	// RepositoryId is the ID of the repository the pipeline's YAML lives in, empty for template host pipelines
+	RepositoryId string
+	// RepositoryType is the type of that repository, TfsGit for Azure Repos
+	RepositoryType string
+	// host is set when the pipeline with the ID is a host pipeline validating the template at FilePath
+	host *TemplateHost
+	// parameters is the combination of runtime parameter values to validate the pipeline with, if any
+	parameters map[string]string
+	// declaredParameters are the names of the runtime parameters the pipeline declares, nil if they are unknown
+	declaredParameters map[string]bool
 }
 
 // ValidateAllPrChanges validates all pipelines that have changed in the given pull request
-func (c ValidationClient) ValidateAllPrChanges(ctx context.Context) []error {
-	errs := make([]error, 0)
+func (c ValidationClient) ValidateAllPrChanges(ctx context.Context) (*Report, error) {
+	if c.baseline && c.environment.targetBranch == "" {
+		return nil, fmt.Errorf("ValidateAllChanges: target branch of the pull request is not set, which the baseline is validated on")
+	}
+
+	changedPipelines, removedFileResults, err := c.getPrChangedPipelines(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ValidateAllChanges: %w", err)
+	}
+
+	report := c.validatePipelines(ctx, changedPipelines, c.validatePipelineInPR)
+	report.addResults(removedFileResults)
+	report.annotateDiagnostics(c.sourcesFS())
+	if c.baseline {
+		report.ApplyBaseline(c.validatePipelinesOnBranch(ctx, changedPipelines, c.environment.targetBranch, c.sourcesFS()))
+	}
+
+	return report, nil
+}
+
+// sourcesFS returns the checkout of the repository in a PR build, or nil if there is none
+func (c ValidationClient) sourcesFS() fs.FS {
+	if c.environment.sourcesDirectory == "" {
+		return nil
+	}
+
+	return os.DirFS(c.environment.sourcesDirectory)
+}
+
+// getPrChangedPipelines returns the pipelines affected by the changes of the pull request, one per parameter
+// combination when validating a parameter matrix, and the results of the pipelines that still use YAML files the pull
+// request deletes or renames
+func (c ValidationClient) getPrChangedPipelines(ctx context.Context) ([]Pipeline, []ValidationResult, error) {
 	if c.environment.pullRequestId == 0 {
-		return append(errs, fmt.Errorf("ValidateAllChanges: pull request ID is not set"))
+		return nil, nil, fmt.Errorf("getPrChangedPipelines: pull request ID is not set")
 	}
 
-	changes, err := c.getPullRequestChangedYamlFiles(c.environment.pullRequestId)
+	changes, removed, err := c.getPullRequestChangedYamlFiles(c.environment.pullRequestId)
 	if err != nil {
-		return append(errs, fmt.Errorf("ValidateAllChanges: failed to get changed files: %w", err))
+		return nil, nil, fmt.Errorf("getPrChangedPipelines: failed to get changed files: %w", err)
 	}
-	changedPipelines, err := c.getChangedPipelines(ctx, changes)
+	sources := c.sourcesFS()
+	if sources == nil {
+		log.Printf("getPrChangedPipelines: no sources directory available, template changes will not be detected")
+	}
+	repo, err := c.getRepositoryPipelineGraph(ctx, sources)
 	if err != nil {
-		return append(errs, fmt.Errorf("ValidateAllChanges: failed to get changed pipelines: %w", err))
-	}
-
-	results := make(chan ValidationResult)
-	for i := range changedPipelines {
-		go func(pipeline Pipeline) {
-			result, err := c.validatePipelineInPR(ctx, pipeline)
-			if err != nil {
-				log.Printf("ValidateAllChanges: failed to validate pipeline %s: %v", pipeline, err)
-			}
-
-			for {
-				select {
-				case results <- result:
-					return
-				case <-ctx.Done():
-					return
-				}
-			}
-
-		}(changedPipelines[i])
-	}
-
-	for result := range results {
-		if result.err != nil {
-			errs = append(errs, result.err)
-			fmt.Printf("pipeline %s failed validation: %s", result.pipelinePath, result.err)
-		} else {
-			fmt.Printf("pipeline %s passed validation", result.pipelinePath)
+		return nil, nil, fmt.Errorf("getPrChangedPipelines: %w", err)
+	}
+
+	changedPipelines := c.getChangedPipelines(repo, changes)
+	removedFileResults := c.checkRemovedFiles(repo, removed, sources)
+
+	return c.expandPipelineParameters(changedPipelines, sources), removedFileResults, nil
+}
+
+// validatePipelines validates the given pipelines with the given function, using at most the configured number of
+// concurrent validations, and collects the results
+func (c ValidationClient) validatePipelines(ctx context.Context, pipes []Pipeline, validate func(context.Context, Pipeline) (ValidationResult, error)) *Report {
+	collected := make([]ValidationResult, 0, len(pipes))
+	for r := range runPool(ctx, c.concurrency, pipes, validate) {
+		result := r.value
+		if r.err != nil && result.err == nil {
+			result.err = r.err
 		}
+		// Results of failed or cancelled validations may be empty, so they are always tied back to their pipeline
+		result.pipelineId = r.item.Id
+		result.pipelinePath = r.item.FilePath
+		result.parameters = r.item.parameters
+		collected = append(collected, result)
 	}
 
-	return errs
+	return newReport(collected)
+}
+
+// PreviewParameters is the request body of the Preview call: https://learn.microsoft.com/en-us/rest/api/azure/devops/pipelines/preview/preview?view=azure-devops-rest-7.0#request-body
+type PreviewParameters struct {
+	// Resources selects the versions of the resources the pipeline consumes
+	Resources *PreviewResources `json:"resources,omitempty"`
+	// TemplateParameters are the runtime parameter values, objects given as YAML
+	TemplateParameters *map[string]string `json:"templateParameters,omitempty"`
+	// Variables are the variable values. Only variables that are settable at queue time can be set.
+	Variables *map[string]pipelines.Variable `json:"variables,omitempty"`
+	// StagesToSkip are the names of the stages to leave out of the run
+	StagesToSkip *[]string `json:"stagesToSkip,omitempty"`
+	// PreviewRun validates and expands the pipeline without queuing a run
+	PreviewRun *bool `json:"previewRun,omitempty"`
+	// YamlOverride replaces the pipeline's root YAML file
+	YamlOverride *string `json:"yamlOverride,omitempty"`
+}
+
+// PreviewResources are the resources of a Preview call, keyed by resource alias. The SDK's RunResourcesParameters only
+// covers repositories.
+type PreviewResources struct {
+	Builds       *map[string]ResourceVersion                        `json:"builds,omitempty"`
+	Containers   *map[string]ResourceVersion                        `json:"containers,omitempty"`
+	Packages     *map[string]ResourceVersion                        `json:"packages,omitempty"`
+	Pipelines    *map[string]ResourceVersion                        `json:"pipelines,omitempty"`
+	Repositories *map[string]pipelines.RepositoryResourceParameters `json:"repositories,omitempty"`
 }
 
-// previewParameters are the Body parameters for the Preview call: https://learn.microsoft.com/en-us/rest/api/azure/devops/pipelines/preview/preview?view=azure-devops-rest-7.0#request-body
-type previewParameters struct {
-	resources    *pipelines.RunResourcesParameters
-	previewRun   *bool
-	yamlOverride *string
+// ResourceVersion selects the version of a build, container, package or pipeline resource
+type ResourceVersion struct {
+	Version *string `json:"version,omitempty"`
 }
 
 // Arguments for the callValidationApi function
 type previewPipelineArgs struct {
 	// (required) Body parameters for the Preview call: https://learn.microsoft.com/en-us/rest/api/azure/devops/pipelines/preview/preview?view=azure-devops-rest-7.0#request-body
-	PreviewParameters *previewParameters
+	PreviewParameters *PreviewParameters
 	// (required) Project ID or project name
 	Project *string
 	// (required) The pipeline id
@@ -133,27 +304,36 @@ type previewPipelineArgs struct {
 	PipelineVersion *int
 }
 
-func (c ValidationClient) newPreviewPipelineArgs(pipelineId int, opts ...previewPipelineArgOpt) previewPipelineArgs {
+// newPreviewPipelineArgs creates the Preview arguments for a pipeline, including the parameter and variable values
+// configured for it
+func (c ValidationClient) newPreviewPipelineArgs(pipeline Pipeline, opts ...previewPipelineArgOpt) previewPipelineArgs {
 	repoMap := make(map[string]pipelines.RepositoryResourceParameters)
-	repoMap["self"] = pipelines.RepositoryResourceParameters{
-		RefName: Pointer(c.environment.runBranch),
-	}
-
-	runResourceParams := pipelines.RunResourcesParameters{
-		Repositories: &repoMap,
+	// A host pipeline can live in another repository, where the branch doesn't exist. Its wrapper pins the template to
+	// the branch with a repository resource instead.
+	if pipeline.host == nil {
+		repoMap["self"] = pipelines.RepositoryResourceParameters{
+			RefName: Pointer(toRefName(c.environment.runBranch)),
+		}
 	}
 
-	previewParams := previewParameters{
-		resources:  &runResourceParams,
-		previewRun: Pointer(true),
+	previewParams := PreviewParameters{
+		Resources: &PreviewResources{
+			Repositories: &repoMap,
+		},
+		PreviewRun: Pointer(true),
 	}
 
 	args := previewPipelineArgs{
-		PipelineId:        Pointer(pipelineId),
+		PipelineId:        Pointer(pipeline.Id),
 		PreviewParameters: &previewParams,
 		Project:           Pointer(c.environment.project),
 	}
 
+	values := c.previewValuesFor(pipeline)
+	for name, value := range pipeline.parameters {
+		values.parameters[name] = value
+	}
+	withPreviewValues(values)(&args)
 	for _, opt := range opts {
 		opt(&args)
 	}
@@ -165,7 +345,16 @@ type previewPipelineArgOpt func(*previewPipelineArgs)
 
 func withYamlOverride(yamlOverride string) previewPipelineArgOpt {
 	return func(args *previewPipelineArgs) {
-		args.PreviewParameters.yamlOverride = Pointer(yamlOverride)
+		args.PreviewParameters.YamlOverride = Pointer(yamlOverride)
+	}
+}
+
+// withRefName previews the pipeline with the files of the given branch instead of the run branch
+func withRefName(branch string) previewPipelineArgOpt {
+	return func(args *previewPipelineArgs) {
+		(*args.PreviewParameters.Resources.Repositories)["self"] = pipelines.RepositoryResourceParameters{
+			RefName: Pointer(toRefName(branch)),
+		}
 	}
 }
 
@@ -173,34 +362,31 @@ type PreviewRun struct {
 	FinalYaml *string `json:"finalYaml,omitempty"`
 }
 
+// callPreviewApi calls the Preview API. The request is created by the SDK client but sent with the environment's HTTP
+// client, so it is retried when throttled, which is safe as previews do not queue runs.
 func (c ValidationClient) callPreviewApi(ctx context.Context, args previewPipelineArgs) (*PreviewRun, error) {
-	routeValues := make(map[string]string)
 	if args.Project == nil || *args.Project == "" {
 		return nil, &azuredevops.ArgumentNilOrEmptyError{ArgumentName: "args.Project"}
 	}
-	routeValues["project"] = *args.Project
 	if args.PipelineId == nil {
 		return nil, &azuredevops.ArgumentNilError{ArgumentName: "args.PipelineId"}
 	}
-	routeValues["pipelineId"] = strconv.Itoa(*args.PipelineId)
 
 	queryParams := url.Values{}
 	if args.PipelineVersion != nil {
 		queryParams.Add("pipelineVersion", strconv.Itoa(*args.PipelineVersion))
 	}
-	body, marshalErr := json.Marshal(*args.PreviewParameters)
-	if marshalErr != nil {
-		return nil, marshalErr
+	previewUrl := fmt.Sprintf("%s/%s/_apis/pipelines/%d/preview", strings.TrimRight(c.environment.organizationUrl, "/"), url.PathEscape(*args.Project), *args.PipelineId)
+	if len(queryParams) > 0 {
+		previewUrl += "?" + queryParams.Encode()
 	}
-	locationId, _ := uuid.Parse("53df2d18-29ea-46a9-bee0-933540f80abf")
-	resp, err := c.pipelineClient.Client.Send(ctx, http.MethodPost, locationId, "7.0", routeValues, queryParams, bytes.NewReader(body), "application/json", "application/json", nil)
-	if err != nil {
+
+	var responseValue PreviewRun
+	if err := c.environment.sendRequest(withRetryable(ctx), &c.pipelineClient.Client, http.MethodPost, previewUrl, *args.PreviewParameters, &responseValue); err != nil {
 		return nil, err
 	}
 
-	var responseValue PreviewRun
-	err = c.pipelineClient.Client.UnmarshalBody(resp, &responseValue)
-	return &responseValue, err
+	return &responseValue, nil
 }
 
 // PR Case:
@@ -208,4 +394,4 @@ func (c ValidationClient) callPreviewApi(ctx context.Context, args previewPipeli
 // Get changed .yaml files from PR
 // Get all pipelines in project, filter for pipelines that directly use those yaml files OR use the file as a template
 // for each file, call the validation api.
-// As this is a PR, we do not need to overwrite the yaml, as the changes are already in the repository.
\ No newline at end of file
+// As this is a PR, we do not need to overwrite the yaml, as the changes are already in the repository.
@@ -0,0 +1,149 @@
+package ado
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPreviewParametersPayload(t *testing.T) {
+	c := ValidationClient{
+		environment: &AzureDevOpsEnvironment{
+			project:   "project",
+			runBranch: "feature/validate",
+		},
+		previewValues: previewValues{
+			parameters: map[string]string{"environment": "dev"},
+			variables:  map[string]string{"verbose": "true"},
+		},
+	}
+	err := WithStagesToSkip([]string{"deploy"})(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := c.newPreviewPipelineArgs(Pipeline{Id: 7, FilePath: "/azure-pipelines.yml"}, withYamlOverride("steps: []\n"))
+	body, err := json.Marshal(*args.PreviewParameters)
+	if err != nil {
+		t.Fatalf("failed to marshal preview parameters: %v", err)
+	}
+
+	var payload struct {
+		Resources struct {
+			Repositories map[string]struct {
+				RefName string `json:"refName"`
+			} `json:"repositories"`
+		} `json:"resources"`
+		YamlOverride       *string                      `json:"yamlOverride"`
+		PreviewRun         *bool                        `json:"previewRun"`
+		TemplateParameters map[string]string            `json:"templateParameters"`
+		Variables          map[string]map[string]string `json:"variables"`
+		StagesToSkip       []string                     `json:"stagesToSkip"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("failed to unmarshal payload %s: %v", body, err)
+	}
+
+	if got := payload.Resources.Repositories["self"].RefName; got != "refs/heads/feature/validate" {
+		t.Errorf("resources.repositories.self.refName = %q, want refs/heads/feature/validate in %s", got, body)
+	}
+	if payload.YamlOverride == nil || *payload.YamlOverride != "steps: []\n" {
+		t.Errorf("yamlOverride = %v, want the override in %s", payload.YamlOverride, body)
+	}
+	if payload.PreviewRun == nil || !*payload.PreviewRun {
+		t.Errorf("previewRun = %v, want true in %s", payload.PreviewRun, body)
+	}
+	if got := payload.TemplateParameters["environment"]; got != "dev" {
+		t.Errorf("templateParameters.environment = %q, want dev in %s", got, body)
+	}
+	if got := payload.Variables["verbose"]["value"]; got != "true" {
+		t.Errorf("variables.verbose.value = %q, want true in %s", got, body)
+	}
+	if len(payload.StagesToSkip) != 1 || payload.StagesToSkip[0] != "deploy" {
+		t.Errorf("stagesToSkip = %v, want [deploy] in %s", payload.StagesToSkip, body)
+	}
+}
+
+func TestPreviewParametersPayloadOmitsUnset(t *testing.T) {
+	c := ValidationClient{
+		environment: &AzureDevOpsEnvironment{
+			project:   "project",
+			runBranch: "refs/pull/1/merge",
+		},
+	}
+
+	args := c.newPreviewPipelineArgs(Pipeline{Id: 7, FilePath: "/azure-pipelines.yml"})
+	body, err := json.Marshal(*args.PreviewParameters)
+	if err != nil {
+		t.Fatalf("failed to marshal preview parameters: %v", err)
+	}
+
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("failed to unmarshal payload %s: %v", body, err)
+	}
+	for _, key := range []string{"yamlOverride", "templateParameters", "variables", "stagesToSkip"} {
+		if _, ok := payload[key]; ok {
+			t.Errorf("%s is set in %s, want it omitted", key, body)
+		}
+	}
+	for _, key := range []string{"resources", "previewRun"} {
+		if _, ok := payload[key]; !ok {
+			t.Errorf("%s is missing in %s", key, body)
+		}
+	}
+}
+
+func TestPreviewParametersPayloadTemplateHost(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/project/_apis/pipelines/5/preview" {
+			t.Errorf("got request for %s, want the preview of the host pipeline", r.URL.Path)
+		}
+		body, _ = io.ReadAll(r.Body)
+		_ = json.NewEncoder(w).Encode(map[string]string{"finalYaml": "steps: []\n"})
+	}))
+	defer server.Close()
+
+	c, err := NewValidationClient(context.Background(), &AzureDevOpsEnvironment{
+		connection:      NewOauthConnection(server.URL, "token"),
+		organizationUrl: server.URL,
+		project:         "project",
+		repositoryName:  "repo",
+		runBranch:       "refs/pull/1/merge",
+		httpClient:      http.DefaultClient,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The host pipeline lives in another repository, which has no pull request merge branch
+	host := &TemplateHost{Templates: []string{"/templates/**"}, PipelineId: 5}
+	sources := fstest.MapFS{"templates/steps.yml": {Data: []byte("steps:\n- script: echo\n")}}
+
+	result, err := c.validatePipelineOnBranch(context.Background(), Pipeline{Id: 5, FilePath: "/templates/steps.yml", host: host}, c.environment.runBranch, sources)
+	if err != nil || result.err != nil {
+		t.Fatalf("validatePipelineOnBranch failed: %v, %v", err, result.err)
+	}
+
+	var payload struct {
+		Resources struct {
+			Repositories map[string]json.RawMessage `json:"repositories"`
+		} `json:"resources"`
+		YamlOverride string `json:"yamlOverride"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("failed to unmarshal payload %s: %v", body, err)
+	}
+
+	if _, ok := payload.Resources.Repositories["self"]; ok {
+		t.Errorf("resources.repositories.self is set in %s, want the host repository on its default branch", body)
+	}
+	if !strings.Contains(payload.YamlOverride, "ref: refs/pull/1/merge") || !strings.Contains(payload.YamlOverride, "template: /templates/steps.yml@validated") {
+		t.Errorf("yamlOverride = %q, want the template from the validated repository on the pull request branch", payload.YamlOverride)
+	}
+}
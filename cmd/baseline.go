@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/drbushytop/ado-yaml-validator/ado"
+	"github.com/spf13/cobra"
+	"io/fs"
+)
+
+// baselineCmd represents the baseline command
+var baselineCmd = &cobra.Command{
+	Use:   "baseline [pipeline files...]",
+	Short: "Write the current diagnostics of pipelines to the baseline file",
+	Long: `This command validates pipelines with the local files like the root command and writes their diagnostics to the
+baseline file. Diagnostics in the baseline file are not reported until they change, which allows adopting strict
+validation one pipeline at a time. Pipelines are selected by their root files, otherwise all changed pipelines are
+validated, or with --all every pipeline whose root file is in the repository. Findings of pipelines that are not
+validated are kept in the baseline file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		env, err := newLocalEnvironment(cmd)
+		if err != nil {
+			return err
+		}
+
+		cmd.SilenceUsage = true
+
+		client, err := newValidationClient(cmd, env)
+		if err != nil {
+			return err
+		}
+
+		var report *ado.Report
+		if all, _ := cmd.Flags().GetBool("all"); all {
+			report, err = client.ValidateAllLocalPipelines(cmd.Context())
+		} else if len(args) > 0 {
+			report, err = client.ValidateLocalPipelines(cmd.Context(), args)
+		} else {
+			report, err = client.ValidateAllLocalChanges(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+		// Diagnostics of rules that are turned off are not reported, so they are not part of the baseline either
+		report.ApplyRuleSeverities(config.Rules)
+
+		file, _ := baselineFilePath(cmd)
+		previous, err := ado.LoadBaselineFile(file)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		baseline := ado.NewBaselineFile(report, previous)
+		if err := baseline.Save(file); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "wrote %d finding(s) to %s\n", len(baseline.Findings), file)
+
+		// Failed calls have no diagnostics to record, so the baseline of these pipelines is incomplete
+		failed := 0
+		for _, result := range report.Results {
+			if result.Error != "" {
+				failed++
+				fmt.Fprintf(cmd.ErrOrStderr(), "pipeline %s could not be validated: %s\n", result.Name(), result.Error)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d pipeline(s) could not be validated", failed)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(baselineCmd)
+
+	addLocalFlags(baselineCmd)
+	baselineCmd.Flags().Bool("all", false, "Validate every pipeline of the project whose root file is in the repository instead of only the changed ones.")
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/drbushytop/ado-yaml-validator/ado"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [pipeline files...]",
+	Short: "Show how the local changes change the expanded YAML of pipelines",
+	Long: `This command expands pipelines with the Azure DevOps Preview API twice, once with the local files like the root
+command and once on the branch given with --branch, and prints a unified diff of the final YAML of each pipeline
+followed by a summary of the pipelines whose expansion changed. Pipelines are selected by their root files, otherwise
+all changed pipelines are compared.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		env, err := newLocalEnvironment(cmd)
+		if err != nil {
+			return err
+		}
+
+		cmd.SilenceUsage = true
+
+		client, err := newValidationClient(cmd, env)
+		if err != nil {
+			return err
+		}
+
+		var diff *ado.DiffReport
+		if len(args) > 0 {
+			diff, err = client.DiffLocalPipelines(cmd.Context(), args)
+		} else {
+			diff, err = client.DiffAllLocalChanges(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := renderDiffReport(cmd, diff, ""); err != nil {
+			return err
+		}
+
+		if failed := diff.Count(ado.DiffFailed); failed > 0 {
+			return fmt.Errorf("%d pipeline(s) could not be expanded", failed)
+		}
+
+		return nil
+	},
+}
+
+// renderDiffReport writes the diff report to the given file, or standard output if it is empty or -
+func renderDiffReport(cmd *cobra.Command, diff *ado.DiffReport, file string) error {
+	out := cmd.OutOrStdout()
+	if file != "" && file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return fmt.Errorf("failed to create diff file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := diff.Render(out); err != nil {
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	addLocalFlags(diffCmd)
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/drbushytop/ado-yaml-validator/ado"
+	"github.com/spf13/cobra"
+)
+
+// expandCmd represents the expand command
+var expandCmd = &cobra.Command{
+	Use:   "expand [pipeline files...]",
+	Short: "Print the fully expanded YAML of pipelines",
+	Long: `This command expands pipelines with the Azure DevOps Preview API, using the local files like the root command, and
+prints the final YAML with all templates resolved. Pipelines are selected by their root files, otherwise all changed
+pipelines are expanded. With --save-expanded, the YAML is written to one file per pipeline instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		env, err := newLocalEnvironment(cmd)
+		if err != nil {
+			return err
+		}
+
+		cmd.SilenceUsage = true
+
+		client, err := newValidationClient(cmd, env)
+		if err != nil {
+			return err
+		}
+
+		var report *ado.Report
+		if len(args) > 0 {
+			report, err = client.ValidateLocalPipelines(cmd.Context(), args)
+		} else {
+			report, err = client.ValidateAllLocalChanges(cmd.Context())
+		}
+		if err != nil {
+			return err
+		}
+
+		if dir := cmd.Flag("save-expanded").Value.String(); dir != "" {
+			if err := report.SaveExpanded(dir); err != nil {
+				return err
+			}
+		} else if err := report.RenderExpanded(cmd.OutOrStdout()); err != nil {
+			return err
+		}
+
+		// Pipelines that could not be expanded are reported with the reason
+		if failed := report.Failed(); failed > 0 {
+			if err := report.Render(cmd.ErrOrStderr(), ado.OutputText); err != nil {
+				return err
+			}
+			return fmt.Errorf("%d pipeline(s) could not be expanded", failed)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(expandCmd)
+
+	addLocalFlags(expandCmd)
+}
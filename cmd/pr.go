@@ -4,7 +4,7 @@ Copyright © 2023 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-	"context"
+	"errors"
 	"github.com/drbushytop/ado-yaml-validator/ado"
 	"github.com/spf13/cobra"
 )
@@ -13,20 +13,68 @@ import (
 var prCmd = &cobra.Command{
 	Use:   "pr",
 	Short: "Trigger PR mode",
-	Long:  `This command triggers the tool in PR mode. It will validate all YAML files in the PR. It will attempt to use the System.AccessToken variable.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long:  `This command triggers the tool in PR mode. It will validate all YAML files in the PR. It authenticates with the System.AccessToken variable, which must be mapped to the SYSTEM_ACCESSTOKEN environment variable.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
 
-		env, err := ado.NewAzureDevOpsEnvironmentFromPR()
+		env, err := ado.NewAzureDevOpsEnvironmentFromPR(cmd.Context(), newRetryTransport(cmd))
 		if err != nil {
-			panic(err)
+			return err
 		}
 
-		client := ado.NewValidationClient(context.Background(), env)
+		client, err := newValidationClient(cmd, env)
+		if err != nil {
+			return err
+		}
+
+		var report *ado.Report
+		if diffFile := cmd.Flag("diff-file").Value.String(); diffFile != "" {
+			// The pipelines are validated with the changes as part of the diff, so they are not validated again
+			diff, err := client.DiffAllPrChanges(cmd.Context())
+			if err != nil {
+				return err
+			}
+			if err := renderDiffReport(cmd, diff, diffFile); err != nil {
+				return err
+			}
+			report = diff.SourceReport()
+		} else {
+			report, err = client.ValidateAllPrChanges(cmd.Context())
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := applyReportConfig(cmd, report); err != nil {
+			return err
+		}
 
-		client.ValidateAllPrChanges(context.Background())
+		// A failed publish must not keep the report from being written, so its errors are returned afterwards
+		var publishErrs []error
+		if comment, _ := cmd.Flags().GetBool("comment"); comment {
+			if err := client.PublishPullRequestComment(cmd.Context(), report); err != nil {
+				publishErrs = append(publishErrs, err)
+			}
+		}
+
+		if status, _ := cmd.Flags().GetBool("status"); status {
+			genre := cmd.Flag("status-genre").Value.String()
+			name := cmd.Flag("status-name").Value.String()
+			if err := client.PublishPullRequestStatus(cmd.Context(), report, genre, name); err != nil {
+				publishErrs = append(publishErrs, err)
+			}
+		}
+
+		return errors.Join(append([]error{writeReport(cmd, report)}, publishErrs...)...)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(prCmd)
-}
\ No newline at end of file
+
+	prCmd.Flags().Bool("comment", false, "Create or update a comment thread on the pull request summarizing the validation results.")
+	prCmd.Flags().Bool("status", false, "Publish the validation result as a pull request status, which can be required by a branch policy.")
+	prCmd.Flags().String("status-genre", "ado-yaml-validator", "Genre of the pull request status.")
+	prCmd.Flags().String("status-name", "pipeline-validation", "Name of the pull request status.")
+	prCmd.Flags().String("diff-file", "", "Also expand the changed pipelines on the target branch of the pull request and write a unified diff of the final YAML of each pipeline to this file, or - for standard output.")
+}
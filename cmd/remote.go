@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// scpRemote matches remotes in the scp-like syntax of git, for example git@ssh.dev.azure.com:v3/org/project/repo
+var scpRemote = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.*)$`)
+
+// azureRepo is the Azure DevOps repository a git remote points to
+type azureRepo struct {
+	orgUrl  string
+	project string
+	repo    string
+}
+
+// parseOrgFromGit reads the URL of the given git remote and parses the Azure DevOps repository from it
+func parseOrgFromGit(remote string) (string, string, string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "remote", "get-url", remote)
+	cmd.Stderr = &stderr
+	remoteUrl, err := cmd.Output()
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to get the URL of git remote %s: %s: %w", remote, strings.TrimSpace(stderr.String()), err)
+	}
+
+	repo, err := parseRemoteUrl(string(remoteUrl))
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to parse the URL of git remote %s, use --org, --project and --repo instead: %w", remote, err)
+	}
+
+	return repo.orgUrl, repo.project, repo.repo, nil
+}
+
+// parseRemoteUrl parses the Azure DevOps repository from a git remote URL. The supported forms are:
+//
+//	https://[user@]dev.azure.com/org/project/_git/repo
+//	https://[user@]org.visualstudio.com[/DefaultCollection]/project/_git/repo
+//	https://server[:port]/[tfs/]collection/project/_git/repo
+//	git@ssh.dev.azure.com:v3/org/project/repo
+//	org@vs-ssh.visualstudio.com:v3/org/project/repo
+//	ssh://server[:port]/[tfs/]collection/project/_git/repo
+//
+// The project can be left out of HTTPS URLs when it has the same name as the repository, for example
+// https://dev.azure.com/org/_git/repo. On Azure DevOps Server, a collection URL without a project is only recognized
+// when the collection is directly under the server. Names are URL decoded.
+func parseRemoteUrl(remoteUrl string) (azureRepo, error) {
+	remoteUrl = strings.TrimSpace(remoteUrl)
+
+	var scheme, host, hostPort, remotePath string
+	if match := scpRemote.FindStringSubmatch(remoteUrl); match != nil && !strings.Contains(remoteUrl, "://") {
+		scheme = "ssh"
+		host = match[1]
+		hostPort = host
+		remotePath = match[2]
+	} else {
+		u, err := url.Parse(remoteUrl)
+		if err != nil {
+			return azureRepo{}, fmt.Errorf("parseRemoteUrl: %w", err)
+		}
+		scheme = strings.ToLower(u.Scheme)
+		host = u.Hostname()
+		hostPort = u.Host
+		remotePath = u.EscapedPath()
+	}
+	if host == "" {
+		return azureRepo{}, fmt.Errorf("parseRemoteUrl: %q has no host", remoteUrl)
+	}
+	host = strings.ToLower(host)
+
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(strings.Trim(remotePath, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return azureRepo{}, fmt.Errorf("parseRemoteUrl: invalid path segment %q in %q: %w", segment, remoteUrl, err)
+		}
+		segments = append(segments, decoded)
+	}
+
+	// Azure DevOps Services SSH URLs have the form v3/org/project/repo
+	if scheme == "ssh" && (host == "ssh.dev.azure.com" || strings.HasSuffix(host, "vs-ssh.visualstudio.com")) {
+		if len(segments) != 4 || segments[0] != "v3" {
+			return azureRepo{}, fmt.Errorf("parseRemoteUrl: expected v3/organization/project/repository in %q", remoteUrl)
+		}
+		orgUrl := createOrgUrl(url.PathEscape(segments[1]))
+		if host != "ssh.dev.azure.com" {
+			orgUrl = "https://" + strings.ToLower(segments[1]) + ".visualstudio.com"
+		}
+		return azureRepo{orgUrl: orgUrl, project: segments[2], repo: segments[3]}, nil
+	}
+
+	gitIndex := -1
+	for i, segment := range segments {
+		if segment == "_git" {
+			gitIndex = i
+			break
+		}
+	}
+	if gitIndex < 0 || gitIndex == len(segments)-1 {
+		return azureRepo{}, fmt.Errorf("parseRemoteUrl: %q is not an Azure Repos URL", remoteUrl)
+	}
+
+	// _optimized and _full select how the repository is cloned and are not part of the name
+	repoSegments := segments[gitIndex+1:]
+	if len(repoSegments) == 2 && (repoSegments[0] == "_optimized" || repoSegments[0] == "_full") {
+		repoSegments = repoSegments[1:]
+	}
+	if len(repoSegments) != 1 {
+		return azureRepo{}, fmt.Errorf("parseRemoteUrl: unexpected path after _git in %q", remoteUrl)
+	}
+	repo := repoSegments[0]
+	prefix := segments[:gitIndex]
+
+	// projectOf takes the project from the segments after the organization, defaulting to the repository name
+	projectOf := func(rest []string) (string, error) {
+		switch len(rest) {
+		case 0:
+			return repo, nil
+		case 1:
+			return rest[0], nil
+		default:
+			return "", fmt.Errorf("parseRemoteUrl: unexpected path before _git in %q", remoteUrl)
+		}
+	}
+
+	switch {
+	case host == "dev.azure.com":
+		if len(prefix) == 0 {
+			return azureRepo{}, fmt.Errorf("parseRemoteUrl: %q has no organization", remoteUrl)
+		}
+		project, err := projectOf(prefix[1:])
+		if err != nil {
+			return azureRepo{}, err
+		}
+		return azureRepo{orgUrl: createOrgUrl(url.PathEscape(prefix[0])), project: project, repo: repo}, nil
+	case strings.HasSuffix(host, ".visualstudio.com"):
+		if len(prefix) > 0 && strings.EqualFold(prefix[0], "DefaultCollection") {
+			prefix = prefix[1:]
+		}
+		project, err := projectOf(prefix)
+		if err != nil {
+			return azureRepo{}, err
+		}
+		return azureRepo{orgUrl: "https://" + host, project: project, repo: repo}, nil
+	default:
+		// Azure DevOps Server: the collection URL is everything before the project
+		if len(prefix) == 0 {
+			return azureRepo{}, fmt.Errorf("parseRemoteUrl: %q has no collection", remoteUrl)
+		}
+		project := repo
+		collection := prefix
+		if len(prefix) > 1 {
+			project = prefix[len(prefix)-1]
+			collection = prefix[:len(prefix)-1]
+		}
+
+		escaped := make([]string, 0, len(collection))
+		for _, segment := range collection {
+			escaped = append(escaped, url.PathEscape(segment))
+		}
+		// The SSH port of the server is not its web port
+		if scheme == "ssh" {
+			hostPort = host
+			scheme = "https"
+		}
+
+		return azureRepo{orgUrl: scheme + "://" + hostPort + "/" + strings.Join(escaped, "/"), project: project, repo: repo}, nil
+	}
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func TestParseRemoteUrl(t *testing.T) {
+	tests := []struct {
+		name      string
+		remoteUrl string
+		want      azureRepo
+		wantErr   bool
+	}{
+		{
+			name:      "dev.azure.com over https",
+			remoteUrl: "https://dev.azure.com/org/project/_git/repo",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "project", repo: "repo"},
+		},
+		{
+			name:      "dev.azure.com with user",
+			remoteUrl: "https://user@dev.azure.com/org/project/_git/repo",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "project", repo: "repo"},
+		},
+		{
+			name:      "dev.azure.com without project",
+			remoteUrl: "https://dev.azure.com/org/_git/repo",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "repo", repo: "repo"},
+		},
+		{
+			name:      "encoded project name",
+			remoteUrl: "https://dev.azure.com/org/My%20Project/_git/My%20Repo",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "My Project", repo: "My Repo"},
+		},
+		{
+			name:      "optimized clone",
+			remoteUrl: "https://dev.azure.com/org/project/_git/_optimized/repo",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "project", repo: "repo"},
+		},
+		{
+			name:      "trailing newline",
+			remoteUrl: "https://dev.azure.com/org/project/_git/repo\n",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "project", repo: "repo"},
+		},
+		{
+			name:      "dev.azure.com over ssh",
+			remoteUrl: "git@ssh.dev.azure.com:v3/org/project/repo",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "project", repo: "repo"},
+		},
+		{
+			name:      "encoded project name over ssh",
+			remoteUrl: "git@ssh.dev.azure.com:v3/org/My%20Project/repo\n",
+			want:      azureRepo{orgUrl: "https://dev.azure.com/org", project: "My Project", repo: "repo"},
+		},
+		{
+			name:      "visualstudio.com over https",
+			remoteUrl: "https://org.visualstudio.com/project/_git/repo",
+			want:      azureRepo{orgUrl: "https://org.visualstudio.com", project: "project", repo: "repo"},
+		},
+		{
+			name:      "visualstudio.com with DefaultCollection",
+			remoteUrl: "https://org.visualstudio.com/DefaultCollection/project/_git/repo",
+			want:      azureRepo{orgUrl: "https://org.visualstudio.com", project: "project", repo: "repo"},
+		},
+		{
+			name:      "visualstudio.com over ssh",
+			remoteUrl: "org@vs-ssh.visualstudio.com:v3/org/project/repo",
+			want:      azureRepo{orgUrl: "https://org.visualstudio.com", project: "project", repo: "repo"},
+		},
+		{
+			name:      "Azure DevOps Server over https",
+			remoteUrl: "https://tfs.contoso.com:8080/tfs/DefaultCollection/project/_git/repo",
+			want:      azureRepo{orgUrl: "https://tfs.contoso.com:8080/tfs/DefaultCollection", project: "project", repo: "repo"},
+		},
+		{
+			name:      "Azure DevOps Server over ssh",
+			remoteUrl: "ssh://tfs.contoso.com:22/tfs/DefaultCollection/project/_git/repo",
+			want:      azureRepo{orgUrl: "https://tfs.contoso.com/tfs/DefaultCollection", project: "project", repo: "repo"},
+		},
+		{
+			name:      "Azure DevOps Server collection without project",
+			remoteUrl: "https://tfs.contoso.com/collection/_git/repo",
+			want:      azureRepo{orgUrl: "https://tfs.contoso.com/collection", project: "repo", repo: "repo"},
+		},
+		{
+			name:      "not an Azure Repos URL",
+			remoteUrl: "git@github.com:owner/repo.git",
+			wantErr:   true,
+		},
+		{
+			name:      "ssh without v3",
+			remoteUrl: "git@ssh.dev.azure.com:org/project/repo",
+			wantErr:   true,
+		},
+		{
+			name:      "ssh with missing repository",
+			remoteUrl: "git@ssh.dev.azure.com:v3/org/project",
+			wantErr:   true,
+		},
+		{
+			name:      "no repository after _git",
+			remoteUrl: "https://dev.azure.com/org/project/_git",
+			wantErr:   true,
+		},
+		{
+			name:      "path after repository",
+			remoteUrl: "https://dev.azure.com/org/project/_git/repo/extra",
+			wantErr:   true,
+		},
+		{
+			name:      "path before _git",
+			remoteUrl: "https://dev.azure.com/org/project/extra/_git/repo",
+			wantErr:   true,
+		},
+		{
+			name:      "no organization",
+			remoteUrl: "https://dev.azure.com/_git/repo",
+			wantErr:   true,
+		},
+		{
+			name:      "no collection",
+			remoteUrl: "https://tfs.contoso.com/_git/repo",
+			wantErr:   true,
+		},
+		{
+			name:      "no host",
+			remoteUrl: "/home/user/repo",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRemoteUrl(tt.remoteUrl)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRemoteUrl failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateOrgUrl(t *testing.T) {
+	tests := []struct {
+		org  string
+		want string
+	}{
+		{org: "org", want: "https://dev.azure.com/org"},
+		{org: "https://dev.azure.com/org/", want: "https://dev.azure.com/org"},
+		{org: "https://org.visualstudio.com", want: "https://org.visualstudio.com"},
+		{org: "https://tfs.contoso.com/tfs/DefaultCollection/", want: "https://tfs.contoso.com/tfs/DefaultCollection"},
+	}
+
+	for _, tt := range tests {
+		if got := createOrgUrl(tt.org); got != tt.want {
+			t.Errorf("createOrgUrl(%q) = %q, want %q", tt.org, got, tt.want)
+		}
+	}
+}
@@ -2,12 +2,15 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/drbushytop/ado-yaml-validator/ado"
-	"github.com/microsoft/azure-devops-go-api/azuredevops"
+	"io/fs"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -15,96 +18,426 @@ import (
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:   "ado-yaml-validator",
+	Use:   "ado-yaml-validator [files...]",
 	Short: "A tool to validate Azure Pipelines",
 	Long: `A tool to validate Azure Pipelines.
 
 This tool can be used to validate Azure Pipelines YAML files. The main use is during development of pipelines, but also
 as a check during PRs.
 `,
+	PersistentPreRunE: persistentPreRun,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	RunE: RunRoot,
+	// Files to validate can be given in offline mode
+	Args: cobra.ArbitraryArgs,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	// Interrupting stops outstanding validations instead of leaving them running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
 	if err != nil {
 		log.Printf(err.Error())
 		os.Exit(1)
 	}
 }
 
+// config is the configuration loaded before any command runs
+var config = &ado.Config{}
+
+// configDir is the directory of the configuration file, or the working directory if there is none
+var configDir = "."
+
+// persistentPreRun loads the configuration and checks the HTTP flags shared by all commands
+func persistentPreRun(cmd *cobra.Command, args []string) error {
+	if err := loadConfig(cmd); err != nil {
+		// A broken configuration file is not a usage error
+		cmd.SilenceUsage = true
+		return err
+	}
+
+	maxAttempts, _ := cmd.Flags().GetInt("max-attempts")
+	if maxAttempts <= 0 {
+		return fmt.Errorf("--max-attempts must be positive, got %d", maxAttempts)
+	}
+
+	return nil
+}
+
+// newRetryTransport creates the transport for the REST calls to Azure DevOps, retrying throttled and failed requests.
+// The auth transport wraps it, so requests retried with a refreshed token after 401 Unauthorized are retried on
+// throttling as well.
+func newRetryTransport(cmd *cobra.Command) http.RoundTripper {
+	maxAttempts, _ := cmd.Flags().GetInt("max-attempts")
+	options := ado.DefaultRetryOptions()
+	options.MaxAttempts = maxAttempts
+
+	return ado.NewRetryTransport(http.DefaultTransport, options)
+}
+
 func RunRoot(cmd *cobra.Command, args []string) error {
 	// Local Case:
 	// Take in project, organization, branch, auth token, branch to compare to (defaulting to master) from user
 	// Get changed .yaml files from git diff?
 	// Get all pipelines in project, filter for pipelines that directly use those yaml files (Later: OR use the file as a template)
-	// If no pipelines are found (this file is a template), just select the first one returned for the validation call (we override the contents)
+	// If no pipelines are found (this file is a template), use the template host from the configuration file for the validation call (we override the contents)
 	// for each file, call the validation api, using the yamloverride by parsing local yaml.
 
-	org := cmd.Flag("org").Value.String()
-	project := cmd.Flag("project").Value.String()
-	repo := cmd.Flag("repo").Value.String()
+	if offline, _ := cmd.Flags().GetBool("offline"); offline {
+		return runOffline(cmd, args)
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("files can only be given with --offline, got %q", args)
+	}
+
+	env, err := newLocalEnvironment(cmd)
+	if err != nil {
+		return err
+	}
+
+	// Flags are valid at this point, validation failures should not print the usage
+	cmd.SilenceUsage = true
+
+	client, err := newValidationClient(cmd, env)
+	if err != nil {
+		return err
+	}
+
+	report, err := client.ValidateAllLocalChanges(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if err := applyReportConfig(cmd, report); err != nil {
+		return err
+	}
+
+	return writeReport(cmd, report)
+}
+
+// newLocalEnvironment creates the Azure DevOps environment for local validation from the connection flags, the
+// configuration file or the current git repository
+func newLocalEnvironment(cmd *cobra.Command) (*ado.AzureDevOpsEnvironment, error) {
+	org := flagOrConfig(cmd, "org", config.Organization)
+	project := flagOrConfig(cmd, "project", config.Project)
+	repo := flagOrConfig(cmd, "repo", config.Repository)
 
 	var orgUrl string
 
 	if org != "" {
 		// If org is given, project and repo must be given as well
+		if project == "" || repo == "" {
+			return nil, fmt.Errorf("project and repo must be given together with org, either as flags or in the configuration file")
+		}
 		orgUrl = createOrgUrl(org)
+	} else if project != "" || repo != "" {
+		return nil, fmt.Errorf("org must be given together with project and repo, either as flags or in the configuration file")
 	} else {
 		// Parse from current git repo
 		var err error
-		orgUrl, project, repo, err = parseOrgFromGit()
+		remote, _ := cmd.Flags().GetString("remote")
+		orgUrl, project, repo, err = parseOrgFromGit(remote)
 		if err != nil {
+			return nil, err
+		}
+	}
+
+	provider, err := newTokenProvider(cmd, orgUrl)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := ado.NewAuthTransport(cmd.Context(), newRetryTransport(cmd), provider)
+	if err != nil {
+		if cmd.Flags().Lookup("offline") != nil {
+			return nil, fmt.Errorf("failed to get credentials for Azure DevOps, use --offline to only validate against the schema: %w", err)
+		}
+		return nil, fmt.Errorf("failed to get credentials for Azure DevOps: %w", err)
+	}
+
+	branch := cmd.Flag("branch").Value.String()
+	return ado.NewAzureDevOpsEnvironment(auth.Connection(orgUrl), project, branch, repo, ado.WithHTTPClient(&http.Client{Transport: auth}))
+}
+
+// newTokenProvider returns the provider of the token given with --bearer or --pat, otherwise the one selected with
+// --auth
+func newTokenProvider(cmd *cobra.Command, orgUrl string) (ado.TokenProvider, error) {
+	if bearer := cmd.Flag("bearer").Value.String(); bearer != "" {
+		return ado.StaticTokenProvider{Source: "--bearer", Value: ado.Token{Value: bearer}}, nil
+	}
+	if pat := cmd.Flag("pat").Value.String(); pat != "" {
+		return ado.StaticTokenProvider{Source: "--pat", Value: ado.Token{Value: pat, Pat: true}}, nil
+	}
+
+	return ado.NewTokenProvider(cmd.Flag("auth").Value.String(), orgUrl)
+}
+
+// newValidationClient creates a validation client configured by the persistent flags and the configuration file
+func newValidationClient(cmd *cobra.Command, env *ado.AzureDevOpsEnvironment) (*ado.ValidationClient, error) {
+	pageSize, _ := cmd.Flags().GetInt("page-size")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	if !cmd.Flags().Changed("concurrency") && config.Concurrency != 0 {
+		concurrency = config.Concurrency
+	}
+
+	paramFlags, _ := cmd.Flags().GetStringArray("param")
+	parameters, err := parseKeyValues("--param", paramFlags)
+	if err != nil {
+		return nil, err
+	}
+	varFlags, _ := cmd.Flags().GetStringArray("var")
+	variables, err := parseKeyValues("--var", varFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	stagesToSkip, _ := cmd.Flags().GetStringArray("skip-stage")
+
+	opts := []ado.ValidationClientOpt{
+		ado.WithPageSize(pageSize),
+		ado.WithConcurrency(concurrency),
+		ado.WithPipelineFolder(cmd.Flag("pipeline-folder").Value.String()),
+		ado.WithFileFilter(config.FileFilter),
+		ado.WithTemplateHosts(config.TemplateHosts),
+		ado.WithPipelineConfigs(config.Pipelines),
+		ado.WithPreviewValues(parameters, variables),
+		ado.WithStagesToSkip(stagesToSkip),
+	}
+
+	if matrix, _ := cmd.Flags().GetBool("matrix"); matrix {
+		maxCombinations, _ := cmd.Flags().GetInt("matrix-max")
+		strategy, err := ado.ParseMatrixStrategy(cmd.Flag("matrix-strategy").Value.String())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, ado.WithParameterMatrix(ado.MatrixOptions{
+			MaxCombinations: maxCombinations,
+			Strategy:        strategy,
+		}))
+	}
+
+	if baseline, _ := cmd.Flags().GetBool("baseline"); baseline {
+		opts = append(opts, ado.WithBaseline())
+	}
+
+	return ado.NewValidationClient(cmd.Context(), env, opts...)
+}
+
+// parseKeyValues parses flag values of the form name=value
+func parseKeyValues(flag string, values []string) (map[string]string, error) {
+	result := make(map[string]string, len(values))
+	for _, value := range values {
+		name, v, found := strings.Cut(value, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("%s must be given as name=value, got %q", flag, value)
+		}
+		result[name] = v
+	}
+
+	return result, nil
+}
+
+// loadConfig reads the configuration file given with --config, or the one found in the working directory or its
+// parents. The configuration stays empty when there is no configuration file.
+func loadConfig(cmd *cobra.Command) error {
+	configFile := cmd.Flag("config").Value.String()
+	if configFile == "" {
+		var err error
+		if configFile, err = ado.FindConfig("."); err != nil || configFile == "" {
 			return err
 		}
 	}
 
-	bearer := cmd.Flag("bearer").Value.String()
-	pat := cmd.Flag("pat").Value.String()
-	if bearer == "" && pat == "" {
-		// Unable to set mutually exclusive but still required
-		return fmt.Errorf("either the --bearer or --pat argument must be given")
+	loaded, err := ado.LoadConfig(configFile)
+	if err != nil {
+		return err
 	}
+	config = loaded
+	configDir = filepath.Dir(configFile)
 
-	var conn *azuredevops.Connection
-	if bearer != "" {
-		conn = ado.NewOauthConnection(orgUrl, bearer)
-	} else {
-		conn = azuredevops.NewPatConnection(orgUrl, pat)
+	return nil
+}
+
+// flagOrConfig returns the value of a string flag if it was given, otherwise the value from the configuration file
+func flagOrConfig(cmd *cobra.Command, flag string, configValue string) string {
+	if cmd.Flags().Changed(flag) {
+		return cmd.Flag(flag).Value.String()
 	}
 
-	branch := cmd.Flag("branch").Value.String()
-	env, err := ado.NewAzureDevOpsEnvironment(conn, project, branch, repo)
+	return configValue
+}
+
+// runOffline validates the given files, or all locally changed pipeline files if none are given, against the Azure
+// Pipelines schema without calling Azure DevOps
+func runOffline(cmd *cobra.Command, args []string) error {
+	var schema *ado.Schema
+	var err error
+	schemaFile := cmd.Flag("schema").Value.String()
+	partial, _ := cmd.Flags().GetBool("partial-schema")
+	switch {
+	case schemaFile != "" && partial:
+		schema, err = ado.LoadPartialSchema(schemaFile)
+	case schemaFile != "":
+		schema, err = ado.LoadSchema(schemaFile)
+	case partial:
+		return fmt.Errorf("--partial-schema requires --schema")
+	default:
+		schema, err = ado.DefaultSchema()
+	}
 	if err != nil {
 		return err
 	}
 
-	client := ado.NewValidationClient(context.Background(), env)
+	cmd.SilenceUsage = true
 
-	fmt.Printf("%v", *client)
-	//client.ValidateAllChanges(context.Background())
+	var report *ado.Report
+	if len(args) > 0 {
+		report, err = ado.ValidateFilesOffline(schema, args)
+	} else {
+		report, err = ado.ValidateLocalChangesOffline(schema, cmd.Flag("branch").Value.String(), config.FileFilter)
+	}
+	if err != nil {
+		return err
+	}
+	if err := applyReportConfig(cmd, report); err != nil {
+		return err
+	}
+
+	return writeReport(cmd, report)
+}
+
+// baselineFilePath returns the baseline file given by flag or configuration, or the default one next to the
+// configuration file. explicit tells whether the file was given, in which case it must exist.
+func baselineFilePath(cmd *cobra.Command) (file string, explicit bool) {
+	if cmd.Flags().Changed("baseline-file") {
+		return cmd.Flag("baseline-file").Value.String(), true
+	}
+	if config.BaselineFile != "" {
+		return config.BaselineFile, true
+	}
+
+	return filepath.Join(configDir, ado.DefaultBaselineFileName), false
+}
+
+// applyReportConfig suppresses the diagnostics listed in the baseline file and applies the rule severities of the
+// configuration to the report
+func applyReportConfig(cmd *cobra.Command, report *ado.Report) error {
+	file, explicit := baselineFilePath(cmd)
+	baseline, err := ado.LoadBaselineFile(file)
+	switch {
+	case err == nil:
+		report.ApplyBaselineFile(baseline)
+	case explicit || !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	report.ApplyRuleSeverities(config.Rules)
+
+	return nil
+}
+
+// writeReport renders the report in the formats given by the output flags, or the configuration file if no output flag
+// is given, and returns an error if any pipeline failed
+func writeReport(cmd *cobra.Command, report *ado.Report) error {
+	if dir := cmd.Flag("save-expanded").Value.String(); dir != "" {
+		if err := report.SaveExpanded(dir); err != nil {
+			return err
+		}
+	}
+
+	outputs := config.Outputs
+	if len(outputs) == 0 || cmd.Flags().Changed("output") || cmd.Flags().Changed("output-file") {
+		format, err := ado.ParseOutputFormat(cmd.Flag("output").Value.String())
+		if err != nil {
+			return err
+		}
+		outputs = []ado.OutputConfig{{Format: format, File: cmd.Flag("output-file").Value.String()}}
+	}
+
+	for _, output := range outputs {
+		if err := renderReport(cmd, report, output); err != nil {
+			return err
+		}
+	}
+
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("%d pipeline(s) failed validation", failed)
+	}
+
+	return nil
+}
+
+// renderReport writes the report to a single output
+func renderReport(cmd *cobra.Command, report *ado.Report, output ado.OutputConfig) error {
+	out := cmd.OutOrStdout()
+	if output.File != "" {
+		f, err := os.Create(output.File)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := report.Render(out, output.Format); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
 
 	return nil
 }
 
 func init() {
-	rootCmd.Flags().String("bearer", "", "oAuth token for Azure DevOps. Either this or the -pat argument is needed unless the pr command is used.")
-	rootCmd.Flags().String("pat", "", "personal access token. Either this or the -bearer argument needs to be given. unless the pr command is used.")
-	rootCmd.MarkFlagsMutuallyExclusive("bearer", "pat")
+	addLocalFlags(rootCmd)
+
+	rootCmd.Flags().Bool("offline", false, "Validate against the Azure Pipelines schema only, without calling Azure DevOps. No credentials are needed. Files to validate can be given as arguments, otherwise all changed pipeline files are validated. The bundled schema is a subset of the official one, so unknown keys are only reported as warnings. Use --schema with the official schema for full coverage.")
+	rootCmd.Flags().String("schema", "", "Azure Pipelines JSON schema file to use with --offline instead of the bundled subset, for example service-schema.json from https://github.com/microsoft/azure-pipelines-vscode.")
+	rootCmd.Flags().Bool("partial-schema", false, "The --schema file leaves out valid keywords, so unknown keys are only reported as warnings.")
+
+	rootCmd.PersistentFlags().StringP("output", "o", string(ado.OutputText), "Output format of the validation report. One of text, json, junit or sarif.")
+	rootCmd.PersistentFlags().String("output-file", "", "File to write the validation report to. Defaults to standard output.")
+
+	rootCmd.PersistentFlags().String("save-expanded", "", "Directory to write the fully expanded YAML of every pipeline that passed validation to, one file per pipeline.")
+
+	rootCmd.PersistentFlags().String("baseline-file", "", "Baseline file listing known diagnostics, which are not reported until they change. Defaults to "+ado.DefaultBaselineFileName+" next to the configuration file, or in the working directory, if it exists.")
+	rootCmd.PersistentFlags().String("config", "", "Configuration file to use. Defaults to "+ado.DefaultConfigFileName+" in the working directory or its parents if it exists. Flags take precedence over the configuration file.")
+	rootCmd.PersistentFlags().StringArray("param", nil, "Runtime parameter value sent for all validated pipelines that declare the parameter, as name=value. Can be given multiple times. Takes precedence over the parameters in the configuration file.")
+	rootCmd.PersistentFlags().StringArray("var", nil, "Variable value sent for all validated pipelines, as name=value. Can be given multiple times. Only variables settable at queue time can be set.")
+	rootCmd.PersistentFlags().StringArray("skip-stage", nil, "Stage left out of the Preview of all validated pipelines. Can be given multiple times. Stages to skip by pipeline can be set in the configuration file.")
+	rootCmd.PersistentFlags().Bool("matrix", false, "Validate each pipeline once per combination of its boolean parameters and parameters with allowed values, reporting which combinations fail.")
+	rootCmd.PersistentFlags().Int("matrix-max", ado.DefaultMatrixMaxCombinations, "Maximum number of parameter combinations validated per pipeline with --matrix.")
+	rootCmd.PersistentFlags().String("matrix-strategy", string(ado.MatrixPairwise), "How combinations are picked with --matrix when a pipeline has more than --matrix-max. One of pairwise or random.")
+	rootCmd.PersistentFlags().Bool("baseline", false, "Also validate the affected pipelines on the target branch, the pull request's target branch in PR mode and --branch otherwise, and fail only on errors that are not found there.")
+	rootCmd.PersistentFlags().String("pipeline-folder", "", "Only consider pipelines under this pipeline folder, for example \\team\\ci.")
+	rootCmd.PersistentFlags().Int("concurrency", ado.DefaultConcurrency, "Maximum number of pipelines validated at the same time.")
+	rootCmd.PersistentFlags().Int("max-attempts", ado.DefaultRetryOptions().MaxAttempts, "Maximum number of attempts for each request to Azure DevOps when it is throttled or temporarily unavailable.")
+	rootCmd.PersistentFlags().Int("page-size", ado.DefaultPageSize, "Number of pipelines requested per page when listing the pipelines in the project.")
+}
+
+// addLocalFlags adds the flags to connect to Azure DevOps and compare with a branch when validating locally
+func addLocalFlags(cmd *cobra.Command) {
+	cmd.Flags().String("bearer", "", "oAuth token for Azure DevOps. Prefer --auth, as tokens given on the command line end up in the shell history.")
+	cmd.Flags().String("pat", "", "personal access token for Azure DevOps. Prefer --auth, as tokens given on the command line end up in the shell history.")
+	cmd.Flags().String("auth", ado.TokenSourceAuto, "Where to get credentials for Azure DevOps when neither --bearer nor --pat is given. One of "+strings.Join(ado.TokenSources, ", ")+". env reads AZURE_DEVOPS_EXT_PAT or SYSTEM_ACCESSTOKEN, federated exchanges the token in AZURE_FEDERATED_TOKEN_FILE for AZURE_CLIENT_ID in AZURE_TENANT_ID, azure-cli runs az account get-access-token, git asks the git credential helpers and auto tries them in that order.")
+	cmd.MarkFlagsMutuallyExclusive("bearer", "pat")
+
+	cmd.Flags().String("org", "", "Azure DevOps organization name. For example, if the Org URL is https://dev.azure.com/organization, then the organization name is 'organization'. Other organizations, such as https://organization.visualstudio.com or Azure DevOps Server collections like https://server/tfs/collection, are given as full URL. If not given, it is taken from the configuration file or determined from the current git repository.")
+	cmd.Flags().String("project", "", "Azure DevOps project name. If not given, it is taken from the configuration file or determined from the current git repository.")
+	cmd.Flags().String("repo", "", "Azure DevOps repository name. If not given, it is taken from the configuration file or determined from the current git repository.")
 
-	rootCmd.Flags().String("org", "", "Azure DevOps organization name. For example, if the Org URL is https://dev.azure.com/organization, then the organization name is 'organization'. If not given, project will be tried to be determined from the current git repository.")
-	rootCmd.Flags().String("project", "", "Azure DevOps project name. If not given, project will be tried to be determined from the current git repository.")
-	rootCmd.Flags().String("repo", "", "Azure DevOps repository name. If not given, repository will be tried to be determined from the current git repository.")
-	rootCmd.MarkFlagsRequiredTogether("org", "project", "repo")
+	cmd.Flags().String("remote", "origin", "git remote whose URL org, project and repo are determined from when they are not given.")
 
-	rootCmd.Flags().String("branch", "master", "Branch name in the repository to compare against. Defaults to master.")
+	cmd.Flags().String("branch", "master", "Branch name in the repository to compare against. Defaults to master.")
 }
 
+// createOrgUrl returns the URL of an organization on dev.azure.com. Full URLs are returned as is, so organizations on
+// visualstudio.com and Azure DevOps Server collections can be given too.
 func createOrgUrl(org string) string {
+	if strings.Contains(org, "://") {
+		return strings.TrimRight(org, "/")
+	}
+
 	return "https://dev.azure.com/" + org
 }
 
@@ -115,20 +448,3 @@ func createProjectUrl(orgUrl string, project string) string {
 func createRepoUrl(projectUrl string, repo string) string {
 	return projectUrl + "/_git/" + repo
 }
-
-func parseOrgFromGit() (string, string, string, error) {
-	cmd := exec.Command("git", "remote", "get-url", "origin")
-	gitOriginUrl, err := cmd.Output()
-	if err != nil {
-		return "", "", "", fmt.Errorf("error executing command: %s", err)
-	}
-
-	splitUrl := strings.Split(string(gitOriginUrl), "/")
-	if len(splitUrl) < 6 {
-		return "", "", "", fmt.Errorf("error parsing git origin url: %s", gitOriginUrl)
-	}
-
-	orgUrl := createOrgUrl(splitUrl[3])
-
-	return orgUrl, splitUrl[4], splitUrl[6], nil
-}
\ No newline at end of file
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+// newConfigTestCommand returns a command with the local flags that loaded the given configuration file. The
+// previously loaded configuration is restored when the test ends.
+func newConfigTestCommand(t *testing.T, content string) *cobra.Command {
+	t.Helper()
+
+	previousConfig, previousConfigDir := config, configDir
+	t.Cleanup(func() {
+		config, configDir = previousConfig, previousConfigDir
+	})
+
+	configFile := filepath.Join(t.TempDir(), ".ado-yaml-validator.yaml")
+	if err := os.WriteFile(configFile, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &cobra.Command{}
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().String("baseline-file", "", "")
+	addLocalFlags(cmd)
+	if err := cmd.Flags().Set("config", configFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfig(cmd); err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	return cmd
+}
+
+func TestFlagsOverrideConfig(t *testing.T) {
+	cmd := newConfigTestCommand(t, "organization: config-org\nproject: config-project\nrepository: config-repo\n")
+	if err := cmd.Flags().Set("org", "flag-org"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := flagOrConfig(cmd, "org", config.Organization); got != "flag-org" {
+		t.Errorf("got org %q, want the flag value", got)
+	}
+	if got := flagOrConfig(cmd, "project", config.Project); got != "config-project" {
+		t.Errorf("got project %q, want the configuration value", got)
+	}
+	// An empty flag given explicitly still overrides the configuration
+	if err := cmd.Flags().Set("repo", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := flagOrConfig(cmd, "repo", config.Repository); got != "" {
+		t.Errorf("got repo %q, want the empty flag value", got)
+	}
+}
+
+func TestBaselineFilePathFromConfig(t *testing.T) {
+	cmd := newConfigTestCommand(t, "baselineFile: known.yaml\n")
+
+	file, explicit := baselineFilePath(cmd)
+	if want := filepath.Join(configDir, "known.yaml"); file != want || !explicit {
+		t.Errorf("got baseline file %q, %v, want %q from the configuration", file, explicit, want)
+	}
+
+	if err := cmd.Flags().Set("baseline-file", "flag.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if file, explicit := baselineFilePath(cmd); file != "flag.yaml" || !explicit {
+		t.Errorf("got baseline file %q, %v, want the flag value", file, explicit)
+	}
+}
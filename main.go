@@ -13,4 +13,4 @@ func main() {
 	//client := ado.NewValidationClient(context.Background(), env)
 	//
 	//client.ValidateAllPrChanges(context.Background())
-}
\ No newline at end of file
+}